@@ -0,0 +1,222 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"sync"
+)
+
+// Async will call fn in a goroutine when n is signalled,
+// and close the channel it was given when fn returns.
+//
+// This saves writing the goroutine waiting for the notifier:
+//
+//	shutdown.Async(shutdown.First(), func() {
+//	    cleanup()
+//	})
+//
+// If fn panics, the panic is logged and counted, like a panic in
+// a shutdown function, see Summary, and the channel is closed.
+// If n is cancelled, fn is not called.
+// The notifier is returned, so it can be cancelled.
+func Async(n Notifier, fn func()) Notifier {
+	if fn == nil {
+		panic("shutdown: nil shutdown function")
+	}
+	go func() {
+		c := <-n
+		if c == nil {
+			// Cancelled
+			return
+		}
+		defer close(c)
+		defer func() {
+			if r := recover(); r != nil {
+				Logger.Printf("Panic in shutdown function %s: %v", describe(n), r)
+				for _, m := range ownersOf(n) {
+					m.srM.Lock()
+					m.panics++
+					m.srM.Unlock()
+				}
+			}
+		}()
+		fn()
+	}()
+	return n
+}
+
+// TrackGoroutine makes shutdown wait for done to be closed before
+// the Preshutdown stage completes, like a lock, see Lock.
+//
+// This is meant for goroutines that already signal when they have returned
+// with a channel. When done is closed, it is no longer tracked.
+// If shutdown has already started, false is returned and done is not tracked.
+// Like locks, it is waited for until the Preshutdown timeout.
+func TrackGoroutine(done <-chan struct{}) bool {
+	return defaultManager.TrackGoroutine(done)
+}
+
+// TrackGoroutine makes shutdown of the manager wait for done to be closed, like a lock.
+func (m *Manager) TrackGoroutine(done <-chan struct{}) bool {
+	if !m.Lock() {
+		return false
+	}
+	if !m.addWatcher() {
+		// Closed, so the lock isn't tracked, see Disable.
+		return true
+	}
+	go func() {
+		defer m.watchers.Done()
+		select {
+		case <-done:
+			m.Unlock()
+		case <-m.closed:
+		}
+	}()
+	return true
+}
+
+// ShutdownAsync starts shutdown like Shutdown, but runs it on a new goroutine,
+// so the calling goroutine never runs notifiers or functions.
+//
+// It returns a channel that is closed when shutdown has completed.
+// All calls return the same channel, so it can be waited for by several
+// goroutines. OnSignal uses this, so signal handling doesn't run user code.
+func ShutdownAsync() <-chan struct{} {
+	return defaultManager.ShutdownAsync()
+}
+
+// ShutdownAsync starts shutdown of the manager on a new goroutine.
+func (m *Manager) ShutdownAsync() <-chan struct{} {
+	return m.shutdownAsync(Reason{Cause: "ShutdownAsync called"}, nil)
+}
+
+// shutdownAsync runs shutdown followed by then, if not nil, on a new goroutine.
+// It returns a channel that is closed when shutdown has completed.
+func (m *Manager) shutdownAsync(r Reason, then func()) <-chan struct{} {
+	if m.isDisabled() {
+		// Shutdown does nothing, so it is already complete.
+		if then != nil {
+			go then()
+		}
+		return spentDone
+	}
+	m.srM.RLock()
+	done := m.done
+	m.srM.RUnlock()
+	go func() {
+		m.shutdown(r)
+		if then != nil {
+			then()
+		}
+	}()
+	return done
+}
+
+// RegisterGoroutine starts g in a new goroutine, and the given stage
+// will wait for it to return before shutdown proceeds.
+//
+// This works like a sync.WaitGroup for the stage. The goroutine
+// should use Started or a notifier to know when to return.
+// Like other notifiers, the stage stops waiting when it times out.
+// If g returns before shutdown has started, it is no longer registered.
+// The notifier is returned, so it can be cancelled.
+func RegisterGoroutine(g func(), s Stage) Notifier {
+	return defaultManager.RegisterGoroutine(g, s)
+}
+
+// RegisterGoroutine starts g in a new goroutine, that the given stage of the manager waits for.
+func (m *Manager) RegisterGoroutine(g func(), s Stage) Notifier {
+	if g == nil {
+		panic("shutdown: nil shutdown function")
+	}
+	done := make(chan struct{})
+	n := m.onFunc(s.n, func(interface{}) {
+		<-done
+	}, nil)
+	go func() {
+		defer func() {
+			close(done)
+			n := n
+			n.Cancel()
+		}()
+		g()
+	}()
+	return n
+}
+
+// Go starts fn in a new goroutine, that holds a lock while it runs, see Lock.
+//
+// Shutdown waits for fn to return before the first stage is started,
+// for no longer than the Preshutdown timeout. This is the simplest way to
+// let a service goroutine finish its work before shutdown begins.
+// fn should use Started or a notifier to know when to return.
+// If shutdown has already started, fn is not started and false is returned.
+// Like Lock, Go waits while the manager is quiesced, see Quiesce.
+// If fn panics, the lock is released, but the panic is not recovered.
+func Go(fn func()) bool {
+	return defaultManager.Go(fn)
+}
+
+// Go starts fn in a new goroutine, that shutdown of the manager waits for before the first stage.
+func (m *Manager) Go(fn func()) bool {
+	if fn == nil {
+		panic("shutdown: nil function")
+	}
+	if m.isDisabled() {
+		go fn()
+		return true
+	}
+	m.waitQuiesce()
+	if !m.locks.tryLock() {
+		return false
+	}
+	go func() {
+		defer m.locks.unlock()
+		fn()
+	}()
+	return true
+}
+
+// StopLoop returns a channel that is closed when the given stage starts,
+// and a function that must be called when the loop has stopped.
+// The stage waits for finished to be called, or for its timeout.
+//
+// This is intended for loops like:
+//
+//	done, finished := shutdown.StopLoop(shutdown.Stage1)
+//	go func() {
+//	    defer finished()
+//	    for {
+//	        select {
+//	        case <-ticker.C:
+//	            work()
+//	        case <-done:
+//	            return
+//	        }
+//	    }
+//	}()
+//
+// If finished is called before shutdown has started, the stage will not wait.
+// finished can be called more than once.
+func StopLoop(s Stage) (done <-chan struct{}, finished func()) {
+	return defaultManager.StopLoop(s)
+}
+
+// StopLoop returns a channel that is closed when the given stage of the manager starts,
+// and a function the stage waits for.
+func (m *Manager) StopLoop(s Stage) (done <-chan struct{}, finished func()) {
+	d := make(chan struct{})
+	stopped := make(chan struct{})
+	n := m.onFunc(s.n, func(interface{}) {
+		close(d)
+		<-stopped
+	}, nil)
+	var once sync.Once
+	return d, func() {
+		once.Do(func() {
+			close(stopped)
+			n.Cancel()
+		})
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAsync(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var got, second bool
+	Async(First(), func() {
+		time.Sleep(100 * time.Millisecond)
+		if second {
+			t.Error("second stage started before function returned")
+		}
+		got = true
+	})
+	SecondFunc(setBool, &second)
+	Shutdown()
+	if !got || !second {
+		t.Fatal("functions were not called", got, second)
+	}
+}
+
+func TestAsyncPanic(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	lines, restore := logLines()
+	defer restore()
+	n := Async(First(), func() {
+		panic("This is expected")
+	})
+	want := fmt.Sprintf("Panic in shutdown function id %d: This is expected", n.ID())
+	tn := time.Now()
+	Shutdown()
+	if dur := time.Since(tn); dur > 500*time.Millisecond {
+		t.Fatal("shutdown waited after panic", dur)
+	}
+	nextLine(t, lines, want)
+	if p := LastSummary().Panics; p != 1 {
+		t.Fatal("panic not counted, got", p)
+	}
+}
+
+func TestAsyncCancel(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	n := Async(First(), func() {
+		t.Error("cancelled function was called")
+	})
+	n.Cancel()
+	Shutdown()
+	expectPanic(t, "shutdown: nil shutdown function", func() { Async(First(), nil) })
+}
+
+func TestTrackGoroutine(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	done := make(chan struct{})
+	if !TrackGoroutine(done) {
+		t.Fatal("unable to track goroutine")
+	}
+	// A closed channel is no longer tracked.
+	returned := make(chan struct{})
+	close(returned)
+	TrackGoroutine(returned)
+
+	stage1 := make(chan struct{})
+	FirstFunc(func(interface{}) { close(stage1) }, nil)
+	finished := make(chan struct{})
+	go func() {
+		Shutdown()
+		close(finished)
+	}()
+	select {
+	case <-stage1:
+		t.Fatal("stage 1 started before the goroutine was done")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(done)
+	select {
+	case <-stage1:
+	case <-time.After(time.Second):
+		t.Fatal("stage 1 did not start after the goroutine was done")
+	}
+	if TrackGoroutine(make(chan struct{})) {
+		t.Fatal("tracked goroutine after shutdown started")
+	}
+	<-finished
+}
+
+func TestShutdownAsync(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	trigger := goroutineID()
+	ran := make(chan uint64, 1)
+	FirstFunc(func(interface{}) {
+		time.Sleep(10 * time.Millisecond)
+		ran <- goroutineID()
+	}, nil)
+	done := ShutdownAsync()
+	if done2 := ShutdownAsync(); done2 != done {
+		t.Fatal("expected the same channel from all calls")
+	}
+	waiters := make(chan struct{}, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			<-done
+			waiters <- struct{}{}
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-waiters:
+		case <-time.After(time.Second):
+			t.Fatal("shutdown did not complete")
+		}
+	}
+	if id := <-ran; id == trigger {
+		t.Fatal("function ran on the goroutine that triggered shutdown")
+	}
+	if !Started() {
+		t.Fatal("shutdown not started")
+	}
+}
+
+func TestRegisterGoroutine(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var returned, second bool
+	f := First()
+	RegisterGoroutine(func() {
+		<-f
+		time.Sleep(100 * time.Millisecond)
+		returned = true
+	}, Stage1)
+	SecondFunc(setBool, &second)
+	Shutdown()
+	if !returned || !second {
+		t.Fatal("stage did not wait for goroutine", returned, second)
+	}
+}
+
+func TestRegisterGoroutineReturned(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	n := RegisterGoroutine(func() {}, Stage2)
+	for i := 0; !n.Cancelled(); i++ {
+		if i > 1000 {
+			t.Fatal("goroutine still registered after returning")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if HasRegistrations(Stage2) {
+		t.Fatal("goroutine still registered after returning")
+	}
+}
+
+func TestRegisterGoroutineTimeout(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeout(100 * time.Millisecond)
+	block := make(chan struct{})
+	defer close(block)
+	RegisterGoroutine(func() { <-block }, Stage1)
+	var third bool
+	ThirdFunc(setBool, &third)
+	Shutdown()
+	if !third {
+		t.Fatal("shutdown did not proceed after timeout")
+	}
+}
+
+func TestGo(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	release := make(chan struct{})
+	var returned, first bool
+	ok := Go(func() {
+		<-release
+		time.Sleep(10 * time.Millisecond)
+		returned = true
+	})
+	if !ok {
+		t.Fatal("unable to start goroutine")
+	}
+	_ = FirstFunc(func(interface{}) {
+		first = returned
+	}, nil)
+	finished := make(chan struct{})
+	go func() {
+		Shutdown()
+		close(finished)
+	}()
+	for !Started() {
+		time.Sleep(time.Millisecond)
+	}
+	if Go(func() { t.Error("goroutine started after shutdown") }) {
+		t.Fatal("Go returned true after shutdown started")
+	}
+	close(release)
+	<-finished
+	if !first {
+		t.Fatal("first stage started before goroutine returned")
+	}
+}
+
+func TestStopLoop(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	done, finished := StopLoop(Stage1)
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	var ticks int
+	var stopped, second bool
+	go func() {
+		defer finished()
+		for {
+			select {
+			case <-ticker.C:
+				ticks++
+			case <-done:
+				time.Sleep(50 * time.Millisecond)
+				stopped = true
+				return
+			}
+		}
+	}()
+	SecondFunc(func(interface{}) {
+		second = true
+		if !stopped {
+			t.Error("second stage started before loop stopped")
+		}
+	}, nil)
+	time.Sleep(10 * time.Millisecond)
+	Shutdown()
+	if !stopped || !second || ticks == 0 {
+		t.Fatal("unexpected state", stopped, second, ticks)
+	}
+}
+
+func TestStopLoopFinishedEarly(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeout(time.Minute)
+	done, finished := StopLoop(Stage1)
+	finished()
+	finished()
+	if HasRegistrations(Stage1) {
+		t.Fatal("finished loop still registered")
+	}
+	Shutdown()
+	select {
+	case <-done:
+		t.Fatal("done closed for a finished loop")
+	default:
+	}
+}
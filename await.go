@@ -0,0 +1,164 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrNotifierCancelled is returned by AwaitCompletion when the notifier has been cancelled.
+	ErrNotifierCancelled = errors.New("shutdown: notifier was cancelled")
+
+	// ErrNotifierTimedOut is returned by AwaitCompletion when shutdown
+	// stopped waiting for the notifier before it finished.
+	ErrNotifierTimedOut = errors.New("shutdown: notifier did not finish in time")
+
+	// ErrNotifierNotRun is returned by AwaitCompletion when shutdown completed
+	// without signalling the notifier, because its stage was skipped,
+	// see SetStagePredicate, or the hard timeout had expired, see SetHardTimeout.
+	ErrNotifierNotRun = errors.New("shutdown: notifier was not run")
+
+	// ErrUnknownNotifier is returned by AwaitCompletion when no notifier has the id.
+	ErrUnknownNotifier = errors.New("shutdown: unknown notifier")
+)
+
+// completion is the outcome of a notifier, see AwaitCompletion.
+type completion struct {
+	n       Notifier
+	done    chan struct{} // Closed when err is set.
+	err     error
+	waiters int
+}
+
+var awM sync.Mutex // Mutex for below. nM is locked before it.
+var completions = make(map[uint64]*completion)
+
+// AwaitCompletion waits until the notifier with the given id has been
+// executed, and returns the outcome.
+//
+// This lets a component wait for the cleanup of another component, without
+// access to its notifier, for instance before it starts work of its own in
+// the same stage. The notifier has been executed when it has acknowledged
+// the notification, or its function has returned. nil is returned if it
+// has. If the notifier has been cancelled, ErrNotifierCancelled is returned.
+// If shutdown stopped waiting for it, ErrNotifierTimedOut is returned, and
+// if it was never signalled, ErrNotifierNotRun. If ctx is done first, the
+// error of ctx is returned.
+//
+// If shutdown hasn't started, AwaitCompletion waits for it. The outcomes
+// of a shutdown are kept until the next shutdown of the manager starts,
+// or the manager is closed or its registrations are reset, so it returns
+// immediately after the shutdown. A cancelled notifier is
+// forgotten, so its id is unknown after it has been cancelled.
+// If no notifier has the id, ErrUnknownNotifier is returned.
+func AwaitCompletion(ctx context.Context, id uint64) error {
+	nM.Lock()
+	n, registered := notifierIDs[id]
+	awM.Lock()
+	c := completions[id]
+	if c == nil {
+		if !registered {
+			awM.Unlock()
+			nM.Unlock()
+			return ErrUnknownNotifier
+		}
+		c = &completion{n: n, done: make(chan struct{})}
+		completions[id] = c
+	}
+	c.waiters++
+	awM.Unlock()
+	nM.Unlock()
+	defer func() {
+		awM.Lock()
+		c.waiters--
+		if c.waiters == 0 && !c.resolved() && completions[id] == c {
+			// Don't keep notifiers nobody waits for.
+			delete(completions, id)
+		}
+		awM.Unlock()
+	}()
+
+	select {
+	case <-c.done:
+		return c.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Await waits until the notifier has been executed, and returns the outcome.
+// See AwaitCompletion. Unlike AwaitCompletion, ErrNotifierCancelled is
+// returned if the notifier has already been cancelled.
+func (s Notifier) Await(ctx context.Context) error {
+	id := s.ID()
+	if id == 0 {
+		// Forgotten after shutdown, but the outcome is kept.
+		awM.Lock()
+		for cid, c := range completions {
+			if c.n == s {
+				id = cid
+				break
+			}
+		}
+		awM.Unlock()
+		if id == 0 && s.Cancelled() {
+			return ErrNotifierCancelled
+		}
+	}
+	return AwaitCompletion(ctx, id)
+}
+
+// resolved returns true if the outcome has been set.
+func (c *completion) resolved() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// complete sets the outcome of n in the running shutdown of the manager,
+// unless it has already been set. The outcome is kept until the next
+// shutdown of the manager starts, see forgetCompletions.
+func (m *Manager) complete(n Notifier, err error) {
+	id := n.ID()
+	if id == 0 {
+		return
+	}
+	awM.Lock()
+	defer awM.Unlock()
+	if resolve(n, id, err) {
+		m.completed = append(m.completed, id)
+	}
+}
+
+// resolve sets the outcome of n, which has the given id, and wakes those
+// waiting for it. It returns false if the outcome had already been set.
+// awM must be held.
+func resolve(n Notifier, id uint64, err error) bool {
+	c := completions[id]
+	if c == nil {
+		c = &completion{n: n, done: make(chan struct{})}
+		completions[id] = c
+	}
+	if c.resolved() {
+		return false
+	}
+	c.err = err
+	close(c.done)
+	return true
+}
+
+// forgetCompletions forgets the outcomes of the last shutdown of the manager.
+func (m *Manager) forgetCompletions() {
+	awM.Lock()
+	defer awM.Unlock()
+	for _, id := range m.completed {
+		delete(completions, id)
+	}
+	m.completed = nil
+}
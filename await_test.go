@@ -0,0 +1,141 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAwaitCompletion(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	ctx := context.Background()
+	var cleaned bool
+	a := SecondFunc(func(interface{}) {
+		time.Sleep(10 * time.Millisecond)
+		cleaned = true
+	}, nil)
+	id := a.ID()
+
+	// Before shutdown, wait for it to happen.
+	awaited := make(chan bool)
+	SecondFunc(func(interface{}) {
+		if err := AwaitCompletion(ctx, id); err != nil {
+			t.Error(err)
+		}
+		awaited <- cleaned
+	}, nil)
+	Shutdown()
+	if !<-awaited {
+		t.Fatal("returned before the cleanup finished")
+	}
+
+	// After shutdown the outcome is kept.
+	if err := AwaitCompletion(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Await(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Outcomes are forgotten when the next shutdown starts.
+	Reset()
+	Shutdown()
+	if err := AwaitCompletion(ctx, id); err != ErrUnknownNotifier {
+		t.Fatal("got", err, "want", ErrUnknownNotifier)
+	}
+	if err := AwaitCompletion(ctx, 0); err != ErrUnknownNotifier {
+		t.Fatal("got", err, "want", ErrUnknownNotifier)
+	}
+}
+
+func TestAwaitCompletionForgotten(t *testing.T) {
+	defer close(startTimer(t))
+	ctx := context.Background()
+	for _, forget := range []func(m *Manager){(*Manager).Close, (*Manager).ResetRegistrations} {
+		m := NewManager()
+		id := m.SecondFunc(func(interface{}) {}, nil).ID()
+		m.Shutdown()
+		if err := AwaitCompletion(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+		forget(m)
+		if err := AwaitCompletion(ctx, id); err != ErrUnknownNotifier {
+			t.Fatal("got", err, "want", ErrUnknownNotifier)
+		}
+		awM.Lock()
+		_, kept := completions[id]
+		awM.Unlock()
+		if kept {
+			t.Fatal("outcome was kept")
+		}
+	}
+}
+
+func TestAwaitCompletionCancelled(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	ctx := context.Background()
+
+	f := First()
+	id := f.ID()
+	awaited := make(chan error)
+	go func() {
+		awaited <- AwaitCompletion(ctx, id)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	f.Cancel()
+	if err := <-awaited; err != ErrNotifierCancelled {
+		t.Fatal("got", err, "want", ErrNotifierCancelled)
+	}
+	// Awaiting a cancelled notifier returns immediately.
+	if err := f.Await(ctx); err != ErrNotifierCancelled {
+		t.Fatal("got", err, "want", ErrNotifierCancelled)
+	}
+	fn := SecondFunc(func(interface{}) {}, nil)
+	fn.Cancel()
+	if err := fn.Await(ctx); err != ErrNotifierCancelled {
+		t.Fatal("got", err, "want", ErrNotifierCancelled)
+	}
+}
+
+func TestAwaitCompletionTimedOut(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeoutN(Stage1, 20*time.Millisecond)
+	release := make(chan struct{})
+	defer close(release)
+	stuck := FirstFunc(func(interface{}) { <-release }, nil)
+	limited := FirstFuncTimeout(func(interface{}) { <-release }, nil, 5*time.Millisecond)
+	ignored := Second()
+	SetStagePredicate(Stage2, func(Reason) bool { return false })
+	Shutdown()
+
+	for _, n := range []Notifier{stuck, limited} {
+		if err := n.Await(context.Background()); err != ErrNotifierTimedOut {
+			t.Fatal("got", err, "want", ErrNotifierTimedOut)
+		}
+	}
+	if err := ignored.Await(context.Background()); err != ErrNotifierNotRun {
+		t.Fatal("got", err, "want", ErrNotifierNotRun)
+	}
+}
+
+func TestAwaitCompletionContext(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	f := First()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := f.Await(ctx); err != context.DeadlineExceeded {
+		t.Fatal("got", err, "want", context.DeadlineExceeded)
+	}
+	awM.Lock()
+	_, kept := completions[f.ID()]
+	awM.Unlock()
+	if kept {
+		t.Fatal("outcome kept after the only waiter returned")
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrBarrierTimeout is returned by Barrier.Wait if the stage
+// timed out before all participants reached the barrier.
+var ErrBarrierTimeout = errors.New("shutdown: barrier timed out")
+
+// A Barrier lets shutdown functions in a stage wait for each other.
+//
+// Functions participate in the barrier by being registered with Func.
+// When a function calls Wait, it is blocked until all participants
+// have called Wait, and they are then released together.
+// This can be used when several functions must reach a consistent
+// state at the same time, for instance before a snapshot.
+type Barrier struct {
+	m     *Manager
+	stage int
+
+	mu           sync.Mutex // Mutex for below
+	participants []Notifier
+	arrived      int
+	released     bool
+	release      chan struct{}
+}
+
+// NewBarrier returns a new barrier for functions in the given stage.
+func NewBarrier(s Stage) *Barrier {
+	return defaultManager.NewBarrier(s)
+}
+
+// NewBarrier returns a new barrier for functions in the given stage of the manager.
+func (m *Manager) NewBarrier(s Stage) *Barrier {
+	return &Barrier{m: m, stage: s.n, release: make(chan struct{})}
+}
+
+// Func registers a function in the stage of the barrier,
+// which participates in the barrier.
+// The function must call Wait once.
+// If the returned notifier is cancelled, it no longer participates.
+func (b *Barrier) Func(fn ShutdownFn, v interface{}) Notifier {
+	n := b.m.onFunc(b.stage, fn, v)
+	b.mu.Lock()
+	b.participants = append(b.participants, n)
+	b.mu.Unlock()
+	return n
+}
+
+// Wait blocks until all participants have called Wait.
+// If the stage times out before that, all waiting participants
+// are released, and ErrBarrierTimeout is returned.
+func (b *Barrier) Wait() error {
+	b.mu.Lock()
+	b.arrived++
+	if !b.released && b.arrived >= b.active() {
+		b.released = true
+		close(b.release)
+	}
+	b.mu.Unlock()
+
+	ctx, cancel := b.m.stageContext(b.stage)
+	defer cancel()
+	select {
+	case <-b.release:
+		return nil
+	case <-ctx.Done():
+		return ErrBarrierTimeout
+	}
+}
+
+// active returns the number of participants that haven't been cancelled.
+// b.mu must be held.
+func (b *Barrier) active() int {
+	n := 0
+	for _, p := range b.participants {
+		if !p.Cancelled() {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBarrier(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	b := NewBarrier(Stage1)
+	var mu sync.Mutex
+	arrived := 0
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		b.Func(func(i interface{}) {
+			time.Sleep(time.Duration(i.(int)) * 50 * time.Millisecond)
+			mu.Lock()
+			arrived++
+			mu.Unlock()
+			err := b.Wait()
+			mu.Lock()
+			if arrived != 3 {
+				t.Errorf("released after %d participants arrived", arrived)
+			}
+			mu.Unlock()
+			errs <- err
+		}, i)
+	}
+	Shutdown()
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal("unexpected error", err)
+		}
+	}
+}
+
+func TestBarrierTimeout(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeout(time.Millisecond * 200)
+	b := NewBarrier(Stage1)
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		b.Func(func(interface{}) {
+			errs <- b.Wait()
+		}, nil)
+	}
+	// This participant never arrives.
+	b.Func(func(interface{}) {
+		select {}
+	}, nil)
+	Shutdown()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != ErrBarrierTimeout {
+			t.Fatal("expected barrier timeout, got", err)
+		}
+	}
+}
+
+func TestBarrierCancel(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	b := NewBarrier(Stage2)
+	errs := make(chan error, 1)
+	b.Func(func(interface{}) {
+		errs <- b.Wait()
+	}, nil)
+	n := b.Func(func(interface{}) {
+		t.Error("cancelled participant was called")
+	}, nil)
+	n.Cancel()
+	Shutdown()
+	if err := <-errs; err != nil {
+		t.Fatal("unexpected error", err)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// SetCrashBreadcrumbs enables breadcrumbs written to the file at path.
+//
+// When a shutdown function, or a function given to RunProtected, is started
+// and when it returns, a line is written to the file. The file is opened
+// with O_SYNC, so each line is on disk before the function continues.
+// If a function exits the process, for instance by calling log.Fatal, the
+// remaining stages are not run. The file then shows which function was
+// running when the process exited.
+//
+// When breadcrumbs are enabled, the file of the previous run is read
+// before it is truncated. A warning is logged for each function that was
+// started but didn't return, and the descriptions of them are returned.
+// Use an empty path to disable breadcrumbs, which is the default.
+func SetCrashBreadcrumbs(path string) ([]string, error) {
+	return defaultManager.SetCrashBreadcrumbs(path)
+}
+
+// SetCrashBreadcrumbs enables breadcrumbs of the manager written to the file at path.
+func (m *Manager) SetCrashBreadcrumbs(path string) ([]string, error) {
+	m.crumbM.Lock()
+	defer m.crumbM.Unlock()
+	if m.crumbs != nil {
+		m.crumbs.Close()
+		m.crumbs = nil
+	}
+	if path == "" {
+		return nil, nil
+	}
+	unfinished, err := readBreadcrumbs(path)
+	if err != nil {
+		return nil, fmt.Errorf("shutdown: reading breadcrumbs: %v", err)
+	}
+	for _, fn := range unfinished {
+		Logger.Printf("WARNING: the process exited during a previous run, while %s was running", fn)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_SYNC, 0644)
+	if err != nil {
+		return unfinished, fmt.Errorf("shutdown: opening breadcrumbs: %v", err)
+	}
+	m.crumbs = f
+	return unfinished, nil
+}
+
+// RunProtected calls fn, and recovers and logs a panic in it.
+//
+// If breadcrumbs are enabled, it is written to the file when fn starts and
+// returns, so it can be seen whether fn exited the process, see
+// SetCrashBreadcrumbs. Shutdown functions are run like this.
+func RunProtected(fn func()) {
+	defaultManager.RunProtected(fn)
+}
+
+// RunProtected calls fn, and recovers and logs a panic in it, leaving breadcrumbs of the manager.
+func (m *Manager) RunProtected(fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			Logger.Printf("Panic in protected function %s: %v", funcName(fn), r)
+		}
+	}()
+	defer m.breadcrumb(func() string {
+		return "function " + funcName(fn)
+	})()
+	fn()
+}
+
+// breadcrumb writes that the function described by desc has started,
+// if breadcrumbs are enabled. The returned function writes that it has returned.
+// desc is only called if breadcrumbs are enabled.
+func (m *Manager) breadcrumb(desc func() string) func() {
+	m.crumbM.Lock()
+	defer m.crumbM.Unlock()
+	if m.crumbs == nil {
+		return func() {}
+	}
+	m.crumbSeq++
+	seq := m.crumbSeq
+	m.writeBreadcrumb(fmt.Sprintf("started %d %s\n", seq, desc()))
+	return func() {
+		m.crumbM.Lock()
+		defer m.crumbM.Unlock()
+		m.writeBreadcrumb(fmt.Sprintf("finished %d\n", seq))
+	}
+}
+
+// writeBreadcrumb writes a line to the breadcrumb file, if it is open.
+// m.crumbM must be held.
+func (m *Manager) writeBreadcrumb(line string) {
+	if m.crumbs == nil {
+		// Disabled after the function started.
+		return
+	}
+	if _, err := m.crumbs.WriteString(line); err != nil {
+		Logger.Println("Unable to write breadcrumb:", err)
+	}
+}
+
+// readBreadcrumbs returns the descriptions of the functions in the
+// breadcrumb file at path, that were started but didn't return.
+// A missing file has none.
+func readBreadcrumbs(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var order []uint64
+	started := make(map[uint64]string)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.SplitN(s.Text(), " ", 3)
+		if len(fields) < 2 {
+			continue
+		}
+		seq, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch {
+		case fields[0] == "started" && len(fields) == 3:
+			order = append(order, seq)
+			started[seq] = fields[2]
+		case fields[0] == "finished":
+			delete(started, seq)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	var unfinished []string
+	for _, seq := range order {
+		if desc, ok := started[seq]; ok {
+			unfinished = append(unfinished, desc)
+		}
+	}
+	return unfinished, nil
+}
+
+// funcName returns the name of the function fn.
+func funcName(fn interface{}) string {
+	if f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()); f != nil {
+		return f.Name()
+	}
+	return "unknown"
+}
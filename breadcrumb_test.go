@@ -0,0 +1,64 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// exitingCallback exits the process, like a callback calling log.Fatal.
+func exitingCallback(interface{}) {
+	os.Exit(3)
+}
+
+func TestCrashBreadcrumbs(t *testing.T) {
+	if path := os.Getenv("SHUTDOWN_TEST_BREADCRUMBS"); path != "" {
+		// Run by the test below.
+		reset()
+		if _, err := SetCrashBreadcrumbs(path); err != nil {
+			t.Fatal(err)
+		}
+		FirstFunc(func(interface{}) {}, nil)
+		SecondFunc(exitingCallback, nil)
+		Shutdown()
+		t.Fatal("did not exit")
+	}
+	reset()
+	defer close(startTimer(t))
+	path := filepath.Join(t.TempDir(), "breadcrumbs")
+	cmd := exec.Command(os.Args[0], "-test.run=^TestCrashBreadcrumbs$")
+	cmd.Env = append(os.Environ(), "SHUTDOWN_TEST_BREADCRUMBS="+path)
+	out, err := cmd.CombinedOutput()
+	if e, ok := err.(*exec.ExitError); !ok || e.ExitCode() != 3 {
+		t.Fatalf("expected exit code 3, got %v: %s", err, out)
+	}
+
+	lines, restore := logLines()
+	defer restore()
+	unfinished, err := SetCrashBreadcrumbs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer SetCrashBreadcrumbs("")
+	if len(unfinished) != 1 || !strings.Contains(unfinished[0], "second stage") || !strings.Contains(unfinished[0], "exitingCallback") {
+		t.Fatal("unexpected unfinished functions", unfinished)
+	}
+	nextLine(t, lines, "exitingCallback was running")
+
+	// The file is truncated, and used for this run.
+	RunProtected(func() { panic("This is expected") })
+	if unfinished, err := readBreadcrumbs(path); err != nil || len(unfinished) != 0 {
+		t.Fatal("unexpected breadcrumbs", unfinished, err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(b); !strings.HasPrefix(got, "started 1 function ") || !strings.HasSuffix(got, "finished 1\n") {
+		t.Fatalf("unexpected breadcrumbs %q", got)
+	}
+}
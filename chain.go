@@ -0,0 +1,136 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"fmt"
+)
+
+// NextStageFunc registers a function for the stage following the
+// stage of the shutdown function calling it.
+//
+// It is intended to be called from a shutdown function, to hand off
+// work to the next stage. The value v is given to the function,
+// so a result can be passed along. Stages running concurrently with the
+// stage of the caller are skipped, see SetParallelStages. If the next
+// stage has already started, because the stage of the caller timed out,
+// the function is not called. If it isn't called by a shutdown function,
+// the stage following the running stage is used.
+// It panics if shutdown hasn't started, or there is no following stage.
+func NextStageFunc(fn ShutdownFn, v interface{}) Notifier {
+	return defaultManager.NextStageFunc(fn, v)
+}
+
+// NextStageFunc registers a function for the stage following the stage of the calling shutdown function of the manager.
+func (m *Manager) NextStageFunc(fn ShutdownFn, v interface{}) Notifier {
+	stage := m.callerStage()
+	m.srM.RLock()
+	if stage < 0 && m.current >= 0 {
+		stage = m.order[m.current]
+	}
+	next := -1
+	if stage >= 0 {
+		next = m.nextStage(stage)
+	}
+	m.srM.RUnlock()
+	if stage < 0 {
+		panic("shutdown: NextStageFunc called before shutdown started")
+	}
+	if next < 0 {
+		panic("shutdown: NextStageFunc called in the last stage")
+	}
+	return m.onFunc(next, fn, v)
+}
+
+// callerStage returns the stage of the shutdown function of the manager
+// running in the calling goroutine, or -1 if there is none.
+func (m *Manager) callerStage() int {
+	id := goroutineID()
+	var fn Notifier
+	m.srM.RLock()
+	for n, gid := range m.running {
+		if gid == id {
+			fn = n
+			break
+		}
+	}
+	m.srM.RUnlock()
+	if fn == nil {
+		return -1
+	}
+	nM.Lock()
+	defer nM.Unlock()
+	if ns := notifiers[fn]; ns != nil {
+		return ns.stage
+	}
+	return -1
+}
+
+// nextStage returns the first stage of the manager run after the given stage,
+// and the stages running concurrently with it, or -1 if there is none.
+// m.srM must be held.
+func (m *Manager) nextStage(stage int) int {
+	group := m.parallel[stage]
+	pos := m.stagePos(stage) + 1
+	for group != 0 && pos < numStages && m.parallel[m.order[pos]] == group {
+		pos++
+	}
+	if pos >= numStages {
+		return -1
+	}
+	return m.order[pos]
+}
+
+// stagePos returns the position of a stage in the order the stages of the manager are run.
+// m.srM must be held.
+func (m *Manager) stagePos(stage int) int {
+	for pos, s := range m.order {
+		if s == stage {
+			return pos
+		}
+	}
+	return -1
+}
+
+// RemapStages changes the order of the stages that haven't started yet.
+//
+// This can be used when the order of the shutdown depends on runtime
+// state, for instance from a Preshutdown function. order must contain
+// each of the stages that haven't started exactly once, in the order they
+// should run. The Preshutdown stage always runs first, and cannot be remapped.
+// If order contains a stage that has started, or isn't complete,
+// an error is returned and the order is not changed.
+func RemapStages(order []Stage) error {
+	return defaultManager.RemapStages(order)
+}
+
+// RemapStages changes the order of the stages of the manager that haven't started yet.
+func (m *Manager) RemapStages(order []Stage) error {
+	m.srM.Lock()
+	defer m.srM.Unlock()
+	first := m.current + 1
+	if first < 1 {
+		// Preshutdown is always first.
+		first = 1
+	}
+	var seen [numStages]bool
+	for _, s := range order {
+		if s.n < 0 || s.n >= numStages {
+			return fmt.Errorf("shutdown: RemapStages: invalid stage %d", s.n)
+		}
+		if m.stagePos(s.n) < first {
+			return fmt.Errorf("shutdown: RemapStages: %s stage has already started", stageName(s.n))
+		}
+		if seen[s.n] {
+			return fmt.Errorf("shutdown: RemapStages: %s stage given more than once", stageName(s.n))
+		}
+		seen[s.n] = true
+	}
+	if len(order) != numStages-first {
+		return fmt.Errorf("shutdown: RemapStages: %d stages given, %d have not started", len(order), numStages-first)
+	}
+	for i, s := range order {
+		m.order[first+i] = s.n
+	}
+	return nil
+}
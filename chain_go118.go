@@ -0,0 +1,44 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package shutdown
+
+// ChainFunc executes produce in the given stage, and consume in the stage
+// following it, with the value returned by produce.
+//
+// Each function is limited by the timeout of its own stage.
+// If produce returns an error, consume is not called.
+// Errors are logged. The returned notifier is the notifier of produce.
+// consume is registered when produce has returned, see NextStageFunc, so
+// cancelling the notifier before shutdown starts cancels both functions.
+// It panics if there is no stage following the given stage.
+func ChainFunc[T any](s Stage, produce func() (T, error), consume func(T) error) Notifier {
+	return ChainFuncOf(defaultManager, s, produce, consume)
+}
+
+// ChainFuncOf is like ChainFunc, but the functions are executed by the given manager.
+func ChainFuncOf[T any](m *Manager, s Stage, produce func() (T, error), consume func(T) error) Notifier {
+	if produce == nil || consume == nil {
+		panic("shutdown: nil shutdown function")
+	}
+	m.srM.RLock()
+	last := m.nextStage(s.n) < 0
+	m.srM.RUnlock()
+	if last {
+		panic("shutdown: ChainFunc called with the last stage")
+	}
+	return m.onFunc(s.n, func(interface{}) {
+		v, err := produce()
+		if err != nil {
+			Logger.Println("Chained function failed, not calling next stage:", err)
+			return
+		}
+		m.NextStageFunc(func(interface{}) {
+			if err := consume(v); err != nil {
+				Logger.Println("Chained function failed:", err)
+			}
+		}, nil)
+	}, nil)
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package shutdown
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestChainFunc(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var third bool
+	var got string
+	_ = ChainFunc(Stage1, func() (string, error) {
+		return "snapshot.bin", nil
+	}, func(path string) error {
+		if third {
+			t.Error("consumer called after third stage")
+		}
+		got = path
+		return nil
+	})
+	_ = ThirdFunc(setBool, &third)
+
+	Shutdown()
+	if !third {
+		t.Fatal("third stage was not run")
+	}
+	if got != "snapshot.bin" {
+		t.Fatalf("expected consumer to get %q, got %q", "snapshot.bin", got)
+	}
+}
+
+func TestChainFuncError(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	lines, restore := logLines()
+	defer restore()
+	var called bool
+	_ = ChainFunc(Stage1, func() (int, error) {
+		return 0, errors.New("no snapshot")
+	}, func(int) error {
+		called = true
+		return nil
+	})
+	_ = ChainFunc(Stage2, func() (int, error) {
+		return 1, nil
+	}, func(int) error {
+		return errors.New("upload failed")
+	})
+
+	Shutdown()
+	if called {
+		t.Fatal("consumer called after producer failed")
+	}
+	nextLine(t, lines, "Chained function failed, not calling next stage: no snapshot")
+	nextLine(t, lines, "Chained function failed: upload failed")
+}
+
+func TestChainFuncInvalid(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	produce := func() (int, error) { return 0, nil }
+	consume := func(int) error { return nil }
+	expectPanic(t, "shutdown: nil shutdown function", func() { ChainFunc(Stage1, nil, consume) })
+	expectPanic(t, "shutdown: nil shutdown function", func() { ChainFunc(Stage1, produce, nil) })
+	expectPanic(t, "shutdown: ChainFunc called with the last stage", func() { ChainFunc(Stage3, produce, consume) })
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNextStageFunc(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var mu sync.Mutex
+	var order []string
+	add := func(i interface{}) {
+		mu.Lock()
+		order = append(order, i.(string))
+		mu.Unlock()
+	}
+	_ = PreShutdownFunc(func(interface{}) {
+		NextStageFunc(add, "readonly")
+	}, nil)
+	_ = FirstFunc(func(interface{}) {
+		add("first")
+		NextStageFunc(add, "second")
+	}, nil)
+	_ = ThirdFunc(add, "third")
+
+	Shutdown()
+	want := []string{"readonly", "first", "second", "third"}
+	if len(order) != len(want) {
+		t.Fatal("unexpected order", order)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatal("unexpected order", order)
+		}
+	}
+}
+
+// The next stage is found from the stage of the caller, not the running stage.
+func TestNextStageFuncOwnStage(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeoutN(Stage1, 50*time.Millisecond)
+	second := make(chan struct{})
+	handedOff := make(chan struct{})
+	var late int32
+	_ = FirstFunc(func(interface{}) {
+		// Wait until this stage has timed out, and the second stage runs.
+		<-second
+		NextStageFunc(func(interface{}) { atomic.StoreInt32(&late, 1) }, nil)
+		close(handedOff)
+	}, nil)
+	_ = SecondFunc(func(interface{}) {
+		close(second)
+		<-handedOff
+	}, nil)
+	Shutdown()
+	if atomic.LoadInt32(&late) != 0 {
+		t.Fatal("function of the first stage handed off to the third stage")
+	}
+
+	// Stages running concurrently are skipped.
+	reset()
+	if err := SetParallelStages([][]Stage{{Stage1, Stage2}}); err != nil {
+		t.Fatal(err)
+	}
+	var after int32
+	_ = FirstFunc(func(interface{}) {
+		NextStageFunc(func(interface{}) { atomic.StoreInt32(&after, 1) }, nil)
+	}, nil)
+	_ = SecondFunc(func(interface{}) {}, nil)
+	Shutdown()
+	if atomic.LoadInt32(&after) != 1 {
+		t.Fatal("function handed off past the parallel stages was not called")
+	}
+}
+
+// Each function must be limited by the timeout of its own stage.
+func TestNextStageFuncTimeout(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeout(time.Millisecond * 300)
+	var done bool
+	_ = FirstFunc(func(interface{}) {
+		time.Sleep(time.Millisecond * 200)
+		NextStageFunc(func(interface{}) {
+			time.Sleep(time.Millisecond * 200)
+			done = true
+		}, nil)
+	}, nil)
+	Shutdown()
+	if !done {
+		t.Fatal("next stage function did not finish")
+	}
+}
+
+func TestNextStageFuncInvalid(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	fn := func(interface{}) {}
+	expectPanic(t, "shutdown: NextStageFunc called before shutdown started", func() { NextStageFunc(fn, nil) })
+
+	lines, restore := logLines()
+	defer restore()
+	n := ThirdFunc(func(interface{}) {
+		NextStageFunc(fn, nil)
+	}, nil)
+	want := fmt.Sprintf("Panic in shutdown function id %d: shutdown: NextStageFunc called in the last stage", n.ID())
+	Shutdown()
+	nextLine(t, lines, want)
+}
+
+func TestRemapStages(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var mu sync.Mutex
+	var order []int
+	add := func(v interface{}) {
+		mu.Lock()
+		order = append(order, v.(int))
+		mu.Unlock()
+	}
+	var errRan, errMissing, errLate error
+	PreShutdownFunc(func(interface{}) {
+		errRan = RemapStages([]Stage{Preshutdown, ReadOnlyStage, Stage1, Stage3, Stage2})
+		errMissing = RemapStages([]Stage{ReadOnlyStage, Stage1, Stage3})
+		if err := RemapStages([]Stage{ReadOnlyStage, Stage1, Stage3, Stage2}); err != nil {
+			t.Error(err)
+		}
+	}, nil)
+	FirstFunc(func(v interface{}) {
+		add(v)
+		errLate = RemapStages([]Stage{Stage1, Stage2, Stage3})
+	}, 1)
+	SecondFunc(add, 2)
+	ThirdFunc(add, 3)
+	Shutdown()
+	if fmt.Sprint(order) != "[1 3 2]" {
+		t.Fatal("unexpected order", order)
+	}
+	for _, err := range []error{errRan, errMissing, errLate} {
+		if err == nil {
+			t.Fatal("invalid remap was accepted")
+		}
+	}
+	if errLate.Error() != "shutdown: RemapStages: first stage has already started" {
+		t.Fatal("unexpected error", errLate)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// drainPollInterval is how often DrainChannel checks a channel that is
+// drained by a running consumer.
+const drainPollInterval = 10 * time.Millisecond
+
+// ChannelSummary describes a channel drained during shutdown, see DrainChannel.
+type ChannelSummary struct {
+	Stage     int // The stage, see WithGracefulDegradation.
+	Drained   int // Items consumed during the stage.
+	Abandoned int // Items left in the channel when shutdown completed.
+}
+
+// channelDrain is a channel being drained by DrainChannel.
+type channelDrain struct {
+	stage   int
+	length  func() int // Returns the number of items in the channel.
+	drained int64      // Accessed atomically.
+}
+
+// startDrain records that a channel is being drained in the stage.
+// The channel is summarized when shutdown completes, see channelSummaries.
+func (m *Manager) startDrain(stage int, length func() int) *channelDrain {
+	d := &channelDrain{stage: stage, length: length}
+	m.srM.Lock()
+	m.channels = append(m.channels, d)
+	m.srM.Unlock()
+	return d
+}
+
+// channelSummaries returns the summaries of the drained channels,
+// and logs abandoned items. m.srM must be held.
+func (m *Manager) channelSummaries() []ChannelSummary {
+	if m.channels == nil {
+		return nil
+	}
+	s := make([]ChannelSummary, 0, len(m.channels))
+	for _, d := range m.channels {
+		cs := ChannelSummary{Stage: d.stage, Drained: int(atomic.LoadInt64(&d.drained)), Abandoned: d.length()}
+		if cs.Abandoned > 0 {
+			Logger.Printf("Stage %d: drained %d items from channel, abandoned %d", cs.Stage, cs.Drained, cs.Abandoned)
+		}
+		s = append(s, cs)
+	}
+	return s
+}
+
+// waitEmpty waits for the channel of d to be emptied by its consumer, or ctx to be done.
+func (m *Manager) waitEmpty(ctx context.Context, d *channelDrain) {
+	start := d.length()
+	t := m.clock.NewTimer(drainPollInterval)
+	defer t.Stop()
+	for {
+		left := d.length()
+		if left <= start {
+			atomic.StoreInt64(&d.drained, int64(start-left))
+		}
+		if left == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C():
+			t.Reset(drainPollInterval)
+		}
+	}
+}
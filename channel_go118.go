@@ -0,0 +1,59 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package shutdown
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// DrainChannel registers a function in the given stage, which
+// drains the items that are left in the buffered channel ch.
+//
+// Intake must be stopped before the stage, for instance by stopping
+// the producers in an earlier stage. This is the responsibility of the
+// caller. The channel may be closed, but doesn't have to be.
+//
+// If handle is not nil, the function takes over: it calls handle for each
+// item left in ch, until it is empty. A consumer that is still running may
+// take items too. If handle is nil, the function cooperates with the running
+// consumer, and waits for it to empty ch.
+//
+// Draining stops when the stage times out. Items are not interrupted,
+// and an item that is being handled then is not counted.
+// The number of items drained, and the number left in ch when shutdown
+// has completed, are recorded in the summary of the shutdown, see
+// Summary.Channels. Items that are left are logged.
+func DrainChannel[T any](s Stage, ch chan T, handle func(T)) Notifier {
+	return DrainChannelOf(defaultManager, s, ch, handle)
+}
+
+// DrainChannelOf is like DrainChannel, but the function is executed by the given manager.
+func DrainChannelOf[T any](m *Manager, s Stage, ch chan T, handle func(T)) Notifier {
+	if ch == nil {
+		panic("shutdown: nil channel")
+	}
+	return m.onFuncCtx(s.n, func(ctx context.Context, _ interface{}) {
+		d := m.startDrain(s.n, func() int { return len(ch) })
+		if handle == nil {
+			m.waitEmpty(ctx, d)
+			return
+		}
+		// Take over, until the channel is empty or the stage times out.
+		for ctx.Err() == nil {
+			select {
+			case v, ok := <-ch:
+				if !ok {
+					return
+				}
+				handle(v)
+				atomic.AddInt64(&d.drained, 1)
+			default:
+				return
+			}
+		}
+	}, nil)
+}
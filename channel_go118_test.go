@@ -0,0 +1,81 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package shutdown
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDrainChannel(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	ch := make(chan int, 20)
+	for i := 0; i < 10; i++ {
+		ch <- i
+	}
+	var got []int
+	DrainChannel(Stage2, ch, func(v int) { got = append(got, v) })
+	Shutdown()
+	if len(got) != 10 || got[0] != 0 || got[9] != 9 {
+		t.Fatal("unexpected items", got)
+	}
+	s := LastSummary().Channels
+	if len(s) != 1 || s[0] != (ChannelSummary{Stage: 2, Drained: 10}) {
+		t.Fatal("unexpected summary", s)
+	}
+}
+
+func TestDrainChannelTimeout(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeout(200 * time.Millisecond)
+	ch := make(chan int, 20)
+	for i := 0; i < 10; i++ {
+		ch <- i
+	}
+	DrainChannel(Stage1, ch, func(int) { time.Sleep(50 * time.Millisecond) })
+	Shutdown()
+	s := LastSummary().Channels
+	// The item being handled when the stage timed out is in neither.
+	if len(s) != 1 || s[0].Drained+s[0].Abandoned < 9 || s[0].Abandoned == 0 || s[0].Drained == 0 {
+		t.Fatal("unexpected summary", s)
+	}
+}
+
+func TestDrainChannelCooperate(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	ch := make(chan int, 20)
+	for i := 0; i < 10; i++ {
+		ch <- i
+	}
+	start := make(chan struct{})
+	var consumed int32
+	go func() {
+		<-start
+		for range ch {
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&consumed, 1)
+		}
+	}()
+	Async(First(), func() { close(start) })
+	DrainChannel(Stage2, ch, nil)
+	Shutdown()
+	if len(ch) != 0 {
+		t.Fatal("channel not drained", len(ch))
+	}
+	// The consumer may have taken items before the stage started.
+	s := LastSummary().Channels
+	if len(s) != 1 || s[0].Stage != 2 || s[0].Drained == 0 || s[0].Abandoned != 0 {
+		t.Fatal("unexpected summary", s)
+	}
+	close(ch)
+	expectPanic(t, "shutdown: nil channel", func() {
+		DrainChannel[int](Stage1, nil, nil)
+	})
+}
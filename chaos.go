@@ -0,0 +1,58 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"math/rand"
+	"time"
+)
+
+// ChaosConfig configures faults that are injected into the shutdown.
+// It is intended for testing that timeouts are handled as expected,
+// and should not be used in production.
+type ChaosConfig struct {
+	// SlowPercent is the percentage of notifiers and functions,
+	// which are considered finished SlowDelay after they actually finish.
+	SlowPercent int
+	SlowDelay   time.Duration
+
+	// TimeoutStages are stages that will always time out.
+	TimeoutStages []Stage
+}
+
+// SetChaos sets faults to inject into the shutdown.
+// By default no faults are injected. Use an empty ChaosConfig to disable it.
+// See ChaosConfig.
+func SetChaos(c ChaosConfig) {
+	defaultManager.SetChaos(c)
+}
+
+// SetChaos sets faults to inject into the shutdown of the manager.
+func (m *Manager) SetChaos(c ChaosConfig) {
+	m.srM.Lock()
+	m.chaos = c
+	m.srM.Unlock()
+}
+
+// chaosTimeout returns true if the stage should time out.
+func (m *Manager) chaosTimeout(stage int) bool {
+	m.srM.RLock()
+	defer m.srM.RUnlock()
+	for _, s := range m.chaos.TimeoutStages {
+		if s.n == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// chaosDelay returns the time to delay a notifier
+// from being considered finished.
+func (m *Manager) chaosDelay() time.Duration {
+	m.srM.RLock()
+	defer m.srM.RUnlock()
+	if m.chaos.SlowPercent <= 0 || rand.Intn(100) >= m.chaos.SlowPercent {
+		return 0
+	}
+	return m.chaos.SlowDelay
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChaosTimeout(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var stages []int
+	Configure(WithGracefulDegradation(func(stage int) {
+		stages = append(stages, stage)
+	}))
+	SetChaos(ChaosConfig{TimeoutStages: []Stage{Stage1}})
+	SetTimeout(time.Millisecond * 100)
+	lines, restore := logLines()
+	defer restore()
+
+	var first, second bool
+	FirstFunc(setBool, &first)
+	SecondFunc(setBool, &second)
+	tn := time.Now()
+	Shutdown()
+	if dur := time.Since(tn); dur < 100*time.Millisecond {
+		t.Fatal("first stage did not time out", dur)
+	}
+	nextLine(t, lines, "timeout waiting to shutdown, forcing shutdown")
+	if len(stages) != 1 || stages[0] != 1 {
+		t.Fatal("unexpected degradation calls", stages)
+	}
+	if !first || !second {
+		t.Fatal("shutdown functions were not called", first, second)
+	}
+}
+
+// A stage set to time out is run, even if it has no notifiers.
+func TestChaosTimeoutEmpty(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var stages []int
+	Configure(WithGracefulDegradation(func(stage int) {
+		stages = append(stages, stage)
+	}))
+	SetChaos(ChaosConfig{TimeoutStages: []Stage{Stage3}})
+	SetTimeout(time.Millisecond * 100)
+	Shutdown()
+	if len(stages) != 1 || stages[0] != 3 {
+		t.Fatal("unexpected degradation calls", stages)
+	}
+}
+
+func TestChaosSlow(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetChaos(ChaosConfig{SlowPercent: 100, SlowDelay: 200 * time.Millisecond})
+	FirstFunc(func(interface{}) {}, nil)
+	tn := time.Now()
+	Shutdown()
+	// Both the lock drain and the function are delayed.
+	if dur := time.Since(tn); dur < 400*time.Millisecond {
+		t.Fatal("notifiers were not delayed", dur)
+	}
+}
+
+func TestChaosDisabled(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetChaos(ChaosConfig{SlowPercent: 100, SlowDelay: time.Minute, TimeoutStages: []Stage{Stage1}})
+	SetChaos(ChaosConfig{})
+	FirstFunc(func(interface{}) {}, nil)
+	tn := time.Now()
+	Shutdown()
+	if dur := time.Since(tn); dur > 100*time.Millisecond {
+		t.Fatal("shutdown was delayed", dur)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"time"
+)
+
+// Clock provides time to a Manager.
+// It allows tests to control time, see WithClock.
+//
+// Now is the wall clock, which can be stepped, for instance when a
+// virtual machine is resumed. It is only used for reporting.
+// All timeouts are based on Mono, which only moves forward.
+type Clock interface {
+	Now() time.Time
+	Mono() time.Duration
+	NewTimer(d time.Duration) ClockTimer
+}
+
+// ClockTimer is a timer created by a Clock.
+// It works like time.Timer.
+type ClockTimer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// WithClock sets the clock used by the manager for timeouts and reporting.
+// This is intended for tests, where time can then be controlled.
+// The shutdowntest package has a clock that only moves when advanced.
+// The default is the time package. A nil clock is ignored.
+func WithClock(c Clock) Option {
+	return func(m *Manager) {
+		if c != nil {
+			m.clock = c
+		}
+	}
+}
+
+// WithArtificialDelay makes the notifier take at least d to finish during
+// shutdown, measured from when its stage starts waiting, by the clock of
+// the manager. The notifier is returned, so it can be used when registering:
+//
+//	slow := m.First().WithArtificialDelay(10 * time.Second)
+//
+// This is meant for tests using a clock set by WithClock, so a stage
+// timeout can be caused by a specific notifier without waiting.
+// With the default clock it does nothing.
+func (s Notifier) WithArtificialDelay(d time.Duration) Notifier {
+	nM.Lock()
+	if ns := notifiers[s]; ns != nil {
+		ns.delay = d
+	}
+	nM.Unlock()
+	return s
+}
+
+// artificialDelay returns the delay set by WithArtificialDelay for n,
+// or the function notifier it belongs to. m.sqM must be held.
+func (m *Manager) artificialDelay(stage int, n Notifier) time.Duration {
+	if _, ok := m.clock.(realClock); ok {
+		return 0
+	}
+	n = m.client(stage, n)
+	nM.Lock()
+	defer nM.Unlock()
+	if ns := notifiers[n]; ns != nil {
+		return ns.delay
+	}
+	return 0
+}
+
+// realClock is a Clock using the time package.
+type realClock struct{}
+
+// monoStart is the origin of the monotonic time of realClock.
+var monoStart = time.Now()
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// Mono returns the monotonic time since the package was initialized.
+func (realClock) Mono() time.Duration {
+	// time.Since uses the monotonic clock reading of monoStart.
+	return time.Since(monoStart)
+}
+
+func (realClock) NewTimer(d time.Duration) ClockTimer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (r realTimer) C() <-chan time.Time {
+	return r.t.C
+}
+
+func (r realTimer) Stop() bool {
+	return r.t.Stop()
+}
+
+func (r realTimer) Reset(d time.Duration) bool {
+	return r.t.Reset(d)
+}
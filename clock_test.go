@@ -0,0 +1,236 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that only moves when advanced.
+// The wall clock can be stepped independently.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	mono   time.Duration
+	timers []*fakeTimer
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Mono() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mono
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) ClockTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{c: c, ch: make(chan time.Time, 1), when: c.mono + d, active: true}
+	c.timers = append(c.timers, t)
+	c.fire()
+	return t
+}
+
+// Advance moves the clock forward and fires all expired timers.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mono += d
+	c.fire()
+	c.mu.Unlock()
+}
+
+// StepWall changes the wall clock, without moving the monotonic clock.
+func (c *fakeClock) StepWall(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// fire sends on all expired timers. c.mu must be held.
+func (c *fakeClock) fire() {
+	for _, t := range c.timers {
+		if t.active && t.when <= c.mono {
+			t.active = false
+			select {
+			case t.ch <- c.now:
+			default:
+			}
+		}
+	}
+}
+
+// active returns the number of timers that haven't fired or been stopped.
+func (c *fakeClock) active() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, t := range c.timers {
+		if t.active {
+			n++
+		}
+	}
+	return n
+}
+
+// waitTimers waits until n timers are active.
+func (c *fakeClock) waitTimers(t *testing.T, n int) {
+	for i := 0; c.active() != n; i++ {
+		if i > 1000 {
+			t.Fatalf("expected %d active timers, got %d", n, c.active())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+type fakeTimer struct {
+	c      *fakeClock
+	ch     chan time.Time
+	when   time.Duration
+	active bool
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	wasActive := t.active
+	t.active = false
+	t.drain()
+	return wasActive
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	wasActive := t.active
+	t.drain()
+	t.when = t.c.mono + d
+	t.active = true
+	t.c.fire()
+	return wasActive
+}
+
+// drain removes a stale value from the channel.
+func (t *fakeTimer) drain() {
+	select {
+	case <-t.ch:
+	default:
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	c := newFakeClock()
+	start := c.Now()
+	tm := c.NewTimer(time.Second)
+	c.Advance(time.Millisecond * 999)
+	select {
+	case <-tm.C():
+		t.Fatal("timer fired early")
+	default:
+	}
+	c.Advance(time.Millisecond)
+	select {
+	case now := <-tm.C():
+		if now.Sub(start) != time.Second {
+			t.Fatal("unexpected fire time", now.Sub(start))
+		}
+	default:
+		t.Fatal("timer did not fire")
+	}
+	if tm.Reset(time.Second) {
+		t.Fatal("fired timer reported as active")
+	}
+	if !tm.Stop() {
+		t.Fatal("reset timer reported as inactive")
+	}
+	c.Advance(time.Hour)
+	select {
+	case <-tm.C():
+		t.Fatal("stopped timer fired")
+	default:
+	}
+}
+
+// Steps of the wall clock must not affect stage timeouts.
+func TestWallClockStep(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeout(time.Minute)
+	c := newFakeClock()
+	defaultManager.clock = c
+	if d := TimeLeft(Stage1); d != 0 {
+		t.Fatal("time left before shutdown", d)
+	}
+
+	f := First()
+	go func() {
+		<-f
+		// Never finish
+	}()
+	var ctxLeft time.Duration
+	SecondFuncCtx(func(ctx context.Context, _ interface{}) {
+		dl, _ := ctx.Deadline()
+		ctxLeft = time.Until(dl)
+	}, nil)
+	finished := make(chan struct{})
+	go func() {
+		Shutdown()
+		close(finished)
+	}()
+	// Stage timer and warn timer
+	c.waitTimers(t, 2)
+	for _, step := range []time.Duration{time.Hour, -2 * time.Hour} {
+		c.StepWall(step)
+		if d := TimeLeft(Stage1); d != time.Minute {
+			t.Fatal("time left changed by wall clock step", d)
+		}
+	}
+	c.Advance(time.Minute - time.Millisecond)
+	select {
+	case <-finished:
+		t.Fatal("shutdown finished before timeout")
+	case <-time.After(10 * time.Millisecond):
+	}
+	if d := TimeLeft(Stage1); d != time.Millisecond {
+		t.Fatal("unexpected time left", d)
+	}
+	// The warnings and the second stage also use timers.
+	for done := false; !done; {
+		c.Advance(time.Minute)
+		select {
+		case <-finished:
+			done = true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if ctxLeft < 50*time.Second {
+		t.Fatal("context deadline was not based on time left", ctxLeft)
+	}
+}
+
+func TestArtificialDelayRealClock(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	FirstFunc(func(interface{}) {}, nil).WithArtificialDelay(time.Hour)
+	tn := time.Now()
+	Shutdown()
+	if d := time.Since(tn); d > 500*time.Millisecond {
+		t.Fatal("artificial delay used with the real clock", d)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"os/signal"
+	"sync/atomic"
+)
+
+// Close stops the goroutines the package runs for the default manager,
+// and waits for them to exit. See Manager.Close.
+func Close() {
+	defaultManager.Close()
+}
+
+// Close stops the goroutines the package runs for the manager,
+// and waits for them to exit. The manager is inert afterwards.
+//
+// These are the goroutines waiting for signals, see OnSignal, for contexts,
+// see NotifyOnContextCancel, for scheduled shutdowns, for tracked goroutines,
+// for tickers and for registered functions to be called.
+// Notifiers registered with the manager are cancelled, and the outcomes
+// of its last shutdown are forgotten, like with ResetRegistrations,
+// and the manager behaves as if disabled, see Disable,
+// so later registrations return spent notifiers, and Shutdown returns
+// immediately. Domains of the manager are closed as well.
+//
+// If a shutdown is running, Close waits for it to complete first.
+// Functions that are still running after their stage has timed out are
+// not waited for. Goroutines started by Go and RegisterGoroutine run
+// your code, so they are not stopped either.
+// Close can be called more than once. It is mainly intended for tests
+// and applications that create many managers.
+func (m *Manager) Close() {
+	m.closeOnce.Do(m.close)
+}
+
+// close closes the manager, see Close.
+func (m *Manager) close() {
+	m.sqM.Lock()
+	m.srM.Lock()
+	atomic.StoreInt32(&m.disabled, 1)
+	close(m.closed)
+	started, done, domains, signals := m.shutdownRequested, m.done, m.domains, m.signals
+	m.signals = nil
+	m.srM.Unlock()
+	m.sqM.Unlock()
+	if started {
+		<-done
+	}
+
+	for _, c := range signals {
+		// No signals are delivered to c once Stop returns.
+		signal.Stop(c)
+		close(c)
+	}
+	// No shutdown can start, so the registrations can be cancelled.
+	m.srM.Lock()
+	m.shutdownRequested = false
+	m.srM.Unlock()
+	m.cancelRegistrations()
+	for _, d := range domains {
+		d.Close()
+	}
+	m.watchers.Wait()
+}
+
+// isClosed returns true if the manager has been closed, see Close.
+func (m *Manager) isClosed() bool {
+	select {
+	case <-m.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// addWatcher adds a goroutine to the goroutines that Close waits for.
+// The goroutine must return when m.closed is closed, and call m.watchers.Done.
+// If the manager has been closed, false is returned, and the goroutine
+// must not be started.
+func (m *Manager) addWatcher() bool {
+	m.srM.RLock()
+	defer m.srM.RUnlock()
+	if m.isClosed() {
+		return false
+	}
+	m.watchers.Add(1)
+	return true
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"context"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// useWatchers uses every feature of m that runs a goroutine until shutdown.
+func useWatchers(m *Manager, ctx context.Context) {
+	m.OnSignal(0, syscall.SIGUSR2)
+	m.OnSignalWaitStage(0, Stage2, syscall.SIGUSR2)
+	m.NotifyOnContextCancel(ctx, Stage1)
+	m.ScheduleShutdown(time.Now().Add(time.Hour))
+	m.TrackGoroutine(make(chan struct{}))
+	m.NewTrackedContext(ctx)
+	m.Ticker(time.Hour)
+	m.Timer(time.Hour)
+	m.FirstFunc(func(interface{}) {}, nil)
+	m.StopLoop(Stage2)
+	Async(m.Third(), func() {})
+	m.NewDomain("closed").SecondFunc(func(interface{}) {}, nil)
+}
+
+func TestClose(t *testing.T) {
+	defer close(startTimer(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The signal package starts a goroutine the first time it is used.
+	m := NewManager()
+	useWatchers(m, ctx)
+	m.Close()
+	runtime.GC()
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 100; i++ {
+		m := NewManager()
+		useWatchers(m, ctx)
+		m.Close()
+	}
+	// Goroutines waiting for cancelled notifiers, like Async,
+	// are not owned by the manager, so give them time to exit.
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Fatalf("goroutines grew from %d to %d", before, n)
+	}
+}
+
+func TestCloseInert(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var called bool
+	f := FirstFunc(setBool, &called)
+	Close()
+	Close()
+	if Enabled() {
+		t.Fatal("manager enabled after Close")
+	}
+	if v, ok := <-f; ok || v != nil {
+		t.Fatal("function notifier was not cancelled")
+	}
+	if v, ok := <-Second(); ok || v != nil {
+		t.Fatal("notifier is not spent")
+	}
+	Shutdown()
+	if Started() || called {
+		t.Fatal("shutdown ran after Close")
+	}
+}
+
+func TestCloseWaitsForShutdown(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	release := make(chan struct{})
+	running := make(chan struct{})
+	FirstFunc(func(interface{}) {
+		close(running)
+		<-release
+	}, nil)
+	go Shutdown()
+	<-running
+	closed := make(chan struct{})
+	go func() {
+		Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close returned while shutdown was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-closed
+}
+
+func TestCloseAfterLateRegistration(t *testing.T) {
+	defer close(startTimer(t))
+	m := NewManager()
+	m.ThirdFunc(func(interface{}) {
+		// Stage 1 has already run, so the function is never called.
+		m.FirstFunc(func(interface{}) {}, nil)
+	}, nil)
+	m.Shutdown()
+	closed := make(chan struct{})
+	go func() {
+		m.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not return")
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// A ConnTracker tracks live connections of a server, and drains them
+// in a stage of the shutdown.
+//
+// When the stage starts, listeners wrapped with WrapListener are closed,
+// so no new connections are accepted. The stage then waits for the
+// tracked connections to be untracked, until the timeout of the stage.
+// Connections that are still tracked at the deadline are closed.
+type ConnTracker struct {
+	mu        sync.Mutex // Mutex for below
+	conns     map[net.Conn]struct{}
+	listeners []net.Listener
+	draining  bool
+	idle      chan struct{} // Closed when the last connection is untracked while draining.
+}
+
+// NewConnTracker returns a ConnTracker that drains its connections in the given stage.
+func NewConnTracker(s Stage) *ConnTracker {
+	return defaultManager.NewConnTracker(s)
+}
+
+// NewConnTracker returns a ConnTracker that drains its connections in the given stage of the shutdown of the manager.
+func (m *Manager) NewConnTracker(s Stage) *ConnTracker {
+	t := &ConnTracker{conns: make(map[net.Conn]struct{})}
+	m.onFuncCtx(s.n, t.drain, nil)
+	return t
+}
+
+// Track adds c to the connections that are drained.
+// Untrack must be called when c has been closed by its handler.
+// If the stage has already started, c is closed, and false is returned.
+func (t *ConnTracker) Track(c net.Conn) bool {
+	t.mu.Lock()
+	if t.draining {
+		t.mu.Unlock()
+		c.Close()
+		return false
+	}
+	t.conns[c] = struct{}{}
+	t.mu.Unlock()
+	return true
+}
+
+// Untrack removes c from the connections that are drained.
+// It does not close c.
+func (t *ConnTracker) Untrack(c net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.conns[c]; !ok {
+		return
+	}
+	delete(t.conns, c)
+	if len(t.conns) == 0 && t.idle != nil {
+		close(t.idle)
+		t.idle = nil
+	}
+}
+
+// Len returns the number of tracked connections.
+func (t *ConnTracker) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.conns)
+}
+
+// WrapListener returns a listener that tracks the connections accepted by l.
+//
+// The connections are untracked when they are closed. The listener is
+// closed when the stage of the tracker starts, so Accept returns an error,
+// and servers looping on Accept return. If the stage has already started,
+// l is closed right away.
+func (t *ConnTracker) WrapListener(l net.Listener) net.Listener {
+	if l == nil {
+		panic("shutdown: nil listener")
+	}
+	tl := &trackedListener{Listener: l, t: t}
+	t.mu.Lock()
+	if t.draining {
+		t.mu.Unlock()
+		l.Close()
+		return tl
+	}
+	t.listeners = append(t.listeners, tl)
+	t.mu.Unlock()
+	return tl
+}
+
+// drain stops accepting connections, and waits for the tracked
+// connections to be untracked until ctx is done.
+// Connections that are left are closed.
+func (t *ConnTracker) drain(ctx context.Context, _ interface{}) {
+	t.mu.Lock()
+	t.draining = true
+	listeners := t.listeners
+	t.listeners = nil
+	idle := make(chan struct{})
+	if len(t.conns) == 0 {
+		close(idle)
+	} else {
+		t.idle = idle
+	}
+	t.mu.Unlock()
+	for _, l := range listeners {
+		l.Close()
+	}
+
+	select {
+	case <-idle:
+		return
+	case <-ctx.Done():
+	}
+	t.mu.Lock()
+	conns := make([]net.Conn, 0, len(t.conns))
+	for c := range t.conns {
+		conns = append(conns, c)
+	}
+	t.idle = nil
+	t.mu.Unlock()
+	Logger.Printf("ConnTracker: closing %d connections that are still open", len(conns))
+	for _, c := range conns {
+		c.Close()
+		t.Untrack(c)
+	}
+}
+
+// trackedListener tracks the connections it accepts, see WrapListener.
+type trackedListener struct {
+	net.Listener
+	t *ConnTracker
+}
+
+func (l *trackedListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		tc := &trackedConn{Conn: c, t: l.t}
+		if l.t.Track(tc) {
+			return tc, nil
+		}
+		// Closed by Track. The listener is being closed, so Accept fails soon.
+	}
+}
+
+// trackedConn is untracked when it is closed, see WrapListener.
+type trackedConn struct {
+	net.Conn
+	t *ConnTracker
+}
+
+func (c *trackedConn) Close() error {
+	err := c.Conn.Close()
+	c.t.Untrack(c)
+	return err
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnTracker(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	const timeout = 100 * time.Millisecond
+	SetTimeoutN(Stage2, timeout)
+	tracker := NewConnTracker(Stage2)
+
+	// A handler that returns when the client hangs up.
+	server, client := net.Pipe()
+	if !tracker.Track(server) {
+		t.Fatal("connection not tracked")
+	}
+	handled := make(chan struct{})
+	go func() {
+		defer close(handled)
+		defer tracker.Untrack(server)
+		defer server.Close()
+		io.Copy(io.Discard, server)
+	}()
+	// A straggler that is never closed by its handler.
+	straggler, strayClient := net.Pipe()
+	tracker.Track(straggler)
+	if tracker.Len() != 2 {
+		t.Fatal("unexpected number of connections", tracker.Len())
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		client.Close()
+	}()
+	start := time.Now()
+	Shutdown()
+	if d := time.Since(start); d > timeout+time.Second {
+		t.Fatal("shutdown took", d)
+	}
+	<-handled
+	if _, err := strayClient.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatal("straggler not closed", err)
+	}
+	if tracker.Len() != 0 {
+		t.Fatal("connections still tracked", tracker.Len())
+	}
+
+	late, lateClient := net.Pipe()
+	if tracker.Track(late) {
+		t.Fatal("connection tracked after the stage started")
+	}
+	if _, err := lateClient.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatal("late connection not closed", err)
+	}
+}
+
+func TestConnTrackerListener(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	tracker := NewConnTracker(Stage1)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("unable to listen:", err)
+	}
+	l = tracker.WrapListener(l)
+	accepted := make(chan net.Conn)
+	acceptErr := make(chan error)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				acceptErr <- err
+				return
+			}
+			accepted <- c
+		}
+	}()
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	c := <-accepted
+	if tracker.Len() != 1 {
+		t.Fatal("accepted connection not tracked")
+	}
+	// The handler closes the connection when the client hangs up.
+	go func() {
+		io.Copy(io.Discard, c)
+		c.Close()
+	}()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		client.Close()
+	}()
+	Shutdown()
+	select {
+	case <-acceptErr:
+	case <-time.After(time.Second):
+		t.Fatal("listener not closed")
+	}
+	if tracker.Len() != 0 {
+		t.Fatal("connection still tracked")
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"context"
+	"sync"
+)
+
+// ShutdownFnCtx is a shutdown function that is given a context.
+//
+// The context is cancelled when the timeout of the stage the function
+// is executed in expires. Each stage has its own context, so a function
+// in the first stage is never cancelled by the deadline of a later stage.
+type ShutdownFnCtx func(context.Context, interface{})
+
+// PreShutdownFuncCtx registers a function that will be called as soon as the shutdown
+// is signalled, before locks are released.
+// The context given to the function is cancelled when the Preshutdown timeout expires.
+func PreShutdownFuncCtx(fn ShutdownFnCtx, v interface{}) Notifier {
+	return defaultManager.PreShutdownFuncCtx(fn, v)
+}
+
+// PreShutdownFuncCtx registers a context function that is called when shutdown of the manager is signalled.
+func (m *Manager) PreShutdownFuncCtx(fn ShutdownFnCtx, v interface{}) Notifier {
+	return m.onFuncCtx(0, fn, v)
+}
+
+// FirstFuncCtx executes a function in the first stage of the shutdown.
+// The context given to the function is cancelled when the Stage1 timeout expires.
+func FirstFuncCtx(fn ShutdownFnCtx, v interface{}) Notifier {
+	return defaultManager.FirstFuncCtx(fn, v)
+}
+
+// FirstFuncCtx executes a context function in the first stage of the shutdown of the manager.
+func (m *Manager) FirstFuncCtx(fn ShutdownFnCtx, v interface{}) Notifier {
+	return m.onFuncCtx(1, fn, v)
+}
+
+// SecondFuncCtx executes a function in the second stage of the shutdown.
+// The context given to the function is cancelled when the Stage2 timeout expires.
+func SecondFuncCtx(fn ShutdownFnCtx, v interface{}) Notifier {
+	return defaultManager.SecondFuncCtx(fn, v)
+}
+
+// SecondFuncCtx executes a context function in the second stage of the shutdown of the manager.
+func (m *Manager) SecondFuncCtx(fn ShutdownFnCtx, v interface{}) Notifier {
+	return m.onFuncCtx(2, fn, v)
+}
+
+// ThirdFuncCtx executes a function in the third stage of the shutdown.
+// The context given to the function is cancelled when the Stage3 timeout expires.
+func ThirdFuncCtx(fn ShutdownFnCtx, v interface{}) Notifier {
+	return defaultManager.ThirdFuncCtx(fn, v)
+}
+
+// ThirdFuncCtx executes a context function in the third stage of the shutdown of the manager.
+func (m *Manager) ThirdFuncCtx(fn ShutdownFnCtx, v interface{}) Notifier {
+	return m.onFuncCtx(3, fn, v)
+}
+
+// Create a function notifier, that is given a context for the stage.
+func (m *Manager) onFuncCtx(prio int, fn ShutdownFnCtx, v interface{}) Notifier {
+	if fn == nil {
+		panic("shutdown: nil shutdown function")
+	}
+	return m.onFunc(prio, func(v interface{}) {
+		ctx, cancel := m.stageContext(prio)
+		defer cancel()
+		fn(ctx, v)
+	}, v)
+}
+
+// stageContext returns a context that is cancelled when
+// the timeout of the given stage expires.
+// The timeout is based on the time left, so it is not affected
+// by changes to the wall clock.
+func (m *Manager) stageContext(prio int) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), m.untilDeadline(prio))
+}
+
+// NotifyOnContextCancel starts the shutdown when ctx is cancelled,
+// and returns a notifier for the given stage.
+//
+// This can be used when the lifetime of the application is controlled
+// by a context. The returned notifier must be handled like any other
+// notifier of the stage. If shutdown is started by other means first,
+// ctx is no longer watched.
+func NotifyOnContextCancel(ctx context.Context, s Stage) Notifier {
+	return defaultManager.NotifyOnContextCancel(ctx, s)
+}
+
+// NotifyOnContextCancel starts the shutdown of the manager when ctx is cancelled,
+// and returns a notifier for the given stage.
+func (m *Manager) NotifyOnContextCancel(ctx context.Context, s Stage) Notifier {
+	n := m.onShutdown(s.n)
+	m.srM.RLock()
+	done := m.done
+	m.srM.RUnlock()
+	if !m.addWatcher() {
+		return n
+	}
+	go func() {
+		defer m.watchers.Done()
+		select {
+		case <-ctx.Done():
+			m.shutdown(Reason{Cause: "context cancelled: " + ctx.Err().Error()})
+		case <-done:
+		case <-m.closed:
+		}
+	}()
+	return n
+}
+
+// NewTrackedContext returns a context derived from ctx, that holds a lock
+// for its lifetime, see Lock.
+//
+// The lock is released when release is called, or when ctx is cancelled,
+// whichever comes first. release also cancels the returned context,
+// so it must be called when the work has finished, like the cancel
+// function of context.WithCancel. It can be called more than once.
+//
+// If shutdown has already been initiated, no lock is acquired, and the
+// returned context is already cancelled, so the work should not be started.
+// Like other locks, it is waited for until the Preshutdown timeout.
+func NewTrackedContext(ctx context.Context) (tracked context.Context, release func()) {
+	return defaultManager.NewTrackedContext(ctx)
+}
+
+// NewTrackedContext returns a context derived from ctx, that holds a lock of the manager for its lifetime.
+func (m *Manager) NewTrackedContext(ctx context.Context) (tracked context.Context, release func()) {
+	tracked, cancel := context.WithCancel(ctx)
+	unlock, ok := m.BeginWork()
+	if !ok {
+		cancel()
+		return tracked, cancel
+	}
+	var once sync.Once
+	release = func() {
+		cancel()
+		once.Do(unlock)
+	}
+	if !m.addWatcher() {
+		// Closed, so the lock is only released by release.
+		return tracked, release
+	}
+	go func() {
+		defer m.watchers.Done()
+		select {
+		case <-tracked.Done():
+			once.Do(unlock)
+		case <-m.closed:
+		}
+	}()
+	return tracked, release
+}
+
+// ContextPool holds contexts that are cancelled when a stage of the shutdown begins.
+//
+// Workers, clients and connection pools can hold a context from the pool,
+// so they stop automatically when the stage they rely on begins.
+type ContextPool struct {
+	m         *Manager
+	ctx       [4]context.Context
+	cancel    [4]context.CancelFunc
+	notifiers [4]Notifier
+}
+
+// NewContextPool returns a new ContextPool with contexts derived from parent.
+func NewContextPool(parent context.Context) *ContextPool {
+	return defaultManager.NewContextPool(parent)
+}
+
+// NewContextPool returns a new ContextPool for the stages of the manager.
+func (m *Manager) NewContextPool(parent context.Context) *ContextPool {
+	p := &ContextPool{m: m}
+	for stage := 1; stage < len(p.ctx); stage++ {
+		p.ctx[stage], p.cancel[stage] = context.WithCancel(parent)
+		// The contexts are cancelled before the stage signals its
+		// notifiers, see cancelPools. This keeps the stage running.
+		p.notifiers[stage] = m.onFunc(stage, func(interface{}) {}, nil)
+	}
+	m.sqM.Lock()
+	m.pools = append(m.pools, p)
+	m.sqM.Unlock()
+	return p
+}
+
+// Stage1Context returns a context that is cancelled when the first stage begins.
+func (p *ContextPool) Stage1Context() context.Context {
+	return p.ctx[1]
+}
+
+// Stage2Context returns a context that is cancelled when the second stage begins.
+func (p *ContextPool) Stage2Context() context.Context {
+	return p.ctx[2]
+}
+
+// Stage3Context returns a context that is cancelled when the third stage begins.
+func (p *ContextPool) Stage3Context() context.Context {
+	return p.ctx[3]
+}
+
+// Close cancels all contexts of the pool, and removes the pool from the shutdown.
+func (p *ContextPool) Close() {
+	for stage := 1; stage < len(p.ctx); stage++ {
+		p.notifiers[stage].Cancel()
+		p.cancel[stage]()
+	}
+	p.m.sqM.Lock()
+	defer p.m.sqM.Unlock()
+	for i, o := range p.m.pools {
+		if o == p {
+			p.m.pools = append(p.m.pools[:i:i], p.m.pools[i+1:]...)
+			break
+		}
+	}
+}
+
+// cancelPools cancels the contexts of the pools of the manager for the stage.
+// m.sqM must be held.
+func (m *Manager) cancelPools(stage int) {
+	for _, p := range m.pools {
+		if stage > 0 && stage < len(p.cancel) {
+			p.cancel[stage]()
+		}
+	}
+}
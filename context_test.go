@@ -0,0 +1,214 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFnCtx(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	gotcall := false
+
+	// Register a function
+	_ = FirstFuncCtx(func(ctx context.Context, i interface{}) {
+		if ctx.Err() != nil {
+			t.Error("context was cancelled before the function was called")
+		}
+		gotcall = i.(bool)
+	}, true)
+
+	// Start shutdown
+	Shutdown()
+	if !gotcall {
+		t.Fatal("did not get expected shutdown signal")
+	}
+}
+
+func TestFnCtxStageDeadline(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	want := [4]time.Duration{100 * time.Millisecond, 300 * time.Millisecond, 500 * time.Millisecond, 700 * time.Millisecond}
+	SetTimeoutN(Preshutdown, want[0])
+	SetTimeoutN(Stage1, want[1])
+	SetTimeoutN(Stage2, want[2])
+	SetTimeoutN(Stage3, want[3])
+
+	var got [4]time.Duration
+	record := func(ctx context.Context, i interface{}) {
+		dl, ok := ctx.Deadline()
+		if !ok {
+			t.Error("context has no deadline")
+			return
+		}
+		got[i.(int)] = time.Until(dl)
+	}
+	_ = PreShutdownFuncCtx(record, 0)
+	_ = FirstFuncCtx(record, 1)
+	_ = SecondFuncCtx(record, 2)
+	_ = ThirdFuncCtx(record, 3)
+
+	Shutdown()
+	for i := range want {
+		if got[i] > want[i] || got[i] < want[i]-50*time.Millisecond {
+			t.Errorf("stage %d: context deadline was %v, expected %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestFnCtxCancelled(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeout(time.Second)
+	SetTimeoutN(Stage1, 100*time.Millisecond)
+	var err2 error
+	done1 := make(chan error, 1)
+
+	// The first stage times out, but that must not affect the second stage.
+	_ = FirstFuncCtx(func(ctx context.Context, i interface{}) {
+		<-ctx.Done()
+		done1 <- ctx.Err()
+	}, nil)
+	_ = SecondFuncCtx(func(ctx context.Context, i interface{}) {
+		err2 = ctx.Err()
+	}, nil)
+
+	Shutdown()
+	if err1 := <-done1; err1 != context.DeadlineExceeded {
+		t.Fatal("expected first stage context to be cancelled, got", err1)
+	}
+	if err2 != nil {
+		t.Fatal("second stage context was cancelled:", err2)
+	}
+}
+
+func TestNilFuncCtx(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	const want = "shutdown: nil shutdown function"
+	expectPanic(t, want, func() { PreShutdownFuncCtx(nil, nil) })
+	expectPanic(t, want, func() { FirstFuncCtx(nil, nil) })
+	expectPanic(t, want, func() { SecondFuncCtx(nil, nil) })
+	expectPanic(t, want, func() { ThirdFuncCtx(nil, nil) })
+}
+
+func TestContextPool(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	p := NewContextPool(context.Background())
+	ctx := [4]context.Context{nil, p.Stage1Context(), p.Stage2Context(), p.Stage3Context()}
+	var errs [4][4]error
+	check := func(i interface{}) {
+		stage := i.(int)
+		for s := 1; s < 4; s++ {
+			errs[stage][s] = ctx[s].Err()
+		}
+	}
+	_ = PreShutdownFunc(check, 0)
+	_ = FirstFunc(func(interface{}) {}, nil)
+	_ = SecondFunc(check, 2)
+	Shutdown()
+	check(3)
+
+	for s := 1; s < 4; s++ {
+		if errs[0][s] != nil {
+			t.Fatalf("stage %d context cancelled before shutdown", s)
+		}
+	}
+	if errs[2][1] != context.Canceled || errs[2][2] != context.Canceled {
+		t.Fatal("context not cancelled when stage began", errs[2])
+	}
+	if errs[2][3] != nil {
+		t.Fatal("stage 3 context cancelled in stage 2")
+	}
+	if errs[3][3] != context.Canceled {
+		t.Fatal("stage 3 context not cancelled")
+	}
+}
+
+func TestContextPoolParent(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	parent, cancel := context.WithCancel(context.Background())
+	p := NewContextPool(parent)
+	cancel()
+	if p.Stage2Context().Err() != context.Canceled {
+		t.Fatal("context not cancelled with parent")
+	}
+	p.Close()
+
+	p = NewContextPool(context.Background())
+	p.Close()
+	if p.Stage1Context().Err() != context.Canceled {
+		t.Fatal("context not cancelled by Close")
+	}
+	if HasRegistrations(Stage1) {
+		t.Fatal("closed pool is still registered")
+	}
+}
+
+func TestNotifyOnContextCancel(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	f := NotifyOnContextCancel(ctx, Stage1)
+	if Started() {
+		t.Fatal("shutdown started before context was cancelled")
+	}
+	cancel()
+	select {
+	case n := <-f:
+		close(n)
+	case <-time.After(time.Second):
+		t.Fatal("notifier not signalled after context was cancelled")
+	}
+	<-defaultManager.done
+	if r := Stats().Reason; r.Cause != "context cancelled: context canceled" {
+		t.Fatal("unexpected reason", r.Cause)
+	}
+}
+
+func TestNewTrackedContext(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	locks := func() int { return Stats().Locks }
+
+	ctx, release := NewTrackedContext(context.Background())
+	if ctx.Err() != nil || locks() != 1 {
+		t.Fatal("context not tracked", ctx.Err(), locks())
+	}
+	release()
+	release()
+	if ctx.Err() != context.Canceled || locks() != 0 {
+		t.Fatal("release didn't cancel and unlock", ctx.Err(), locks())
+	}
+
+	// Cancelling the parent releases the lock, so shutdown can proceed.
+	parent, cancel := context.WithCancel(context.Background())
+	ctx, release = NewTrackedContext(parent)
+	defer release()
+	done := make(chan struct{})
+	go func() {
+		Shutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("shutdown didn't wait for the tracked context")
+	case <-time.After(20 * time.Millisecond):
+	}
+	cancel()
+	<-done
+	if locks() != 0 {
+		t.Fatal("lock held after the parent was cancelled", locks())
+	}
+
+	ctx, release = NewTrackedContext(context.Background())
+	if ctx.Err() == nil {
+		t.Fatal("context not cancelled after shutdown")
+	}
+	release()
+}
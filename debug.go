@@ -0,0 +1,90 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"fmt"
+	"path"
+	"runtime"
+	"strings"
+	"sync/atomic"
+)
+
+var debugMode int32 // Accessed atomically.
+
+// pkgDir is the directory of the package source.
+var pkgDir string
+
+func init() {
+	_, file, _, _ := runtime.Caller(0)
+	pkgDir = path.Dir(file)
+}
+
+// SetDebugMode enables or disables debug mode.
+//
+// When debug mode is enabled, the file and line where each notifier is
+// created is recorded. It is available from Notifier.CallSite and is
+// added to log messages about the notifier.
+// Debug mode only affects notifiers created after it has been enabled.
+func SetDebugMode(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&debugMode, v)
+}
+
+// callSite returns the file and line of the first caller
+// outside this package, if debug mode is enabled.
+func callSite() string {
+	site, _ := caller()
+	return site
+}
+
+// caller returns the file and line, and the function of the
+// first caller outside this package, if debug mode is enabled.
+func caller() (site, function string) {
+	if atomic.LoadInt32(&debugMode) == 0 {
+		return "", ""
+	}
+	pc := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pc[:runtime.Callers(3, pc)])
+	for {
+		f, more := frames.Next()
+		if path.Dir(f.File) != pkgDir || strings.HasSuffix(f.File, "_test.go") {
+			return fmt.Sprintf("%s:%d", f.File, f.Line), f.Function
+		}
+		if !more {
+			return "", ""
+		}
+	}
+}
+
+// CallSite returns the file and line where the notifier was created.
+// It is only recorded when debug mode is enabled, see SetDebugMode,
+// otherwise an empty string is returned.
+func (s Notifier) CallSite() string {
+	nM.Lock()
+	defer nM.Unlock()
+	ns := notifiers[s]
+	if ns == nil {
+		return ""
+	}
+	return ns.callSite
+}
+
+// SetLogOnComplete enables logging of each notifier when it finishes.
+//
+// During shutdown, a line with the notifier and the time since its
+// stage started is logged when it finishes, so a slow shutdown can
+// be followed as it progresses. It is disabled by default.
+func SetLogOnComplete(enabled bool) {
+	defaultManager.SetLogOnComplete(enabled)
+}
+
+// SetLogOnComplete enables logging of each notifier of the manager when it finishes.
+func (m *Manager) SetLogOnComplete(enabled bool) {
+	m.srM.Lock()
+	m.logOnComplete = enabled
+	m.srM.Unlock()
+}
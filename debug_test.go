@@ -0,0 +1,113 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// line returns the file and line of the caller.
+func line() string {
+	_, file, line, _ := runtime.Caller(1)
+	return fmt.Sprintf("%s:%d", file, line)
+}
+
+func TestCallSite(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	n := First()
+	if site := n.CallSite(); site != "" {
+		t.Fatal("call site recorded without debug mode:", site)
+	}
+
+	SetDebugMode(true)
+	defer SetDebugMode(false)
+	n, want := First(), line()
+	if site := n.CallSite(); site != want {
+		t.Fatalf("expected call site %q, got %q", want, site)
+	}
+	m := NewManager()
+	n, want = m.SecondFunc(func(interface{}) {}, nil), line()
+	if site := n.CallSite(); site != want {
+		t.Fatalf("expected call site %q, got %q", want, site)
+	}
+	d := m.NewDomain("test")
+	n, want = d.ThirdFunc(func(interface{}) {}, nil), line()
+	if site := n.CallSite(); site != want {
+		t.Fatalf("expected call site %q, got %q", want, site)
+	}
+}
+
+func TestCallSiteLogged(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetDebugMode(true)
+	defer SetDebugMode(false)
+	SetTimeout(time.Minute)
+	c := newFakeClock()
+	defaultManager.clock = c
+	lines, restore := logLines()
+	defer restore()
+
+	f, want := First(), line()
+	go func() {
+		<-f
+	}()
+	_, wantFn := FirstFunc(func(interface{}) { panic("test panic") }, nil), line()
+
+	done := make(chan struct{})
+	go func() {
+		Shutdown()
+		close(done)
+	}()
+	l := nextLine(t, lines, "Panic in shutdown function")
+	if !strings.Contains(l, wantFn) {
+		t.Fatalf("panic log does not contain %q: %s", wantFn, l)
+	}
+	// stage timer and warn timer
+	c.waitTimers(t, 2)
+	c.Advance(time.Second)
+	l = nextLine(t, lines, "still waiting")
+	if !strings.Contains(l, fmt.Sprintf("notifier id %d (%s)", f.ID(), want)) {
+		t.Fatalf("stall warning does not contain %q: %s", want, l)
+	}
+	c.Advance(time.Minute)
+	<-done
+}
+
+func TestLogOnComplete(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetLogOnComplete(true)
+	lines, restore := logLines()
+	defer restore()
+	want := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		n := FirstFunc(func(interface{}) {}, nil)
+		want[fmt.Sprintf("notifier id %d finished after", n.ID())] = true
+	}
+	_ = SecondFunc(func(interface{}) {}, nil)
+	Shutdown()
+
+	nextLine(t, lines, "Shutdown stage 1")
+	for i := 0; i < 3; i++ {
+		l := nextLine(t, lines, "finished after")
+		found := false
+		for w := range want {
+			if strings.Contains(l, "Stage 1: "+w) {
+				delete(want, w)
+				found = true
+			}
+		}
+		if !found {
+			t.Fatal("unexpected completion line", l)
+		}
+	}
+	// The next stage is logged after the completions of the first.
+	nextLine(t, lines, "Shutdown stage 2")
+	nextLine(t, lines, "Stage 2: notifier id")
+}
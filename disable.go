@@ -0,0 +1,80 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrInUse is returned by Disable when the manager is already in use.
+var ErrInUse = errors.New("shutdown: manager is already in use")
+
+// spentNotifier is returned by registrations when the manager is disabled.
+// It is closed, like a cancelled notifier, so waiting for it doesn't block.
+var spentNotifier = make(Notifier)
+
+// spentDone is returned by ShutdownAsync when the manager is disabled.
+var spentDone = make(chan struct{})
+
+func init() {
+	close(spentNotifier)
+	close(spentDone)
+}
+
+// Disable turns the default manager into a no-op.
+//
+// This is meant for libraries that register with this package, when
+// the application using them doesn't. The application calls Disable
+// before the libraries are set up, and the libraries can then call the
+// package unconditionally, without their registrations accumulating:
+//   - Registrations return a closed notifier, like a cancelled notifier,
+//     and functions given to them are never called.
+//   - Lock and BeginWork always succeed, and releasing does nothing.
+//   - Shutdown returns immediately, and Exit exits right away.
+//
+// The manager can't be enabled again. If notifiers have already been
+// registered, locks are held or shutdown has started, ErrInUse is returned
+// and the manager is not disabled.
+func Disable() error {
+	return defaultManager.Disable()
+}
+
+// Disable turns the manager into a no-op, see Disable.
+func (m *Manager) Disable() error {
+	m.sqM.Lock()
+	defer m.sqM.Unlock()
+	if m.isDisabled() {
+		return nil
+	}
+	for stage := range m.shutdownQueue {
+		if len(m.shutdownQueue[stage]) > 0 {
+			return ErrInUse
+		}
+	}
+	if m.Started() || m.locks.held() > 0 {
+		return ErrInUse
+	}
+	for _, l := range m.stageLocks {
+		if l.held() > 0 {
+			return ErrInUse
+		}
+	}
+	atomic.StoreInt32(&m.disabled, 1)
+	return nil
+}
+
+// Enabled returns false if the default manager has been disabled with Disable.
+func Enabled() bool {
+	return defaultManager.Enabled()
+}
+
+// Enabled returns false if the manager has been disabled.
+func (m *Manager) Enabled() bool {
+	return !m.isDisabled()
+}
+
+// isDisabled returns true if the manager has been disabled.
+func (m *Manager) isDisabled() bool {
+	return atomic.LoadInt32(&m.disabled) != 0
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDisable(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	if !Enabled() {
+		t.Fatal("manager disabled by default")
+	}
+	if err := Disable(); err != nil {
+		t.Fatal(err)
+	}
+	if err := Disable(); err != nil {
+		t.Fatal("disabling twice failed", err)
+	}
+	if Enabled() {
+		t.Fatal("manager not disabled")
+	}
+
+	f := First()
+	if v, ok := <-f; ok || v != nil {
+		t.Fatal("notifier is not spent")
+	}
+	var called bool
+	fn := SecondFunc(setBool, &called)
+	fn.Cancel()
+	if HasRegistrations(Stage1) || HasRegistrations(Stage2) {
+		t.Fatal("registrations were kept")
+	}
+
+	if !Lock() || !Lock() {
+		t.Fatal("lock failed")
+	}
+	Unlock()
+	release, ok := BeginWork()
+	if !ok {
+		t.Fatal("BeginWork failed")
+	}
+	release()
+	if !LockStage(Stage3) {
+		t.Fatal("LockStage failed")
+	}
+	tok, ok := LockWithToken()
+	if !ok {
+		t.Fatal("LockWithToken failed")
+	}
+	tok.SetNote("held")
+
+	finished := make(chan struct{})
+	go func() {
+		Shutdown()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("shutdown waited for locks")
+	}
+	if Started() || called {
+		t.Fatal("shutdown was run")
+	}
+	tok.Unlock()
+	Unlock()
+	UnlockStage(Stage3)
+}
+
+func TestDisableInUse(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	f := First()
+	if err := Disable(); err != ErrInUse {
+		t.Fatal("unexpected error", err)
+	}
+	f.Cancel()
+
+	if !Lock() {
+		t.Fatal("lock failed")
+	}
+	if err := Disable(); err != ErrInUse {
+		t.Fatal("unexpected error", err)
+	}
+	Unlock()
+	if !Enabled() {
+		t.Fatal("manager was disabled")
+	}
+	if err := Disable(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"context"
+	"sync"
+)
+
+// A Domain is a named part of an application, that can be shut down
+// separately from the rest of the application.
+//
+// A Domain has its own notifiers and timeouts, and the full registration
+// API of a Manager. Locks are shared with the manager that created it,
+// so shutdown of a domain will not wait for locks to be released,
+// and Lock on a domain will fail if either the domain or the
+// manager that created it has been shut down. This applies to
+// all locks, like BeginWork, LockWithToken, LockStage, Go,
+// TrackGoroutine and NewTrackedContext.
+//
+// When the manager that created the domain is shut down, all domains
+// that have not been shut down are shut down when the Preshutdown stage
+// of the manager has completed, so the locks have been released, and
+// before the next stage starts. The Preshutdown stage always runs, since
+// it waits for the locks, so this always happens. See WithParallelDomains.
+type Domain struct {
+	*Manager
+	name   string
+	parent *Manager
+}
+
+// NewDomain creates a new Domain with the given name on the default manager.
+func NewDomain(name string) *Domain {
+	return defaultManager.NewDomain(name)
+}
+
+// NewDomain creates a new Domain with the given name.
+// The domain uses the timeouts of the manager at the time of creation.
+func (m *Manager) NewDomain(name string) *Domain {
+	d := &Domain{Manager: NewManager(), name: name, parent: m}
+	m.srM.Lock()
+	d.timeout = m.timeout
+	d.timeouts = m.timeouts
+	m.domains = append(m.domains, d)
+	m.srM.Unlock()
+	return d
+}
+
+// Name returns the name of the domain.
+func (d *Domain) Name() string {
+	return d.name
+}
+
+// Shutdown will signal all notifiers of the domain in three stages.
+// Other domains and the manager that created the domain are not affected.
+func (d *Domain) Shutdown() {
+	Logger.Println("Shutting down domain", d.name)
+	d.Manager.Shutdown()
+}
+
+// Lock will signal that you have a function running,
+// that you do not want to be interrupted by a shutdown.
+// The lock is held on the manager that created the domain.
+//
+// If the function returns false either the domain or the manager that created it
+// has been shut down, and you did not get a lock.
+func (d *Domain) Lock() bool {
+	if d.Started() {
+		return false
+	}
+	return d.parent.Lock()
+}
+
+// Unlock will release a lock acquired with Lock.
+func (d *Domain) Unlock() {
+	d.parent.Unlock()
+}
+
+// BeginWork works like Lock, but returns a function that releases the lock.
+// The lock is held on the manager that created the domain.
+func (d *Domain) BeginWork() (release func(), ok bool) {
+	if d.Started() {
+		return nil, false
+	}
+	return d.parent.BeginWork()
+}
+
+// LockWithToken works like Lock, but returns a token that releases the lock.
+// The lock is held on the manager that created the domain.
+func (d *Domain) LockWithToken() (*LockToken, bool) {
+	if d.Started() {
+		return nil, false
+	}
+	return d.parent.LockWithToken()
+}
+
+// LockStage works like Lock, but the given stage of the manager
+// that created the domain waits for the lock to be released.
+func (d *Domain) LockStage(s Stage) bool {
+	if d.Started() {
+		return false
+	}
+	return d.parent.LockStage(s)
+}
+
+// UnlockStage will release a lock acquired with LockStage.
+func (d *Domain) UnlockStage(s Stage) {
+	d.parent.UnlockStage(s)
+}
+
+// Go starts fn in a new goroutine, that holds a lock on the manager
+// that created the domain while it runs.
+func (d *Domain) Go(fn func()) bool {
+	if fn == nil {
+		panic("shutdown: nil function")
+	}
+	if d.Started() {
+		return false
+	}
+	return d.parent.Go(fn)
+}
+
+// TrackGoroutine holds a lock on the manager that created the domain,
+// until done is closed.
+func (d *Domain) TrackGoroutine(done <-chan struct{}) bool {
+	if d.Started() {
+		return false
+	}
+	return d.parent.TrackGoroutine(done)
+}
+
+// NewTrackedContext returns a context derived from ctx, that holds a lock
+// on the manager that created the domain for its lifetime.
+func (d *Domain) NewTrackedContext(ctx context.Context) (tracked context.Context, release func()) {
+	if d.Started() {
+		tracked, cancel := context.WithCancel(ctx)
+		cancel()
+		return tracked, cancel
+	}
+	return d.parent.NewTrackedContext(ctx)
+}
+
+// shutdownDomains shuts down all domains of the manager
+// that haven't been shut down.
+func (m *Manager) shutdownDomains() {
+	m.srM.RLock()
+	domains := append([]*Domain(nil), m.domains...)
+	parallel := m.parallelDomains
+	m.srM.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, d := range domains {
+		if d.Started() {
+			continue
+		}
+		if !parallel {
+			d.Shutdown()
+			continue
+		}
+		wg.Add(1)
+		go func(d *Domain) {
+			defer wg.Done()
+			d.Shutdown()
+		}(d)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDomain(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	blue := NewDomain("blue")
+	green := NewDomain("green")
+	if blue.Name() != "blue" {
+		t.Fatal("unexpected domain name", blue.Name())
+	}
+	var gotBlue, gotGreen, gotDefault bool
+	blue.FirstFunc(setBool, &gotBlue)
+	green.FirstFunc(setBool, &gotGreen)
+	FirstFunc(setBool, &gotDefault)
+
+	blue.Shutdown()
+	if !gotBlue || gotGreen || gotDefault {
+		t.Fatal("unexpected shutdown signals", gotBlue, gotGreen, gotDefault)
+	}
+	if Started() || green.Started() {
+		t.Fatal("shutdown of domain started other domains")
+	}
+	if blue.Lock() {
+		t.Fatal("got lock on domain that has been shut down")
+	}
+	if !green.Lock() {
+		t.Fatal("unable to lock domain that is running")
+	}
+	green.Unlock()
+
+	Shutdown()
+	if !gotGreen || !gotDefault {
+		t.Fatal("unexpected shutdown signals", gotGreen, gotDefault)
+	}
+	if !green.Started() {
+		t.Fatal("domain not shut down by process shutdown")
+	}
+	if green.Lock() {
+		t.Fatal("got lock on domain after shutdown")
+	}
+}
+
+func TestDomainLocks(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	d := NewDomain("worker")
+	if !d.Lock() {
+		t.Fatal("unable to lock domain")
+	}
+	var mu sync.Mutex
+	unlocked := false
+	d.FirstFunc(func(interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !unlocked {
+			t.Error("domain was shut down before lock was released")
+		}
+	}, nil)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		mu.Lock()
+		unlocked = true
+		mu.Unlock()
+		d.Unlock()
+	}()
+	Shutdown()
+}
+
+func TestDomainOrder(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var order []string
+	for _, name := range []string{"a", "b", "c"} {
+		d := NewDomain(name)
+		d.FirstFunc(func(interface{}) {
+			order = append(order, d.Name())
+		}, nil)
+	}
+	Shutdown()
+	if len(order) != 3 || order[0] != "a" || order[1] != "b" || order[2] != "c" {
+		t.Fatal("unexpected domain order", order)
+	}
+}
+
+func TestDomainParallel(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	Configure(WithParallelDomains(true))
+	for i := 0; i < 3; i++ {
+		d := NewDomain("slow")
+		d.FirstFunc(func(interface{}) {
+			time.Sleep(200 * time.Millisecond)
+		}, nil)
+	}
+	tn := time.Now()
+	Shutdown()
+	if dur := time.Since(tn); dur > 500*time.Millisecond {
+		t.Fatal("domains were not shut down in parallel", dur)
+	}
+}
+
+func TestDomainLocksForwarded(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	m := NewManager()
+	d := m.NewDomain("forward")
+	locks := func() int { return m.Stats().Locks }
+
+	release, ok := d.BeginWork()
+	if !ok || locks() != 1 {
+		t.Fatal("BeginWork not held on the manager", locks())
+	}
+	release()
+	token, ok := d.LockWithToken()
+	if !ok || locks() != 1 {
+		t.Fatal("LockWithToken not held on the manager", locks())
+	}
+	token.Unlock()
+	if !d.LockStage(Stage2) || m.stageLocks[Stage2.n].held() != 1 {
+		t.Fatal("LockStage not held on the manager")
+	}
+	d.UnlockStage(Stage2)
+	stop := make(chan struct{})
+	if !d.Go(func() { <-stop }) || locks() != 1 {
+		t.Fatal("Go not held on the manager", locks())
+	}
+	close(stop)
+	done := make(chan struct{})
+	if !d.TrackGoroutine(done) {
+		t.Fatal("TrackGoroutine failed")
+	}
+	ctx, cancel := d.NewTrackedContext(context.Background())
+	if ctx.Err() != nil {
+		t.Fatal("tracked context cancelled")
+	}
+	cancel()
+	close(done)
+	deadline := time.Now().Add(time.Second)
+	for locks() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if locks() != 0 {
+		t.Fatal("locks not released", locks())
+	}
+
+	// Once the domain is shut down, no locks are acquired.
+	d.Shutdown()
+	if _, ok := d.BeginWork(); ok {
+		t.Fatal("BeginWork after domain shutdown")
+	}
+	if _, ok := d.LockWithToken(); ok {
+		t.Fatal("LockWithToken after domain shutdown")
+	}
+	if d.LockStage(Stage2) {
+		t.Fatal("LockStage after domain shutdown")
+	}
+	if d.Go(func() {}) {
+		t.Fatal("Go after domain shutdown")
+	}
+	if d.TrackGoroutine(done) {
+		t.Fatal("TrackGoroutine after domain shutdown")
+	}
+	if ctx, _ := d.NewTrackedContext(context.Background()); ctx.Err() == nil {
+		t.Fatal("tracked context after domain shutdown not cancelled")
+	}
+	if locks() != 0 {
+		t.Fatal("locks acquired after domain shutdown", locks())
+	}
+}
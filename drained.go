@@ -0,0 +1,68 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+// OnAllDrained adds a function that is called during shutdown,
+// when the last registered notifier or function has finished.
+//
+// This happens right after the stage waited for it, before the minimum
+// duration of the stage, see SetStageMinDuration, and before the
+// following stages, which have nothing to wait for. If nothing is
+// registered when shutdown starts, the functions are called right away.
+// Notifiers that were abandoned, because their stage timed out,
+// count as finished. Functions are called once per shutdown,
+// in the order they were added.
+//
+// Call the returned function to remove the function, for instance when the
+// module that added it is unloaded. If the function has already been called,
+// it has no effect on that shutdown.
+func OnAllDrained(fn func()) (cancel func()) {
+	return defaultManager.OnAllDrained(fn)
+}
+
+// OnAllDrained adds a function that is called when the last registration of the manager has finished.
+func (m *Manager) OnAllDrained(fn func()) (cancel func()) {
+	if fn == nil {
+		panic("shutdown: nil drained function")
+	}
+	drained := &fn
+	m.srM.Lock()
+	m.allDrainedFns = append(m.allDrainedFns, drained)
+	m.srM.Unlock()
+	return func() {
+		m.srM.Lock()
+		defer m.srM.Unlock()
+		for i, f := range m.allDrainedFns {
+			if f == drained {
+				m.allDrainedFns = append(m.allDrainedFns[:i:i], m.allDrainedFns[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// registeredAfter returns true if notifiers are registered
+// in the stages that run after position pos in the order.
+func (m *Manager) registeredAfter(pos int) bool {
+	m.srM.RLock()
+	order := m.order
+	m.srM.RUnlock()
+	m.sqM.Lock()
+	defer m.sqM.Unlock()
+	for _, stage := range order[pos+1:] {
+		if len(m.shutdownQueue[stage]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// allDrained calls the functions added by OnAllDrained.
+func (m *Manager) allDrained() {
+	m.srM.RLock()
+	fns := m.allDrainedFns
+	m.srM.RUnlock()
+	for _, fn := range fns {
+		(*fn)()
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOnAllDrained(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var mu sync.Mutex
+	var events []string
+	record := func(event string) {
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+	}
+	SetStageMinDuration(Stage3, 50*time.Millisecond)
+	PreShutdownFunc(func(interface{}) { record("preshutdown") }, nil)
+	FirstFunc(func(interface{}) { record("first") }, nil)
+	ThirdFunc(func(interface{}) {
+		time.Sleep(10 * time.Millisecond)
+		record("third")
+	}, nil)
+	var drainedAt time.Time
+	OnAllDrained(func() {
+		drainedAt = time.Now()
+		record("drained")
+	})
+	Shutdown()
+	// Called before the minimum duration of the stage.
+	if d := time.Since(drainedAt); d < 40*time.Millisecond {
+		t.Fatalf("called %v before shutdown returned, expected it before the minimum duration", d)
+	}
+	want := []string{"preshutdown", "first", "third", "drained"}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("got events %q, want %q", events, want)
+	}
+}
+
+func TestOnAllDrainedEmpty(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	called := 0
+	OnAllDrained(func() { called++ })
+	Shutdown()
+	if called != 1 {
+		t.Fatal("expected one call, got", called)
+	}
+	expectPanic(t, "shutdown: nil drained function", func() { OnAllDrained(nil) })
+}
+
+func TestOnAllDrainedCancel(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var called []string
+	cancel := OnAllDrained(func() { called = append(called, "cancelled") })
+	OnAllDrained(func() { called = append(called, "kept") })
+	cancel()
+	cancel()
+	Shutdown()
+	if len(called) != 1 || called[0] != "kept" {
+		t.Fatal("unexpected calls", called)
+	}
+
+	// Cancelling after the function was called has no effect on that shutdown.
+	Reset()
+	called = nil
+	cancel = OnAllDrained(func() { called = append(called, "again") })
+	Shutdown()
+	cancel()
+	if len(called) != 2 {
+		t.Fatal("unexpected calls", called)
+	}
+}
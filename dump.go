@@ -0,0 +1,152 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strconv"
+)
+
+// DumpPending writes the notifiers registered in each stage to w.
+//
+// If shutdown is in progress, the notifiers the running stage is
+// waiting for are written, and the stacks of the shutdown functions
+// that are running. This can be used to find out what shutdown is
+// waiting for, for instance when a signal is received.
+func DumpPending(w io.Writer) error {
+	return defaultManager.DumpPending(w)
+}
+
+// DumpPending writes the notifiers registered with the manager to w.
+func (m *Manager) DumpPending(w io.Writer) error {
+	var buf bytes.Buffer
+	m.srM.RLock()
+	order := m.order
+	m.srM.RUnlock()
+	m.sqM.Lock()
+	for _, stage := range order {
+		labels := m.labels(stage, nil)
+		fmt.Fprintf(&buf, "Stage %s: %d registered\n", stageName(stage), len(labels))
+		for _, l := range labels {
+			fmt.Fprintf(&buf, "\t%s\n", l)
+		}
+	}
+	m.sqM.Unlock()
+
+	m.srM.RLock()
+	started := m.shutdownRequested
+	stage := -1
+	if m.current >= 0 {
+		stage = m.order[m.current]
+	}
+	order = m.order
+	waiting := m.waiting
+	running := make(map[uint64]Notifier, len(m.running))
+	for n, id := range m.running {
+		running[id] = n
+	}
+	m.srM.RUnlock()
+
+	if held := m.locks.held(); held > 0 {
+		fmt.Fprintf(&buf, "Locks: %d held\n", held)
+		for _, note := range m.lockNotes() {
+			fmt.Fprintf(&buf, "\t%s\n", note)
+		}
+	}
+	if started && stage >= 0 {
+		// Stages that run concurrently are waiting too, see SetParallelStages.
+		for _, st := range order {
+			if st != stage && len(waiting[st]) == 0 {
+				continue
+			}
+			fmt.Fprintf(&buf, "Shutdown in progress, %s stage is waiting for %d notifiers\n", stageName(st), len(waiting[st]))
+			for _, l := range waiting[st] {
+				fmt.Fprintf(&buf, "\t%s\n", l)
+			}
+		}
+	}
+	if len(running) > 0 {
+		fmt.Fprintf(&buf, "Running shutdown functions:\n")
+		stacks := goroutineStacks()
+		ids := make([]uint64, 0, len(running))
+		for id := range running {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		for _, id := range ids {
+			fmt.Fprintf(&buf, "notifier %s:\n%s\n\n", describe(running[id]), stacks[id])
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// setWaiting records the notifiers a running stage is waiting for.
+func (m *Manager) setWaiting(stage int, labels []string, finished []bool) {
+	var waiting []string
+	for i, l := range labels {
+		if !finished[i] {
+			waiting = append(waiting, l)
+		}
+	}
+	m.srM.Lock()
+	m.waiting[stage] = waiting
+	m.srM.Unlock()
+}
+
+// trackRunning records that the function of n is running in the
+// calling goroutine. Call the returned function when it returns.
+func (m *Manager) trackRunning(n Notifier) func() {
+	id := goroutineID()
+	m.srM.Lock()
+	if m.running == nil {
+		m.running = make(map[Notifier]uint64)
+	}
+	m.running[n] = id
+	m.srM.Unlock()
+	return func() {
+		m.srM.Lock()
+		delete(m.running, n)
+		m.srM.Unlock()
+	}
+}
+
+// goroutineID returns the id of the calling goroutine,
+// as written in stack traces.
+func goroutineID() uint64 {
+	b := make([]byte, 64)
+	b = b[:runtime.Stack(b, false)]
+	return parseGoroutineID(b)
+}
+
+// parseGoroutineID returns the id of a goroutine from the first line of its stack.
+func parseGoroutineID(b []byte) uint64 {
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
+
+// goroutineStacks returns the stacks of all goroutines by id.
+func goroutineStacks() map[uint64]string {
+	b := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(b, true)
+		if n < len(b) {
+			b = b[:n]
+			break
+		}
+		b = make([]byte, len(b)*2)
+	}
+	stacks := make(map[uint64]string)
+	for _, s := range bytes.Split(b, []byte("\n\n")) {
+		stacks[parseGoroutineID(s)] = string(s)
+	}
+	return stacks
+}
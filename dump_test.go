@@ -0,0 +1,60 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func slowShutdownFunction(release chan struct{}) {
+	<-release
+}
+
+func TestDumpPending(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	f := First()
+	var buf bytes.Buffer
+	if err := DumpPending(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("Stage first: 1 registered\n\tnotifier id %d\n", f.ID())
+	if !strings.Contains(buf.String(), want) || strings.Contains(buf.String(), "Shutdown in progress") {
+		t.Fatal("unexpected output", buf.String())
+	}
+
+	release := make(chan struct{})
+	slow := SecondFunc(func(interface{}) { slowShutdownFunction(release) }, nil).ID()
+	go func() {
+		close(<-f)
+	}()
+	finished := make(chan struct{})
+	go func() {
+		Shutdown()
+		close(finished)
+	}()
+	for i := 0; !strings.Contains(buf.String(), "second stage"); i++ {
+		if i > 1000 {
+			t.Fatal("second stage not running", buf.String())
+		}
+		time.Sleep(time.Millisecond)
+		buf.Reset()
+		if err := DumpPending(&buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := buf.String()
+	close(release)
+	<-finished
+	want = fmt.Sprintf("second stage is waiting for 1 notifiers\n\tnotifier id %d\n", slow)
+	if !strings.Contains(out, want) {
+		t.Fatal("waiting notifier not in output", out)
+	}
+	if !strings.Contains(out, fmt.Sprintf("notifier id %d:\ngoroutine ", slow)) || !strings.Contains(out, "slowShutdownFunction") {
+		t.Fatal("stack of running function not in output", out)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// envStageTimeouts are the environment variables, without prefix,
+// with the timeout of each stage, see NewFromEnv.
+var envStageTimeouts = [numStages]string{
+	"SHUTDOWN_PRESHUTDOWN_TIMEOUT",
+	"SHUTDOWN_STAGE1_TIMEOUT",
+	"SHUTDOWN_STAGE2_TIMEOUT",
+	"SHUTDOWN_STAGE3_TIMEOUT",
+	"SHUTDOWN_READONLY_TIMEOUT",
+}
+
+// NewFromEnv returns a new Manager configured from environment variables.
+//
+// With the prefix "MYAPP", the variables are named like MYAPP_SHUTDOWN_TIMEOUT.
+// With an empty prefix, they are named like SHUTDOWN_TIMEOUT.
+// The variables are:
+//   - SHUTDOWN_TIMEOUT: the timeout of all stages, see SetTimeout.
+//   - SHUTDOWN_PRESHUTDOWN_TIMEOUT, SHUTDOWN_STAGE1_TIMEOUT, SHUTDOWN_STAGE2_TIMEOUT,
+//     SHUTDOWN_STAGE3_TIMEOUT and SHUTDOWN_READONLY_TIMEOUT: the timeout of a stage, see SetTimeoutN.
+//   - SHUTDOWN_GRACE_PERIOD: the maximum time of the whole shutdown, see SetHardTimeout.
+//
+// Values are durations, like "10s", see time.ParseDuration, and must be positive.
+// Variables that are not set or empty keep the default.
+// The options are applied before the environment is read.
+// If a value is invalid, an error naming the variable is returned.
+func NewFromEnv(prefix string, opts ...Option) (*Manager, error) {
+	m := NewManager(opts...)
+	if prefix != "" {
+		prefix += "_"
+	}
+	if d, ok, err := envDuration(prefix + "SHUTDOWN_TIMEOUT"); err != nil {
+		return nil, err
+	} else if ok {
+		m.SetTimeout(d)
+	}
+	for stage, name := range envStageTimeouts {
+		if d, ok, err := envDuration(prefix + name); err != nil {
+			return nil, err
+		} else if ok {
+			m.SetTimeoutN(Stage{stage}, d)
+		}
+	}
+	if d, ok, err := envDuration(prefix + "SHUTDOWN_GRACE_PERIOD"); err != nil {
+		return nil, err
+	} else if ok {
+		m.SetHardTimeout(d)
+	}
+	return m, nil
+}
+
+// envDuration returns the duration in the environment variable.
+// If the variable is not set or empty, ok is false.
+func envDuration(name string) (d time.Duration, ok bool, err error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0, false, nil
+	}
+	d, err = time.ParseDuration(v)
+	if err != nil {
+		return 0, false, fmt.Errorf("shutdown: %s: invalid duration %q, use a value like \"10s\"", name, v)
+	}
+	if d <= 0 {
+		return 0, false, fmt.Errorf("shutdown: %s: duration must be positive, got %v", name, d)
+	}
+	return d, true, nil
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewFromEnv(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	t.Setenv("MYAPP_SHUTDOWN_TIMEOUT", "2s")
+	t.Setenv("MYAPP_SHUTDOWN_STAGE1_TIMEOUT", "3s")
+	t.Setenv("MYAPP_SHUTDOWN_GRACE_PERIOD", "1m")
+	// Without the prefix, the variable is not used.
+	t.Setenv("SHUTDOWN_STAGE2_TIMEOUT", "4s")
+	m, err := NewFromEnv("MYAPP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]time.Duration{0: 2 * time.Second, 1: 3 * time.Second, 2: 2 * time.Second}
+	for stage, d := range want {
+		if got := m.effectiveTimeout(stage); got != d {
+			t.Errorf("stage %d: got timeout %v, want %v", stage, got, d)
+		}
+	}
+	if m.hardTimeout != time.Minute {
+		t.Errorf("got hard timeout %v, want %v", m.hardTimeout, time.Minute)
+	}
+
+	m, err = NewFromEnv("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.effectiveTimeout(2); got != 4*time.Second {
+		t.Errorf("got timeout %v, want %v", got, 4*time.Second)
+	}
+}
+
+func TestNewFromEnvInvalid(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	for _, v := range []string{"abc", "10", "-1s", "0s"} {
+		t.Setenv("MYAPP_SHUTDOWN_STAGE3_TIMEOUT", v)
+		m, err := NewFromEnv("MYAPP")
+		if err == nil || m != nil {
+			t.Fatalf("%q: expected error", v)
+		}
+		if !strings.Contains(err.Error(), "MYAPP_SHUTDOWN_STAGE3_TIMEOUT") {
+			t.Errorf("%q: error doesn't name the variable: %v", v, err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+// ShutdownFnErr is a shutdown function that can return an error.
+type ShutdownFnErr func(interface{}) error
+
+// PreShutdownFuncWithError registers a function that will be called as soon as the shutdown
+// is signalled, before locks are released.
+// If the function returns an error, it is sent on errs.
+// See FirstFuncWithError for details.
+func PreShutdownFuncWithError(fn ShutdownFnErr, v interface{}, errs chan<- error) Notifier {
+	return defaultManager.PreShutdownFuncWithError(fn, v, errs)
+}
+
+// PreShutdownFuncWithError registers an error returning function that is called when shutdown of the manager is signalled.
+func (m *Manager) PreShutdownFuncWithError(fn ShutdownFnErr, v interface{}, errs chan<- error) Notifier {
+	return m.onFuncErr(0, fn, v, errs)
+}
+
+// FirstFuncWithError executes a function in the first stage of the shutdown.
+// If the function returns an error, it is sent on errs.
+//
+// The channel must be buffered, so sending does not block the shutdown.
+// If the channel is full when an error is returned, the error is logged
+// and dropped instead. A nil or unbuffered channel panics.
+func FirstFuncWithError(fn ShutdownFnErr, v interface{}, errs chan<- error) Notifier {
+	return defaultManager.FirstFuncWithError(fn, v, errs)
+}
+
+// FirstFuncWithError executes an error returning function in the first stage of the shutdown of the manager.
+func (m *Manager) FirstFuncWithError(fn ShutdownFnErr, v interface{}, errs chan<- error) Notifier {
+	return m.onFuncErr(1, fn, v, errs)
+}
+
+// SecondFuncWithError executes a function in the second stage of the shutdown.
+// If the function returns an error, it is sent on errs.
+// See FirstFuncWithError for details.
+func SecondFuncWithError(fn ShutdownFnErr, v interface{}, errs chan<- error) Notifier {
+	return defaultManager.SecondFuncWithError(fn, v, errs)
+}
+
+// SecondFuncWithError executes an error returning function in the second stage of the shutdown of the manager.
+func (m *Manager) SecondFuncWithError(fn ShutdownFnErr, v interface{}, errs chan<- error) Notifier {
+	return m.onFuncErr(2, fn, v, errs)
+}
+
+// ThirdFuncWithError executes a function in the third stage of the shutdown.
+// If the function returns an error, it is sent on errs.
+// See FirstFuncWithError for details.
+func ThirdFuncWithError(fn ShutdownFnErr, v interface{}, errs chan<- error) Notifier {
+	return defaultManager.ThirdFuncWithError(fn, v, errs)
+}
+
+// ThirdFuncWithError executes an error returning function in the third stage of the shutdown of the manager.
+func (m *Manager) ThirdFuncWithError(fn ShutdownFnErr, v interface{}, errs chan<- error) Notifier {
+	return m.onFuncErr(3, fn, v, errs)
+}
+
+// Create a function notifier, that sends the error returned by the function.
+func (m *Manager) onFuncErr(prio int, fn ShutdownFnErr, v interface{}, errs chan<- error) Notifier {
+	if fn == nil {
+		panic("shutdown: nil shutdown function")
+	}
+	if cap(errs) == 0 {
+		panic("shutdown: error channel must be buffered")
+	}
+	return m.onFunc(prio, func(v interface{}) {
+		err := fn(v)
+		if err == nil {
+			return
+		}
+		select {
+		case errs <- err:
+		default:
+			Logger.Println("Error channel full, dropping error:", err)
+		}
+	}, v)
+}
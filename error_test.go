@@ -0,0 +1,55 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFnWithError(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	errs := make(chan error, 4)
+	errFirst := errors.New("first")
+	errThird := errors.New("third")
+	_ = PreShutdownFuncWithError(func(interface{}) error { return nil }, nil, errs)
+	_ = FirstFuncWithError(func(i interface{}) error { return i.(error) }, errFirst, errs)
+	_ = SecondFuncWithError(func(interface{}) error { return nil }, nil, errs)
+	_ = ThirdFuncWithError(func(i interface{}) error { return i.(error) }, errThird, errs)
+
+	Shutdown()
+	close(errs)
+	var got []error
+	for err := range errs {
+		got = append(got, err)
+	}
+	if len(got) != 2 || got[0] != errFirst || got[1] != errThird {
+		t.Fatal("unexpected errors", got)
+	}
+}
+
+func TestFnWithErrorFull(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	lines, restore := logLines()
+	defer restore()
+	errs := make(chan error, 1)
+	errs <- errors.New("already there")
+	_ = FirstFuncWithError(func(interface{}) error { return errors.New("dropped") }, nil, errs)
+
+	Shutdown()
+	nextLine(t, lines, "Error channel full, dropping error: dropped")
+	if len(errs) != 1 {
+		t.Fatal("unexpected number of errors", len(errs))
+	}
+}
+
+func TestFnWithErrorInvalid(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	fn := func(interface{}) error { return nil }
+	expectPanic(t, "shutdown: nil shutdown function", func() { FirstFuncWithError(nil, nil, make(chan error, 1)) })
+	expectPanic(t, "shutdown: error channel must be buffered", func() { FirstFuncWithError(fn, nil, nil) })
+	expectPanic(t, "shutdown: error channel must be buffered", func() { FirstFuncWithError(fn, nil, make(chan error)) })
+}
@@ -0,0 +1,175 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"time"
+)
+
+// DurationEstimate is a breakdown of the longest time a shutdown can take.
+type DurationEstimate struct {
+	// Stages contains the timeout of each stage that will run,
+	// in the order they are run. Stages without notifiers are skipped
+	// during shutdown, so they are not included.
+	Stages []StageEstimate
+
+	// DrainExtension is the maximum extension of the Preshutdown stage,
+	// see SetMaxDrainExtension.
+	DrainExtension time.Duration
+
+	// RateLimit is the time spent waiting to signal notifiers,
+	// see WithRateLimit.
+	RateLimit time.Duration
+
+	// HardTimeout is the limit of the stages, the drain extension and
+	// the rate limit, if they can take longer than the hard timeout,
+	// see SetHardTimeout. If notifiers are marked with WithNoHardTimeout,
+	// it is the critical ceiling. Otherwise it is 0.
+	HardTimeout time.Duration
+
+	// Domains is the time to shut down the domains of the manager.
+	Domains time.Duration
+
+	// ExitHooks is the maximum time the exit hooks can take,
+	// see OnBeforeExit. It only applies when the application exits.
+	ExitHooks time.Duration
+
+	// LastWish is the maximum time the last wish function can take,
+	// see SetLastWish. It only applies when the application exits.
+	LastWish time.Duration
+
+	// ExitFlush is the time waited for output to be read before exiting,
+	// see SetExitFlushDelay. It only applies when the application exits.
+	ExitFlush time.Duration
+
+	// Total is the sum of the above, where HardTimeout replaces
+	// the stages, the drain extension and the rate limit, if set.
+	Total time.Duration
+}
+
+// StageEstimate is the longest time a stage can take.
+type StageEstimate struct {
+	Stage   int           // The stage, see WithGracefulDegradation.
+	Timeout time.Duration // The timeout of the stage.
+}
+
+// EstimateMaxDuration returns the longest time a shutdown followed by
+// an exit can take with the current configuration.
+//
+// This can be used to configure how long the application is given to
+// exit, for instance terminationGracePeriodSeconds on Kubernetes.
+// The estimate is based on the notifiers registered when it is called,
+// so call it when the application has been set up.
+// If timeouts are disabled, the result is very large.
+func EstimateMaxDuration() time.Duration {
+	return defaultManager.EstimateMaxDuration()
+}
+
+// EstimateMaxDuration returns the longest time a shutdown of the manager followed by an exit can take.
+func (m *Manager) EstimateMaxDuration() time.Duration {
+	return m.EstimateMaxDurationDetail().Total
+}
+
+// EstimateMaxDurationDetail returns a breakdown of EstimateMaxDuration.
+func EstimateMaxDurationDetail() DurationEstimate {
+	return defaultManager.EstimateMaxDurationDetail()
+}
+
+// EstimateMaxDurationDetail returns a breakdown of EstimateMaxDuration of the manager.
+func (m *Manager) EstimateMaxDurationDetail() DurationEstimate {
+	var e DurationEstimate
+	e.Domains = m.estimateDomains()
+
+	m.sqM.Lock()
+	var queued [numStages]int
+	critical := false
+	for stage := range m.shutdownQueue {
+		queued[stage] = len(m.shutdownQueue[stage])
+		for _, n := range m.shutdownQueue[stage] {
+			critical = critical || m.critical(stage, n)
+		}
+	}
+	m.sqM.Unlock()
+
+	m.srM.RLock()
+	defer m.srM.RUnlock()
+	signals := 0
+	// Stages that run concurrently only add the longest timeout, see SetParallelStages.
+	var runs []int
+	run, group := 0, 0
+	for _, stage := range m.order {
+		if g := m.parallel[stage]; g == 0 || g != group {
+			run++
+			group = g
+		}
+		chaos := false
+		for _, s := range m.chaos.TimeoutStages {
+			chaos = chaos || s.n == stage
+		}
+		n := queued[stage]
+		if stage == 0 {
+			// The lock drain is always added.
+			n++
+		} else if m.stageLocks[stage].held() > 0 {
+			n++
+		}
+		if n == 0 && !chaos && m.minDurations[stage] == 0 {
+			continue
+		}
+		signals += n
+		e.Stages = append(e.Stages, StageEstimate{Stage: stage, Timeout: m.effectiveTimeout(stage)})
+		runs = append(runs, run)
+	}
+	e.DrainExtension = m.maxDrainExtension
+	if m.rateLimit > 0 {
+		e.RateLimit = time.Duration(signals) * time.Second / time.Duration(m.rateLimit)
+	}
+	e.ExitHooks = time.Duration(len(m.exitHooks)) * m.exitHookTimeout
+	if m.lastWish != nil {
+		e.LastWish = lastWishTimeout
+	}
+	e.ExitFlush = m.exitFlushDelay
+
+	var longest time.Duration
+	for i, s := range e.Stages {
+		if i > 0 && runs[i] != runs[i-1] {
+			e.Total += longest
+			longest = 0
+		}
+		if s.Timeout > longest {
+			longest = s.Timeout
+		}
+	}
+	e.Total += longest
+	e.Total += e.DrainExtension + e.RateLimit
+	if m.hardTimeout > 0 && !m.timeoutsDisabled && e.Total > m.hardTimeout {
+		e.HardTimeout = m.hardTimeout
+		if critical && m.criticalCeiling > e.HardTimeout {
+			e.HardTimeout = m.criticalCeiling
+		}
+		e.Total = e.HardTimeout
+	}
+	e.Total += e.Domains + e.ExitHooks + e.LastWish + e.ExitFlush
+	return e
+}
+
+// estimateDomains returns the longest time the domains of the manager take to shut down.
+// Domains don't exit, so their exit terms are not included.
+func (m *Manager) estimateDomains() time.Duration {
+	m.srM.RLock()
+	domains := append([]*Domain(nil), m.domains...)
+	parallel := m.parallelDomains
+	m.srM.RUnlock()
+
+	var total time.Duration
+	for _, d := range domains {
+		e := d.EstimateMaxDurationDetail()
+		t := e.Total - e.ExitHooks - e.LastWish - e.ExitFlush
+		if !parallel {
+			total += t
+		} else if t > total {
+			total = t
+		}
+	}
+	return total
+}
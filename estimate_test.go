@@ -0,0 +1,100 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestEstimateMaxDuration(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	m := NewManager(WithRateLimit(10))
+	m.SetTimeout(time.Second)
+	m.SetTimeoutN(Stage2, 2*time.Second)
+	m.SetMaxDrainExtension(3 * time.Second)
+	m.SetExitFlushDelay(10 * time.Millisecond)
+	m.SetLastWish(func() {})
+	m.SetExitHookTimeout(2 * time.Second)
+	m.OnBeforeExit(func(context.Context) error { return nil })
+
+	// Preshutdown, with the lock drain.
+	want := time.Second
+	// Two notifiers in the second stage.
+	m.Second()
+	m.SecondFunc(func(interface{}) {}, nil)
+	want += 2 * time.Second
+	// An empty stage with a minimum duration.
+	m.SetTimeoutN(Stage3, 4*time.Second)
+	m.SetStageMinDuration(Stage3, time.Second)
+	want += 4 * time.Second
+	// A chaos stage.
+	m.SetChaos(ChaosConfig{TimeoutStages: []Stage{ReadOnlyStage}})
+	want += time.Second
+
+	// Two sequential domains, each with a notifier in the first stage.
+	for i := 0; i < 2; i++ {
+		d := m.NewDomain("d")
+		d.SetExitFlushDelay(time.Hour)
+		d.SetMaxDrainExtension(0)
+		d.OnBeforeExit(func(context.Context) error { return nil })
+		d.First()
+	}
+	// Each domain has its lock drain and first stage.
+	domains := 2 * 2 * time.Second
+
+	e := m.EstimateMaxDurationDetail()
+	if len(e.Stages) != 4 {
+		t.Fatal("unexpected stages", e.Stages)
+	}
+	for i, s := range []int{0, 4, 2, 3} {
+		if e.Stages[i].Stage != s {
+			t.Fatal("unexpected stages", e.Stages)
+		}
+	}
+	if e.DrainExtension != 3*time.Second || e.ExitHooks != 2*time.Second || e.LastWish != time.Second || e.ExitFlush != 10*time.Millisecond {
+		t.Fatal("unexpected estimate", e)
+	}
+	// 3 notifiers, 100ms apart.
+	if e.RateLimit != 300*time.Millisecond {
+		t.Fatal("unexpected rate limit", e.RateLimit)
+	}
+	if e.Domains != domains {
+		t.Fatal("unexpected domains", e.Domains)
+	}
+	want += 3*time.Second + 300*time.Millisecond + domains + 2*time.Second + time.Second + 10*time.Millisecond
+	if e.Total != want || m.EstimateMaxDuration() != want {
+		t.Fatal("unexpected total", e.Total, want)
+	}
+
+	// Parallel domains take as long as the slowest.
+	m.Configure(WithParallelDomains(true))
+	if e := m.EstimateMaxDurationDetail(); e.Domains != 2*time.Second {
+		t.Fatal("unexpected parallel domains", e.Domains)
+	}
+}
+
+func TestEstimateMaxDurationHardTimeout(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	m := NewManager()
+	m.SetExitFlushDelay(0)
+	m.SetTimeout(time.Second)
+	m.SetMaxDrainExtension(0)
+	m.First()
+	m.SetHardTimeout(5 * time.Second)
+	if e := m.EstimateMaxDurationDetail(); e.HardTimeout != 0 || e.Total != 2*time.Second {
+		t.Fatal("unexpected estimate", e)
+	}
+	m.SetHardTimeout(1500 * time.Millisecond)
+	if e := m.EstimateMaxDurationDetail(); e.HardTimeout != 1500*time.Millisecond || e.Total != 1500*time.Millisecond {
+		t.Fatal("unexpected estimate", e)
+	}
+	m.Second().WithNoHardTimeout()
+	m.SetCriticalCeiling(time.Minute)
+	if e := m.EstimateMaxDurationDetail(); e.HardTimeout != time.Minute || e.Total != time.Minute {
+		t.Fatal("unexpected estimate", e)
+	}
+}
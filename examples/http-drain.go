@@ -0,0 +1,57 @@
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/klauspost/shutdown"
+)
+
+// This example shows a server that finishes requests in flight
+// before shutting down.
+//
+// The handler is wrapped with shutdown.WrapHandlerFunc, so shutdown
+// will not proceed until all running requests have finished, and new
+// requests get a 'StatusServiceUnavailable' (503) response code.
+//
+// To execute, use 'go run http-drain.go'
+//
+// The server prints the address it is listening on.
+// Requests take one second, unless another duration is given,
+// for instance http://localhost:port/?wait=10s
+
+func handler(w http.ResponseWriter, req *http.Request) {
+	wait := time.Second
+	if v := req.FormValue("wait"); v != "" {
+		wait, _ = time.ParseDuration(v)
+	}
+	fmt.Println("request started")
+	time.Sleep(wait)
+	fmt.Println("request finished")
+	io.WriteString(w, "hello world\n")
+}
+
+func main() {
+	// Make shutdown catch Ctrl+c and system terminate
+	shutdown.OnSignal(0, os.Interrupt, syscall.SIGTERM)
+	shutdown.SetTimeout(time.Second * 10)
+
+	shutdown.FirstFunc(func(interface{}) {
+		fmt.Println("first stage")
+	}, nil)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("listening", l.Addr())
+	log.Fatal(http.Serve(l, shutdown.WrapHandlerFunc(handler)))
+}
@@ -0,0 +1,40 @@
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/klauspost/shutdown"
+)
+
+// This example shows the order in which the shutdown stages are run
+// when the program receives a signal.
+//
+// When the program is terminated (via ctrl+c for instance), each stage
+// prints its name. The last wish is printed right before the program
+// exits with exit code 3.
+//
+// To execute, use 'go run signal-stages.go'
+
+func main() {
+	// Exit with code 3 on Ctrl+c and system terminate
+	shutdown.OnSignal(3, os.Interrupt, syscall.SIGTERM)
+
+	shutdown.PreShutdownFunc(printStage, "preshutdown")
+	shutdown.FirstFunc(printStage, "first")
+	shutdown.SecondFunc(printStage, "second")
+	shutdown.ThirdFunc(printStage, "third")
+	shutdown.SetLastWish(func() {
+		fmt.Println("last wish")
+	})
+
+	fmt.Println("ready")
+	select {}
+}
+
+func printStage(i interface{}) {
+	fmt.Println(i.(string))
+}
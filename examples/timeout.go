@@ -0,0 +1,39 @@
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/klauspost/shutdown"
+)
+
+// This example shows what happens when a stage doesn't finish in time.
+//
+// The first stage hangs forever. When the stage timeout expires,
+// the graceful degradation function is called, and shutdown
+// proceeds to the second stage.
+//
+// To execute, use 'go run timeout.go' and press ctrl+c
+
+func main() {
+	shutdown.Configure(shutdown.WithGracefulDegradation(func(stage int) {
+		fmt.Println("stage", stage, "timed out")
+	}))
+	shutdown.SetTimeout(time.Millisecond * 200)
+	shutdown.OnSignal(0, os.Interrupt, syscall.SIGTERM)
+
+	shutdown.FirstFunc(func(interface{}) {
+		fmt.Println("first stage hanging")
+		select {}
+	}, nil)
+	shutdown.SecondFunc(func(interface{}) {
+		fmt.Println("second stage")
+	}, nil)
+
+	fmt.Println("ready")
+	select {}
+}
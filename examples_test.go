@@ -0,0 +1,207 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package shutdown
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// example is a running example program.
+type example struct {
+	cmd    *exec.Cmd
+	lines  chan string
+	stderr bytes.Buffer
+}
+
+// startExample builds and starts the example with the given name.
+func startExample(t *testing.T, name string) *example {
+	if testing.Short() {
+		t.Skip("skipping example in short mode")
+	}
+	// Use the toolchain running the test, from the package directory,
+	// so the examples build against this copy of the package.
+	gobin := filepath.Join(runtime.GOROOT(), "bin", "go")
+	out, err := exec.Command(gobin, "list", "-f", "{{.Dir}}", "github.com/klauspost/shutdown").CombinedOutput()
+	if err != nil {
+		t.Skipf("skipping example, the go tool can't find the shutdown package from here: %v\n%s", err, out)
+	}
+	if wd, _ := os.Getwd(); !sameDir(strings.TrimSpace(string(out)), wd) {
+		t.Skipf("skipping example, the go tool finds another copy of the shutdown package at %s", out)
+	}
+	dir, err := ioutil.TempDir("", "shutdown-example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bin := filepath.Join(dir, name)
+	out, err = exec.Command(gobin, "build", "-o", bin, "./"+filepath.Join("examples", name+".go")).CombinedOutput()
+	if err != nil {
+		t.Fatalf("building %s: %v\n%s", name, err, out)
+	}
+
+	// Lines are not buffered, so output is only read when we expect it.
+	e := &example{cmd: exec.Command(bin), lines: make(chan string)}
+	e.cmd.Stderr = &e.stderr
+	stdout, err := e.cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		s := bufio.NewScanner(stdout)
+		for s.Scan() {
+			e.lines <- s.Text()
+		}
+		close(e.lines)
+	}()
+	return e
+}
+
+// sameDir returns true if a and b are the same directory.
+func sameDir(a, b string) bool {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	return err == nil && os.SameFile(ai, bi)
+}
+
+// expect waits for the next line of output and checks that it starts with want.
+// It returns the line.
+func (e *example) expect(t *testing.T, want string) string {
+	select {
+	case l, ok := <-e.lines:
+		if !ok {
+			t.Fatalf("expected %q, but output ended", want)
+		}
+		if !strings.HasPrefix(l, want) {
+			t.Fatalf("expected %q, got %q", want, l)
+		}
+		return l
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for %q", want)
+	}
+	return ""
+}
+
+// wait waits for the example to exit and returns the exit code.
+func (e *example) wait(t *testing.T) int {
+	// Read remaining output, so the program is not blocked writing it.
+	for l := range e.lines {
+		t.Log("unexpected output:", l)
+	}
+	err := e.cmd.Wait()
+	if err == nil {
+		return 0
+	}
+	if ee, ok := err.(*exec.ExitError); ok {
+		return ee.Sys().(syscall.WaitStatus).ExitStatus()
+	}
+	t.Fatal(err)
+	return 0
+}
+
+func TestExampleSignalStages(t *testing.T) {
+	defer close(startTimer(t))
+	e := startExample(t, "signal-stages")
+	e.expect(t, "ready")
+	e.cmd.Process.Signal(syscall.SIGTERM)
+	for _, stage := range []string{"preshutdown", "first", "second", "third", "last wish"} {
+		e.expect(t, stage)
+	}
+	if code := e.wait(t); code != 3 {
+		t.Fatal("unexpected exit code", code)
+	}
+}
+
+func TestExampleHTTPDrain(t *testing.T) {
+	defer close(startTimer(t))
+	e := startExample(t, "http-drain")
+	addr := strings.TrimPrefix(e.expect(t, "listening "), "listening ")
+
+	type result struct {
+		code int
+		err  error
+	}
+	res := make(chan result, 1)
+	go func() {
+		r, err := http.Get("http://" + addr + "/?wait=500ms")
+		if err != nil {
+			res <- result{err: err}
+			return
+		}
+		r.Body.Close()
+		res <- result{code: r.StatusCode}
+	}()
+	e.expect(t, "request started")
+	e.cmd.Process.Signal(syscall.SIGTERM)
+
+	// The request must finish before shutdown proceeds.
+	e.expect(t, "request finished")
+	e.expect(t, "first stage")
+	r := <-res
+	if r.err != nil {
+		t.Fatal("request in flight failed:", r.err)
+	}
+	if r.code != http.StatusOK {
+		t.Fatal("unexpected status code", r.code)
+	}
+	if code := e.wait(t); code != 0 {
+		t.Fatal("unexpected exit code", code)
+	}
+}
+
+func TestExampleTimeout(t *testing.T) {
+	defer close(startTimer(t))
+	e := startExample(t, "timeout")
+	e.expect(t, "ready")
+	tn := time.Now()
+	e.cmd.Process.Signal(syscall.SIGTERM)
+	e.expect(t, "first stage hanging")
+	e.expect(t, "stage 1 timed out")
+	e.expect(t, "second stage")
+	if code := e.wait(t); code != 0 {
+		t.Fatal("unexpected exit code", code)
+	}
+	if dur := time.Since(tn); dur > 2*time.Second {
+		t.Fatal("shutdown took too long", dur)
+	}
+}
+
+// Tests that the last output is not lost when the reader is slow.
+func TestExampleExitFlush(t *testing.T) {
+	defer close(startTimer(t))
+	e := startExample(t, "signal-stages")
+	e.expect(t, "ready")
+	e.cmd.Process.Signal(syscall.SIGTERM)
+
+	// Don't read while the program shuts down and exits.
+	time.Sleep(500 * time.Millisecond)
+	for _, stage := range []string{"preshutdown", "first", "second", "third", "last wish"} {
+		e.expect(t, stage)
+	}
+	if code := e.wait(t); code != 3 {
+		t.Fatal("unexpected exit code", code)
+	}
+	log := strings.Split(strings.TrimSpace(e.stderr.String()), "\n")
+	if l := log[len(log)-1]; !strings.HasSuffix(l, "Shutdown stage 3") {
+		t.Fatal("unexpected last log line", l)
+	}
+}
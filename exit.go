@@ -0,0 +1,293 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// lastWishTimeout is the maximum time to wait for the last wish function.
+const lastWishTimeout = time.Second
+
+// defaultExitHookTimeout is the default maximum time to wait for each exit hook.
+const defaultExitHookTimeout = time.Second
+
+// defaultExitFlushDelay is the default time to wait for output to be
+// written before exiting.
+const defaultExitFlushDelay = 5 * time.Millisecond
+
+// SetLastWish sets a function that is called as the very last thing
+// before the application exits, see Exit and OnSignal.
+//
+// It is called once, regardless of how shutdown went, so it can be
+// used to write a single marker that the application has exited.
+// If the function panics, the panic is logged. If it doesn't return
+// within one second, the application exits without waiting for it.
+//
+// Call the returned function to remove the last wish function, for instance
+// when the module that set it is unloaded. If another function has been set
+// since, or the function has already been called, it has no effect.
+func SetLastWish(fn func()) (cancel func()) {
+	return defaultManager.SetLastWish(fn)
+}
+
+// SetLastWish sets a function that is called before the manager exits the application.
+func (m *Manager) SetLastWish(fn func()) (cancel func()) {
+	wish := &fn
+	if fn == nil {
+		wish = nil
+	}
+	m.srM.Lock()
+	m.lastWish = wish
+	m.srM.Unlock()
+	return func() {
+		m.srM.Lock()
+		if m.lastWish == wish {
+			m.lastWish = nil
+		}
+		m.srM.Unlock()
+	}
+}
+
+// OnBeforeExit adds a function that is called before the application exits,
+// see Exit and OnSignal.
+//
+// Hooks are called after all stages have completed, before the last wish
+// function, in the order they were added. This can be used to push logs or
+// metrics about the shutdown, which would otherwise be lost when the
+// application exits. Each hook is given a context that is cancelled when its
+// timeout expires, see SetExitHookTimeout. If a hook doesn't return by then,
+// it is abandoned and the next hook is called. Errors and panics are logged.
+// Hooks are not called when Shutdown is called, since the application doesn't exit.
+//
+// Call the returned function to remove the hook, for instance when the module
+// that added it is unloaded. If the hook has already been called, it has no effect.
+func OnBeforeExit(fn func(ctx context.Context) error) (cancel func()) {
+	return defaultManager.OnBeforeExit(fn)
+}
+
+// OnBeforeExit adds a function that is called before the manager exits the application.
+func (m *Manager) OnBeforeExit(fn func(ctx context.Context) error) (cancel func()) {
+	if fn == nil {
+		panic("shutdown: nil exit hook")
+	}
+	hook := &fn
+	m.srM.Lock()
+	m.exitHooks = append(m.exitHooks, hook)
+	m.srM.Unlock()
+	return func() {
+		m.srM.Lock()
+		defer m.srM.Unlock()
+		for i, h := range m.exitHooks {
+			if h == hook {
+				m.exitHooks = append(m.exitHooks[:i:i], m.exitHooks[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// SetExitHookTimeout sets the maximum time to wait for each function
+// added by OnBeforeExit. The default is one second.
+// Timeouts that are zero or negative are ignored with a warning.
+func SetExitHookTimeout(d time.Duration) {
+	defaultManager.SetExitHookTimeout(d)
+}
+
+// SetExitHookTimeout sets the maximum time to wait for each exit hook of the manager.
+func (m *Manager) SetExitHookTimeout(d time.Duration) {
+	if !validTimeout("SetExitHookTimeout", d) {
+		return
+	}
+	m.srM.Lock()
+	m.exitHookTimeout = d
+	m.srM.Unlock()
+}
+
+// SetExitFlushDelay sets the time to wait before exiting, after
+// stdout and stderr have been flushed. This gives pipes and logging
+// backends, like journald, time to read the last output.
+// The default is 5 milliseconds.
+// A negative duration is treated as 0 with a warning.
+func SetExitFlushDelay(d time.Duration) {
+	defaultManager.SetExitFlushDelay(d)
+}
+
+// SetExitFlushDelay sets the time to wait before the manager exits, after output has been flushed.
+func (m *Manager) SetExitFlushDelay(d time.Duration) {
+	if d < 0 {
+		Logger.Printf("SetExitFlushDelay: negative duration %v, using 0", d)
+		d = 0
+	}
+	m.srM.Lock()
+	m.exitFlushDelay = d
+	m.srM.Unlock()
+}
+
+// SetExitFunc sets the function that is called to exit the application,
+// see Exit and OnSignal. It is given the exit code, see PlannedExitCode.
+// The default is os.Exit, which is also used if fn is nil.
+func SetExitFunc(fn func(code int)) {
+	defaultManager.SetExitFunc(fn)
+}
+
+// SetExitFunc sets the function that is called when the manager exits the application.
+func (m *Manager) SetExitFunc(fn func(code int)) {
+	if fn == nil {
+		fn = os.Exit
+	}
+	m.srM.Lock()
+	m.exitFn = fn
+	m.srM.Unlock()
+}
+
+// SetExitCodeOnFailure sets the exit code used when the application
+// exits after a shutdown where a stage timed out or a function panicked.
+// It replaces the code given to Exit or OnSignal.
+// Use 0 to always use the given code, which is the default.
+func SetExitCodeOnFailure(code int) {
+	defaultManager.SetExitCodeOnFailure(code)
+}
+
+// SetExitCodeOnFailure sets the exit code used when shutdown of the manager failed.
+func (m *Manager) SetExitCodeOnFailure(code int) {
+	m.srM.Lock()
+	m.failureExitCode = code
+	m.srM.Unlock()
+}
+
+// PlannedExitCode returns the exit code the application will exit with.
+//
+// It is the code given to Exit or OnSignal, or the code set by
+// SetExitCodeOnFailure if the shutdown failed. The result is based
+// on the last completed shutdown, so it is final when called from the
+// last wish function or the exit function. If the application isn't
+// exiting, for instance if Shutdown was called, the code is 0.
+func PlannedExitCode() int {
+	return defaultManager.PlannedExitCode()
+}
+
+// PlannedExitCode returns the exit code the manager will exit the application with.
+func (m *Manager) PlannedExitCode() int {
+	m.srM.RLock()
+	defer m.srM.RUnlock()
+	if !m.exiting {
+		return 0
+	}
+	if m.failureExitCode != 0 && (m.last.TimedOut || m.last.Panics > 0) {
+		return m.failureExitCode
+	}
+	return m.exitCode
+}
+
+// exit calls the exit hooks and the last wish function, flushes output
+// and exits with the given code, unless it is replaced by SetExitCodeOnFailure.
+func (m *Manager) exit(code int) {
+	m.srM.Lock()
+	if m.shutdownRequested && m.exitCycle == m.cycles && m.clock.Mono()-m.startedMono < m.debounce {
+		// Already exiting because of this shutdown, see SetShutdownDebounce.
+		m.srM.Unlock()
+		return
+	}
+	m.exitCycle = m.cycles
+	m.exitCode = code
+	m.exiting = true
+	m.srM.Unlock()
+	m.runExitHooks()
+	m.runLastWish()
+	m.flush()
+	m.srM.RLock()
+	exit := m.exitFn
+	m.srM.RUnlock()
+	exit(m.PlannedExitCode())
+}
+
+// flush syncs stdout and stderr, where supported,
+// and waits for the exit flush delay.
+func (m *Manager) flush() {
+	_ = os.Stdout.Sync()
+	_ = os.Stderr.Sync()
+	m.srM.RLock()
+	d := m.exitFlushDelay
+	m.srM.RUnlock()
+	if d <= 0 {
+		return
+	}
+	t := m.clock.NewTimer(d)
+	defer t.Stop()
+	<-t.C()
+}
+
+// runExitHooks calls the exit hooks once, in the order they were added.
+// It waits at most the exit hook timeout for each of them to return.
+func (m *Manager) runExitHooks() {
+	m.exitHooksOnce.Do(func() {
+		m.srM.RLock()
+		hooks := m.exitHooks
+		timeout := m.exitHookTimeout
+		m.srM.RUnlock()
+		for i, fn := range hooks {
+			m.runExitHook(i, *fn, timeout)
+		}
+	})
+}
+
+// runExitHook calls a single exit hook, and logs if it fails.
+func (m *Manager) runExitHook(i int, fn func(ctx context.Context) error, timeout time.Duration) {
+	// The timeout is measured with the clock of the manager,
+	// so the context is cancelled by us, rather than by a deadline.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			if r := recover(); r != nil {
+				Logger.Printf("Panic in exit hook %d: %v", i, r)
+			}
+		}()
+		if err := fn(ctx); err != nil {
+			Logger.Printf("Exit hook %d failed: %v", i, err)
+		}
+	}()
+	t := m.clock.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-done:
+	case <-t.C():
+		Logger.Printf("timeout waiting for exit hook %d", i)
+	}
+}
+
+// runLastWish calls the last wish function once.
+// It waits at most lastWishTimeout for it to return.
+func (m *Manager) runLastWish() {
+	m.lastWishOnce.Do(func() {
+		m.srM.RLock()
+		wish := m.lastWish
+		m.srM.RUnlock()
+		if wish == nil {
+			return
+		}
+		fn := *wish
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			defer func() {
+				if r := recover(); r != nil {
+					Logger.Println("Panic in last wish function:", r)
+				}
+			}()
+			fn()
+		}()
+		t := m.clock.NewTimer(lastWishTimeout)
+		defer t.Stop()
+		select {
+		case <-done:
+		case <-t.C():
+			Logger.Println("timeout waiting for last wish function")
+		}
+	})
+}
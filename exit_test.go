@@ -0,0 +1,322 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeExit replaces the exit function of the default manager.
+// The exit code is sent on the returned channel.
+func fakeExit() chan int {
+	codes := make(chan int, 1)
+	SetExitFunc(func(code int) {
+		codes <- code
+	})
+	return codes
+}
+
+func TestLastWish(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	codes := fakeExit()
+	var third bool
+	ThirdFunc(setBool, &third)
+	SetLastWish(func() {
+		if !third {
+			t.Error("last wish called before shutdown finished")
+		}
+		select {
+		case <-codes:
+			t.Error("last wish called after exit")
+		default:
+		}
+	})
+	Exit(2)
+	if code := <-codes; code != 2 {
+		t.Fatal("unexpected exit code", code)
+	}
+}
+
+func TestLastWishStageTimeout(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	codes := fakeExit()
+	SetTimeout(time.Millisecond * 100)
+	f := First()
+	go func() {
+		<-f
+		// Never finish
+	}()
+	var called bool
+	SetLastWish(func() { called = true })
+	Exit(1)
+	<-codes
+	if !called {
+		t.Fatal("last wish was not called")
+	}
+}
+
+func TestLastWishOnce(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	codes := fakeExit()
+	calls := 0
+	SetLastWish(func() { calls++ })
+	Exit(0)
+	<-codes
+	Exit(0)
+	<-codes
+	if calls != 1 {
+		t.Fatal("expected last wish to be called once, got", calls)
+	}
+}
+
+func TestLastWishPanic(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	codes := fakeExit()
+	lines, restore := logLines()
+	defer restore()
+	SetLastWish(func() { panic("This is expected") })
+	Exit(0)
+	<-codes
+	nextLine(t, lines, "Panic in last wish function: This is expected")
+}
+
+func TestLastWishHang(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	codes := fakeExit()
+	c := newFakeClock()
+	defaultManager.clock = c
+	lines, restore := logLines()
+	defer restore()
+	started := make(chan struct{})
+	SetLastWish(func() {
+		close(started)
+		select {}
+	})
+	go Exit(0)
+	<-started
+	c.waitTimers(t, 1)
+	select {
+	case <-codes:
+		t.Fatal("exited while waiting for last wish")
+	default:
+	}
+	c.Advance(lastWishTimeout)
+	// Wait for the exit flush delay.
+	c.waitTimers(t, 1)
+	c.Advance(defaultExitFlushDelay)
+	<-codes
+	nextLine(t, lines, "timeout waiting for last wish function")
+}
+
+func TestOnBeforeExit(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	codes := fakeExit()
+	SetExitFlushDelay(0)
+	lines, restore := logLines()
+	defer restore()
+	var third bool
+	ThirdFunc(setBool, &third)
+	var order []int
+	OnBeforeExit(func(ctx context.Context) error {
+		if !third {
+			t.Error("exit hook called before shutdown finished")
+		}
+		order = append(order, 0)
+		return errors.New("push failed")
+	})
+	OnBeforeExit(func(ctx context.Context) error {
+		select {
+		case <-codes:
+			t.Error("exit hook called after exit")
+		default:
+		}
+		order = append(order, 1)
+		return nil
+	})
+	SetLastWish(func() {
+		if len(order) != 2 {
+			t.Error("last wish called before exit hooks")
+		}
+	})
+	Exit(0)
+	<-codes
+	if len(order) != 2 || order[0] != 0 || order[1] != 1 {
+		t.Fatal("unexpected hook order", order)
+	}
+	nextLine(t, lines, "Exit hook 0 failed: push failed")
+}
+
+func TestOnBeforeExitHang(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	codes := fakeExit()
+	SetExitFlushDelay(0)
+	c := newFakeClock()
+	defaultManager.clock = c
+	lines, restore := logLines()
+	defer restore()
+	SetExitHookTimeout(2 * time.Second)
+	started, cancelled := make(chan struct{}), make(chan struct{})
+	OnBeforeExit(func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		select {}
+	})
+	var second bool
+	OnBeforeExit(func(ctx context.Context) error {
+		second = true
+		return nil
+	})
+	go Exit(0)
+	<-started
+	c.waitTimers(t, 1)
+	c.Advance(2*time.Second - time.Millisecond)
+	select {
+	case <-codes:
+		t.Fatal("exited while waiting for exit hook")
+	case <-time.After(10 * time.Millisecond):
+	}
+	c.Advance(time.Millisecond)
+	<-codes
+	<-cancelled
+	if !second {
+		t.Fatal("hook after the hanging hook was not called")
+	}
+	nextLine(t, lines, "timeout waiting for exit hook 0")
+}
+
+func TestExitFlushDelay(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	codes := fakeExit()
+	c := newFakeClock()
+	defaultManager.clock = c
+	SetExitFlushDelay(time.Second)
+	go Exit(0)
+	<-defaultManager.done
+	c.waitTimers(t, 1)
+	c.Advance(time.Second - time.Millisecond)
+	select {
+	case <-codes:
+		t.Fatal("exited before flush delay")
+	case <-time.After(10 * time.Millisecond):
+	}
+	c.Advance(time.Millisecond)
+	<-codes
+
+	lines, restore := logLines()
+	defer restore()
+	SetExitFlushDelay(-time.Second)
+	nextLine(t, lines, "SetExitFlushDelay: negative duration -1s, using 0")
+	if d := defaultManager.exitFlushDelay; d != 0 {
+		t.Fatal("unexpected flush delay", d)
+	}
+}
+
+func TestPlannedExitCode(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	codes := fakeExit()
+	SetExitFlushDelay(0)
+	SetExitCodeOnFailure(10)
+	var planned int
+	SetLastWish(func() {
+		planned = PlannedExitCode()
+	})
+	_ = FirstFunc(setBool, new(bool))
+	Exit(3)
+	if code := <-codes; code != 3 || planned != 3 {
+		t.Fatal("unexpected exit code", code, planned)
+	}
+	if code := PlannedExitCode(); code != 3 {
+		t.Fatal("unexpected planned exit code", code)
+	}
+}
+
+func TestPlannedExitCodeFailure(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeout(100 * time.Millisecond)
+	codes := fakeExit()
+	SetExitFlushDelay(0)
+	SetExitCodeOnFailure(10)
+	var planned int
+	SetLastWish(func() {
+		planned = PlannedExitCode()
+	})
+	f := First()
+	go func() {
+		<-f
+		// Never finish
+	}()
+	Exit(0)
+	if code := <-codes; code != 10 || planned != 10 {
+		t.Fatal("unexpected exit code", code, planned)
+	}
+}
+
+func TestPlannedExitCodeShutdown(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeout(100 * time.Millisecond)
+	SetExitCodeOnFailure(10)
+	f := First()
+	go func() {
+		<-f
+		// Never finish
+	}()
+	Shutdown()
+	if !LastSummary().TimedOut {
+		t.Fatal("shutdown did not time out")
+	}
+	// The application is not exiting.
+	if code := PlannedExitCode(); code != 0 {
+		t.Fatal("unexpected planned exit code", code)
+	}
+}
+
+func TestExitHooksCancel(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	codes := fakeExit()
+	SetExitFlushDelay(0)
+	var called []string
+	cancelHook := OnBeforeExit(func(ctx context.Context) error {
+		called = append(called, "cancelled hook")
+		return nil
+	})
+	OnBeforeExit(func(ctx context.Context) error {
+		called = append(called, "hook")
+		return nil
+	})
+	cancelWish := SetLastWish(func() { called = append(called, "cancelled wish") })
+	cancelHook()
+	cancelWish()
+	Exit(0)
+	<-codes
+	if len(called) != 1 || called[0] != "hook" {
+		t.Fatal("unexpected calls", called)
+	}
+
+	// Cancelling a replaced last wish doesn't remove the new one.
+	Reset()
+	called = nil
+	cancelWish = SetLastWish(func() { called = append(called, "old wish") })
+	SetLastWish(func() { called = append(called, "wish") })
+	cancelWish()
+	Exit(0)
+	<-codes
+	if len(called) != 2 || called[1] != "wish" {
+		t.Fatal("unexpected calls", called)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+// Package expvar publishes the state of the shutdown with the expvar package,
+// so it is available from /debug/vars.
+//
+// It is a separate package, since importing expvar registers
+// the /debug/vars handler with http.DefaultServeMux.
+package expvar
+
+import (
+	"bytes"
+	"encoding/json"
+	stdexpvar "expvar"
+	"io"
+	"sync"
+
+	"github.com/klauspost/shutdown"
+)
+
+var mu sync.Mutex
+
+// Publish publishes the state of the shutdown with the given name.
+//
+// The state is collected when it is read, see shutdown.WriteStatus.
+// If a variable with the name has already been published,
+// for instance by an earlier call, nothing is done.
+func Publish(name string) {
+	publish(name, shutdown.WriteStatus)
+}
+
+// PublishManager publishes the state of the shutdown of m with the given name.
+func PublishManager(m *shutdown.Manager, name string) {
+	publish(name, m.WriteStatus)
+}
+
+func publish(name string, write func(w io.Writer) error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if stdexpvar.Get(name) != nil {
+		return
+	}
+	stdexpvar.Publish(name, stdexpvar.Func(func() interface{} {
+		var buf bytes.Buffer
+		if err := write(&buf); err != nil {
+			return err.Error()
+		}
+		return json.RawMessage(buf.Bytes())
+	}))
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package expvar
+
+import (
+	"encoding/json"
+	stdexpvar "expvar"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/klauspost/shutdown"
+)
+
+// scrape returns the published variable with the given name.
+func scrape(t *testing.T, name string) map[string]interface{} {
+	w := httptest.NewRecorder()
+	stdexpvar.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &vars); err != nil {
+		t.Fatal(err)
+	}
+	var s map[string]interface{}
+	if err := json.Unmarshal(vars[name], &s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestPublishManager(t *testing.T) {
+	m := shutdown.NewManager()
+	PublishManager(m, "shutdown_test")
+	// Publishing again must not panic.
+	PublishManager(m, "shutdown_test")
+	Publish("shutdown_test")
+	m.First()
+
+	s := scrape(t, "shutdown_test")
+	if s["started"] != false {
+		t.Fatal("unexpected state before shutdown", s)
+	}
+	if p, _ := s["pending"].(map[string]interface{}); p["first"] != 1.0 {
+		t.Fatal("unexpected pending", s["pending"])
+	}
+	m.SetTimeout(10 * time.Millisecond)
+	m.Shutdown()
+	s = scrape(t, "shutdown_test")
+	if s["started"] != true || s["completed"] != true || s["last"] == nil {
+		t.Fatal("unexpected state after shutdown", s)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"io"
+	"strings"
+)
+
+// FlushOnShutdown will flush w in the first stage of the shutdown.
+//
+// If w has a Flush() error method, like bufio.Writer, it is called.
+// Otherwise, if it has a Sync() error method, like os.File, that is called.
+// If w has neither, nothing is done. Errors are logged.
+// The returned Notifier is only really useful for cancelling the flush.
+func FlushOnShutdown(w io.Writer) Notifier {
+	return defaultManager.FlushOnShutdown(w)
+}
+
+// FlushOnShutdown will flush w in the first stage of the shutdown of the manager.
+func (m *Manager) FlushOnShutdown(w io.Writer) Notifier {
+	return m.onFunc(1, flushWriter, w)
+}
+
+// flushWriter flushes or syncs the given writer, if it can.
+func flushWriter(i interface{}) {
+	var err error
+	switch w := i.(type) {
+	case interface{ Flush() error }:
+		err = w.Flush()
+	case interface{ Sync() error }:
+		err = w.Sync()
+	default:
+		return
+	}
+	if err != nil {
+		Logger.Println("Error flushing writer:", err)
+	}
+}
+
+// CloseClientsOnShutdown closes clients in the given stage of the shutdown.
+//
+// This releases sockets held by clients, like keep-alive connections,
+// which can otherwise delay the termination of the application.
+// The following values are accepted:
+//   - values with a CloseIdleConnections() method, like *http.Client and *http.Transport.
+//   - io.Closer, like *grpc.ClientConn.
+//   - func() error.
+//
+// Nil values are skipped. Other values are skipped with a warning.
+// The clients are closed one at the time by a single function, and
+// errors are logged together. The notifier of the function is returned,
+// with the number of clients that will be closed.
+func CloseClientsOnShutdown(s Stage, closers ...interface{}) (Notifier, int) {
+	return defaultManager.CloseClientsOnShutdown(s, closers...)
+}
+
+// CloseClientsOnShutdown closes clients in the given stage of the shutdown of the manager.
+func (m *Manager) CloseClientsOnShutdown(s Stage, closers ...interface{}) (Notifier, int) {
+	var fns []func() error
+	for _, c := range closers {
+		switch c := c.(type) {
+		case nil:
+		case interface{ CloseIdleConnections() }:
+			fns = append(fns, func() error {
+				c.CloseIdleConnections()
+				return nil
+			})
+		case io.Closer:
+			fns = append(fns, c.Close)
+		case func() error:
+			if c != nil {
+				fns = append(fns, c)
+			}
+		default:
+			Logger.Printf("CloseClientsOnShutdown: ignoring %T, it cannot be closed", c)
+		}
+	}
+	n := m.onFunc(s.n, func(interface{}) {
+		var errs []string
+		for _, fn := range fns {
+			if err := fn(); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+		if len(errs) > 0 {
+			Logger.Printf("Error closing %d of %d clients: %s", len(errs), len(fns), strings.Join(errs, "; "))
+		}
+	}, nil)
+	return n, len(fns)
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// syncWriter records calls to Sync.
+type syncWriter struct {
+	bytes.Buffer
+	synced bool
+	err    error
+}
+
+func (s *syncWriter) Sync() error {
+	s.synced = true
+	return s.err
+}
+
+func TestFlushOnShutdown(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	bw.WriteString("buffered")
+	sw := &syncWriter{}
+	FlushOnShutdown(bw)
+	FlushOnShutdown(sw)
+	// Writers that can't be flushed are ignored.
+	FlushOnShutdown(&bytes.Buffer{})
+
+	Shutdown()
+	if buf.String() != "buffered" {
+		t.Fatal("buffered writer was not flushed")
+	}
+	if !sw.synced {
+		t.Fatal("writer was not synced")
+	}
+}
+
+func TestFlushOnShutdownError(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	lines, restore := logLines()
+	defer restore()
+	FlushOnShutdown(&syncWriter{err: errors.New("disk full")})
+	cancelled := &syncWriter{}
+	n := FlushOnShutdown(cancelled)
+	n.Cancel()
+	Shutdown()
+	nextLine(t, lines, "Error flushing writer: disk full")
+	if cancelled.synced {
+		t.Fatal("cancelled writer was synced")
+	}
+}
+
+// fakeClient records calls to its close methods.
+type fakeClient struct {
+	closed, idle int
+	err          error
+}
+
+func (f *fakeClient) Close() error {
+	f.closed++
+	return f.err
+}
+
+type fakeTransport struct {
+	fakeClient
+}
+
+func (f *fakeTransport) CloseIdleConnections() {
+	f.idle++
+}
+
+func TestCloseClientsOnShutdown(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	lines, restore := logLines()
+	defer restore()
+	conn := &fakeClient{}
+	failing := &fakeClient{err: errors.New("conn failed")}
+	tr := &fakeTransport{}
+	var fnCalls int
+	fn := func() error {
+		fnCalls++
+		return errors.New("fn failed")
+	}
+	var nilFn func() error
+	_, n := CloseClientsOnShutdown(Stage3, conn, nil, failing, tr, fn, nilFn, "not a client")
+	if n != 4 {
+		t.Fatal("unexpected number of clients", n)
+	}
+	nextLine(t, lines, "CloseClientsOnShutdown: ignoring string")
+	Shutdown()
+	if conn.closed != 1 || failing.closed != 1 || fnCalls != 1 {
+		t.Fatal("clients not closed once", conn.closed, failing.closed, fnCalls)
+	}
+	// Idle connections are closed, instead of Close.
+	if tr.idle != 1 || tr.closed != 0 {
+		t.Fatal("unexpected transport calls", tr.idle, tr.closed)
+	}
+	l := nextLine(t, lines, "Error closing")
+	if !strings.Contains(l, "2 of 4 clients: conn failed; fn failed") {
+		t.Fatal("unexpected log line", l)
+	}
+}
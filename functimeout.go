@@ -0,0 +1,55 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"time"
+)
+
+// FirstFuncTimeout executes a function in the first stage of the shutdown,
+// like FirstFunc, but the stage only waits timeout for it to finish.
+//
+// This is for functions with a tighter limit than the stage timeout.
+// When timeout has passed, measured from when the stage starts waiting,
+// the stage continues without waiting for the function, which is logged.
+// The function is not stopped, and keeps running in its goroutine.
+// This does not make the stage time out. The stage timeout still applies.
+// Timeouts that are zero or negative are ignored with a warning,
+// and the function is registered like FirstFunc.
+func FirstFuncTimeout(fn ShutdownFn, v interface{}, timeout time.Duration) Notifier {
+	return defaultManager.FirstFuncTimeout(fn, v, timeout)
+}
+
+// FirstFuncTimeout executes a function in the first stage of the shutdown of the manager,
+// which the stage only waits timeout for.
+func (m *Manager) FirstFuncTimeout(fn ShutdownFn, v interface{}, timeout time.Duration) Notifier {
+	n := m.onFunc(1, fn, v)
+	if !validTimeout("FirstFuncTimeout", timeout) {
+		return n
+	}
+	nM.Lock()
+	if ns := notifiers[n]; ns != nil {
+		ns.timeout = timeout
+	}
+	nM.Unlock()
+	return n
+}
+
+// funcTimeout returns the time the stage waits for n, or the function
+// notifier it belongs to, see FirstFuncTimeout. 0 means the stage timeout.
+// m.sqM must be held.
+func (m *Manager) funcTimeout(stage int, n Notifier) time.Duration {
+	m.srM.RLock()
+	disabled := m.timeoutsDisabled
+	m.srM.RUnlock()
+	if disabled {
+		return 0
+	}
+	n = m.client(stage, n)
+	nM.Lock()
+	defer nM.Unlock()
+	if ns := notifiers[n]; ns != nil {
+		return ns.timeout
+	}
+	return 0
+}
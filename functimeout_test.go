@@ -0,0 +1,52 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFirstFuncTimeout(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	release := make(chan struct{})
+	finished := make(chan struct{})
+	FirstFuncTimeout(func(interface{}) {
+		<-release
+		close(finished)
+	}, nil, 50*time.Millisecond)
+	fast := false
+	FirstFunc(func(interface{}) { fast = true }, nil)
+
+	start := time.Now()
+	Shutdown()
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatal("stage waited for the function, took", d)
+	}
+	if !fast {
+		t.Fatal("other function not called")
+	}
+	s := LastSummary()
+	if s.TimedOut {
+		t.Fatal("stage timed out", s.Stages)
+	}
+	// The function keeps running.
+	close(release)
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("function did not finish")
+	}
+}
+
+func TestFirstFuncTimeoutInvalid(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeout(100 * time.Millisecond)
+	FirstFuncTimeout(func(interface{}) { select {} }, nil, -time.Second)
+	Shutdown()
+	if !LastSummary().TimedOut {
+		t.Fatal("invalid timeout was applied")
+	}
+}
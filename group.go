@@ -0,0 +1,82 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"sync"
+)
+
+// A Group links shutdown functions of a subsystem, which may be in different stages.
+//
+// A subsystem can for instance stop accepting work in the first stage,
+// and close its files in the third stage. Registering both with the
+// same group allows them to be cancelled together, while each is
+// still executed in its own stage.
+type Group struct {
+	m *Manager
+
+	mu      sync.Mutex // Mutex for below
+	members []groupMember
+}
+
+type groupMember struct {
+	n    Notifier
+	done chan struct{} // Closed when the function has returned.
+}
+
+// NewGroup returns a new, empty group.
+func NewGroup() *Group {
+	return defaultManager.NewGroup()
+}
+
+// NewGroup returns a new, empty group of the manager.
+func (m *Manager) NewGroup() *Group {
+	return &Group{m: m}
+}
+
+// Func registers a function in the given stage as a member of the group.
+// The returned notifier can be cancelled to remove only this function.
+func (g *Group) Func(s Stage, fn ShutdownFn, v interface{}) Notifier {
+	if fn == nil {
+		panic("shutdown: nil shutdown function")
+	}
+	done := make(chan struct{})
+	n := g.m.onFunc(s.n, func(v interface{}) {
+		defer close(done)
+		fn(v)
+	}, v)
+	g.mu.Lock()
+	g.members = append(g.members, groupMember{n: n, done: done})
+	g.mu.Unlock()
+	return n
+}
+
+// Cancel cancels all functions of the group, regardless of their stage.
+// Like Notifier.Cancel, functions that have already been
+// signalled are not affected.
+func (g *Group) Cancel() {
+	g.mu.Lock()
+	members := append([]groupMember(nil), g.members...)
+	g.mu.Unlock()
+	for _, mb := range members {
+		n := mb.n
+		n.Cancel()
+	}
+}
+
+// Wait blocks until all functions of the group have completed during shutdown.
+//
+// A function is also considered complete if it has been cancelled,
+// or if its stage has timed out. If shutdown is never started,
+// Wait will block forever, unless all functions are cancelled.
+func (g *Group) Wait() {
+	g.mu.Lock()
+	members := append([]groupMember(nil), g.members...)
+	g.mu.Unlock()
+	for _, mb := range members {
+		select {
+		case <-mb.done:
+		case <-mb.n.Expired():
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGroup(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	g := NewGroup()
+	var mu sync.Mutex
+	var order []int
+	add := func(v interface{}) {
+		mu.Lock()
+		order = append(order, v.(int))
+		mu.Unlock()
+	}
+	g.Func(Stage1, add, 1)
+	g.Func(Stage3, add, 3)
+
+	waited := make(chan struct{})
+	go func() {
+		g.Wait()
+		mu.Lock()
+		if len(order) != 2 || order[0] != 1 || order[1] != 3 {
+			t.Error("wait returned before all functions ran", order)
+		}
+		mu.Unlock()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("wait returned before shutdown")
+	case <-time.After(50 * time.Millisecond):
+	}
+	Shutdown()
+	<-waited
+}
+
+func TestGroupCancel(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	g := NewGroup()
+	var first, third, other bool
+	n1 := g.Func(Stage1, setBool, &first)
+	n3 := g.Func(Stage3, setBool, &third)
+	_ = ThirdFunc(setBool, &other)
+	g.Cancel()
+	if !n1.Cancelled() || !n3.Cancelled() {
+		t.Fatal("group members not cancelled")
+	}
+	if HasRegistrations(Stage1) {
+		t.Fatal("cancelled member still registered")
+	}
+	// All members are cancelled, so this must not block.
+	g.Wait()
+	Shutdown()
+	if first || third {
+		t.Fatal("cancelled group member was called", first, third)
+	}
+	if !other {
+		t.Fatal("function outside the group was not called")
+	}
+}
+
+func TestGroupTimeout(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeout(100 * time.Millisecond)
+	g := NewGroup()
+	block := make(chan struct{})
+	defer close(block)
+	g.Func(Stage2, func(interface{}) { <-block }, nil)
+	Shutdown()
+	// The stage has timed out, so this must not block.
+	g.Wait()
+}
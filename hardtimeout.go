@@ -0,0 +1,138 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"time"
+)
+
+// defaultCriticalCeiling is the default maximum time to wait
+// for notifiers marked with WithNoHardTimeout.
+const defaultCriticalCeiling = time.Minute
+
+// SetHardTimeout sets the maximum time the whole shutdown may take.
+//
+// The stage timeouts still apply. When the hard timeout expires, the running
+// stage stops waiting, and the notifiers of the remaining stages are not
+// signalled, so the application can exit. Notifiers marked with
+// WithNoHardTimeout are still signalled and waited for, see SetCriticalCeiling.
+// Use 0 to remove the hard timeout, which is the default.
+// Timeouts that are negative are ignored with a warning.
+func SetHardTimeout(d time.Duration) {
+	defaultManager.SetHardTimeout(d)
+}
+
+// SetHardTimeout sets the maximum time the whole shutdown of the manager may take.
+func (m *Manager) SetHardTimeout(d time.Duration) {
+	if d != 0 && !validTimeout("SetHardTimeout", d) {
+		return
+	}
+	m.srM.Lock()
+	m.hardTimeout = d
+	m.srM.Unlock()
+}
+
+// SetCriticalCeiling sets the maximum time, measured from the start of
+// the shutdown, to wait for notifiers marked with WithNoHardTimeout,
+// when the hard timeout has expired. This prevents a hanging notifier
+// from preventing the application from exiting.
+// The default is one minute. A ceiling shorter than the hard timeout has no effect.
+// Timeouts that are zero or negative are ignored with a warning.
+func SetCriticalCeiling(d time.Duration) {
+	defaultManager.SetCriticalCeiling(d)
+}
+
+// SetCriticalCeiling sets the maximum time to wait for notifiers of the manager marked with WithNoHardTimeout.
+func (m *Manager) SetCriticalCeiling(d time.Duration) {
+	if !validTimeout("SetCriticalCeiling", d) {
+		return
+	}
+	m.srM.Lock()
+	m.criticalCeiling = d
+	m.srM.Unlock()
+}
+
+// WithNoHardTimeout marks the notifier as critical, so it is not
+// abandoned when the hard timeout expires, see SetHardTimeout.
+// The notifier is returned, so it can be used when registering:
+//
+//	n := shutdown.Third().WithNoHardTimeout()
+//
+// This is meant for cleanup that must complete to avoid losing data,
+// like flushing a write-ahead log. Use it sparingly: shutdown can then
+// take up to the critical ceiling, see SetCriticalCeiling, which may be
+// longer than the time the application is given to exit. If it is killed
+// first, nothing is gained. The stage timeout still applies until the
+// hard timeout expires.
+func (s Notifier) WithNoHardTimeout() Notifier {
+	nM.Lock()
+	if ns := notifiers[s]; ns != nil {
+		ns.critical = true
+	}
+	nM.Unlock()
+	return s
+}
+
+// critical returns true if n, or the function notifier it belongs to,
+// is marked with WithNoHardTimeout. m.sqM must be held.
+func (m *Manager) critical(stage int, n Notifier) bool {
+	n = m.client(stage, n)
+	nM.Lock()
+	defer nM.Unlock()
+	ns := notifiers[n]
+	return ns != nil && ns.critical
+}
+
+// hardDeadlines returns the monotonic time the hard timeout and the
+// critical ceiling of the running shutdown expire.
+// If there is no hard timeout, ok is false.
+func (m *Manager) hardDeadlines() (hard, ceiling time.Duration, ok bool) {
+	m.srM.RLock()
+	defer m.srM.RUnlock()
+	if m.hardTimeout == 0 || m.timeoutsDisabled {
+		return 0, 0, false
+	}
+	hard = m.startedMono + m.hardTimeout
+	ceiling = m.startedMono + m.criticalCeiling
+	if ceiling < hard {
+		ceiling = hard
+	}
+	return hard, ceiling, true
+}
+
+// hardTimedOut returns true if the hard timeout of the running shutdown has expired.
+func (m *Manager) hardTimedOut() bool {
+	hard, _, ok := m.hardDeadlines()
+	return ok && m.clock.Mono() >= hard
+}
+
+// abandon stops notifiers of the stage that are not critical,
+// after the hard timeout has expired. It returns the critical notifiers
+// of the queue and their labels. Functions of abandoned notifiers are not called.
+// m.sqM must be held.
+func (m *Manager) abandon(stage int, labels []string) ([]Notifier, []string) {
+	var queue []Notifier
+	var kept []string
+	for i, n := range m.shutdownQueue[stage] {
+		if m.critical(stage, n) {
+			queue = append(queue, n)
+			kept = append(kept, labels[i])
+			continue
+		}
+		expire(n)
+	}
+	for _, fn := range m.shutdownFnQueue[stage] {
+		if m.critical(stage, fn.client) {
+			continue
+		}
+		// Stop the goroutine waiting to call the function.
+		select {
+		case <-fn.cancel:
+		default:
+			close(fn.cancel)
+		}
+		fire(fn.client)
+		expire(fn.client)
+	}
+	return queue, kept
+}
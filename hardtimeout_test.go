@@ -0,0 +1,74 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHardTimeout(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeout(time.Second)
+	SetHardTimeout(100 * time.Millisecond)
+	lines, restore := logLines()
+	defer restore()
+
+	critical := First().WithNoHardTimeout()
+	var flushed bool
+	go func() {
+		v := <-critical
+		time.Sleep(300 * time.Millisecond)
+		flushed = true
+		close(v)
+	}()
+	slow := First()
+	go func() {
+		<-slow
+		// Never finish
+	}()
+	var second, criticalSecond bool
+	abandoned := SecondFunc(setBool, &second)
+	SecondFunc(setBool, &criticalSecond).WithNoHardTimeout()
+
+	tn := time.Now()
+	Shutdown()
+	if d := time.Since(tn); d < 300*time.Millisecond || d > 900*time.Millisecond {
+		t.Fatal("unexpected shutdown duration", d)
+	}
+	if !flushed {
+		t.Fatal("shutdown did not wait for critical notifier")
+	}
+	if second || !criticalSecond {
+		t.Fatal("unexpected functions called", second, criticalSecond)
+	}
+	select {
+	case <-abandoned.Expired():
+	default:
+		t.Fatal("abandoned notifier not expired")
+	}
+	nextLine(t, lines, "hard timeout expired, waiting for 1 critical notifiers")
+	nextLine(t, lines, "Hard timeout expired, only signalling critical notifiers of second stage")
+	if s := LastSummary(); !s.Stages[1].TimedOut || s.Stages[2].TimedOut {
+		t.Fatal("unexpected summary", s.Stages)
+	}
+}
+
+func TestHardTimeoutCeiling(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeout(time.Second)
+	SetHardTimeout(100 * time.Millisecond)
+	SetCriticalCeiling(300 * time.Millisecond)
+	critical := First().WithNoHardTimeout()
+	go func() {
+		<-critical
+		// Never finish
+	}()
+	tn := time.Now()
+	Shutdown()
+	if d := time.Since(tn); d < 300*time.Millisecond || d > 900*time.Millisecond {
+		t.Fatal("unexpected shutdown duration", d)
+	}
+}
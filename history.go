@@ -0,0 +1,68 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+// defaultHistorySize is the default number of summaries kept, see SetHistorySize.
+const defaultHistorySize = 4
+
+// History returns the summaries of the most recently completed shutdowns,
+// oldest first. See LastSummary and SetHistorySize.
+//
+// This is useful when a manager is shut down several times, see Reset.
+// Summaries that timed out are included. The returned summaries are copies.
+func History() []Summary {
+	return defaultManager.History()
+}
+
+// History returns the summaries of the most recently completed shutdowns of the manager.
+func (m *Manager) History() []Summary {
+	m.srM.RLock()
+	defer m.srM.RUnlock()
+	h := make([]Summary, len(m.history))
+	for i, s := range m.history {
+		h[i] = s.copy()
+	}
+	return h
+}
+
+// SetHistorySize sets the number of summaries kept by History.
+// The default is 4. Use 0 to keep none.
+// Sizes that are negative are ignored with a warning.
+func SetHistorySize(n int) {
+	defaultManager.SetHistorySize(n)
+}
+
+// SetHistorySize sets the number of summaries of the manager kept by History.
+func (m *Manager) SetHistorySize(n int) {
+	if n < 0 {
+		Logger.Printf("SetHistorySize: ignoring invalid size %d", n)
+		return
+	}
+	m.srM.Lock()
+	m.historySize = n
+	m.trimHistory()
+	m.srM.Unlock()
+}
+
+// addHistory adds a summary to the history. m.srM must be held.
+func (m *Manager) addHistory(s Summary) {
+	m.history = append(m.history, s.copy())
+	m.trimHistory()
+}
+
+// trimHistory removes the oldest summaries above the history size.
+// m.srM must be held.
+func (m *Manager) trimHistory() {
+	if drop := len(m.history) - m.historySize; drop > 0 {
+		// Copy, so the dropped summaries can be collected.
+		m.history = append([]Summary(nil), m.history[drop:]...)
+	}
+}
+
+// copy returns a copy of the summary that doesn't share memory with s.
+func (s Summary) copy() Summary {
+	s.Stages = append([]StageSummary(nil), s.Stages...)
+	s.Callbacks = append([]CallbackSummary(nil), s.Callbacks...)
+	s.Channels = append([]ChannelSummary(nil), s.Channels...)
+	return s
+}
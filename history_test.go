@@ -0,0 +1,60 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	if h := History(); len(h) != 0 {
+		t.Fatal("history before shutdown", h)
+	}
+	SetHistorySize(2)
+	var first []Summary
+	// Run i has i panicking functions, so the runs can be told apart.
+	for i := 1; i <= 3; i++ {
+		for j := 0; j < i; j++ {
+			_ = FirstFunc(func(interface{}) { panic("This is expected") }, nil)
+		}
+		Shutdown()
+		if i == 1 {
+			first = History()
+		}
+		Reset()
+	}
+
+	h := History()
+	if len(h) != 2 {
+		t.Fatal("expected two summaries, got", len(h))
+	}
+	if h[0].Panics != 2 || h[1].Panics != 3 {
+		t.Fatal("unexpected order", h[0].Panics, h[1].Panics)
+	}
+	if h[0].Reason.Time.After(h[1].Reason.Time) {
+		t.Fatal("unexpected times", h[0].Reason.Time, h[1].Reason.Time)
+	}
+	if len(first) != 1 || first[0].Panics != 1 || len(first[0].Stages) != 2 {
+		t.Fatal("earlier history changed", first)
+	}
+
+	// The returned summaries must not share memory with the history.
+	h[1].Stages[0].Stage = 42
+	if s := History()[1]; s.Stages[0].Stage == 42 {
+		t.Fatal("history shares stages")
+	}
+	if s := LastSummary(); s.Stages[0].Stage == 42 {
+		t.Fatal("last summary shares stages")
+	}
+
+	SetHistorySize(1)
+	if h := History(); len(h) != 1 || h[0].Panics != 3 {
+		t.Fatal("history not trimmed", h)
+	}
+	SetHistorySize(-1)
+	if h := History(); len(h) != 1 {
+		t.Fatal("invalid size applied", h)
+	}
+}
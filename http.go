@@ -3,6 +3,7 @@
 package shutdown
 
 import (
+	"encoding/json"
 	"net/http"
 )
 
@@ -11,6 +12,9 @@ import (
 // and will return http.StatusServiceUnavailable if
 // shutdown has been initiated.
 func WrapHandler(h http.Handler) http.Handler {
+	if h == nil {
+		panic("shutdown: nil handler")
+	}
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		if !Lock() {
 			w.WriteHeader(http.StatusServiceUnavailable)
@@ -28,6 +32,9 @@ func WrapHandler(h http.Handler) http.Handler {
 // The handler will return http.StatusServiceUnavailable if
 // shutdown has been initiated.
 func WrapHandlerFunc(h http.HandlerFunc) http.HandlerFunc {
+	if h == nil {
+		panic("shutdown: nil handler")
+	}
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		if !Lock() {
 			w.WriteHeader(http.StatusServiceUnavailable)
@@ -39,3 +46,90 @@ func WrapHandlerFunc(h http.HandlerFunc) http.HandlerFunc {
 	}
 	return http.HandlerFunc(fn)
 }
+
+// WrapHandlerStage will return an http Handler
+// that will return http.StatusServiceUnavailable if
+// shutdown has been initiated.
+//
+// Unlike WrapHandler, shutdown will not wait for requests to complete
+// before the Preshutdown stage has finished, but the given stage will
+// wait for all requests to complete. See LockStage.
+func WrapHandlerStage(s Stage, h http.Handler) http.Handler {
+	if h == nil {
+		panic("shutdown: nil handler")
+	}
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if !LockStage(s) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		// We defer, so panics will not keep a lock
+		defer UnlockStage(s)
+		h.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// healthzStatus is the body written by HealthzHandler.
+type healthzStatus struct {
+	Started   bool   `json:"started"`
+	Completed bool   `json:"completed"`
+	Stage     string `json:"stage,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	Locks     int    `json:"locks"`
+}
+
+// HealthzHandler returns an http Handler for health probes.
+//
+// The handler responds with http.StatusOK, until shutdown has been
+// initiated, after which it responds with http.StatusServiceUnavailable.
+// The body is a JSON object with whether shutdown has started or completed,
+// the running stage, the reason of the shutdown and the locks held.
+func HealthzHandler() http.Handler {
+	return defaultManager.HealthzHandler()
+}
+
+// HealthzHandler returns an http Handler for health probes of the manager.
+func (m *Manager) HealthzHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := m.status()
+		h := healthzStatus{
+			Started:   s.Started,
+			Completed: s.Completed,
+			Stage:     s.Stage,
+			Locks:     s.Locks,
+		}
+		if s.Started {
+			h.Reason = m.Stats().Reason.Cause
+		}
+		writeStatus(w, s.Started, h)
+	})
+}
+
+// ReadyzHandler returns an http Handler for readiness probes.
+//
+// The handler responds like HealthzHandler, but the body also contains
+// the status of each stage, see WriteStatus.
+func ReadyzHandler() http.Handler {
+	return defaultManager.ReadyzHandler()
+}
+
+// ReadyzHandler returns an http Handler for readiness probes of the manager.
+func (m *Manager) ReadyzHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := m.status()
+		writeStatus(w, s.Started, s)
+	})
+}
+
+// writeStatus writes v as JSON, with http.StatusServiceUnavailable if started is true.
+func writeStatus(w http.ResponseWriter, started bool, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if started {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	json.NewEncoder(w).Encode(v)
+}
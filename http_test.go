@@ -4,6 +4,7 @@ package shutdown
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"html"
 	"net/http"
@@ -291,3 +292,118 @@ func TestWrapHandlerFuncOrder(t *testing.T) {
 		t.Fatal("Function had not finished")
 	}
 }
+
+// Tests that the stage given to WrapHandlerStage waits for the handler,
+// while the stages before it do not.
+func TestWrapHandlerStage(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var wait = make(chan bool)
+	var waiting = make(chan bool)
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(waiting)
+		<-wait
+	})
+	wrapped := WrapHandlerStage(Stage1, fn)
+
+	go func() {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("", "", bytes.NewBufferString(""))
+		wrapped.ServeHTTP(res, req)
+	}()
+	<-waiting
+
+	var first = make(chan bool)
+	var second bool
+	PreShutdownFunc(func(interface{}) {}, nil)
+	FirstFunc(func(interface{}) { close(first) }, nil)
+	SecondFunc(setBool, &second)
+
+	completed := make(chan bool)
+	go func() {
+		Shutdown()
+		close(completed)
+	}()
+
+	// The first stage must start while the request is in flight.
+	<-first
+	select {
+	case <-completed:
+		t.Fatal("shutdown completed while request was in flight")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if second {
+		t.Fatal("second stage started while request was in flight")
+	}
+
+	close(wait)
+	<-completed
+	if !second {
+		t.Fatal("second stage was not run")
+	}
+
+	// New requests must be refused.
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("", "", bytes.NewBufferString(""))
+	wrapped.ServeHTTP(res, req)
+	if res.Code != http.StatusServiceUnavailable {
+		t.Fatal("unexpected status code", res.Code)
+	}
+}
+
+func TestWrapNilHandler(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	const want = "shutdown: nil handler"
+	expectPanic(t, want, func() { WrapHandler(nil) })
+	expectPanic(t, want, func() { WrapHandlerFunc(nil) })
+	expectPanic(t, want, func() { WrapHandlerStage(Stage1, nil) })
+}
+
+func TestHealthzHandler(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	FirstFunc(func(interface{}) {}, nil)
+	for _, h := range []http.Handler{HealthzHandler(), ReadyzHandler()} {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/", nil)
+		h.ServeHTTP(res, req)
+		if res.Code != http.StatusOK {
+			t.Fatal("unexpected status code", res.Code)
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if body["started"] != false {
+			t.Fatal("expected started to be false, got", body["started"])
+		}
+	}
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	ReadyzHandler().ServeHTTP(res, req)
+	var status shutdownStatus
+	if err := json.Unmarshal(res.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Pending["first"] != 1 {
+		t.Fatal("expected one pending notifier in First, got", status.Pending)
+	}
+
+	Shutdown()
+	for _, h := range []http.Handler{HealthzHandler(), ReadyzHandler()} {
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		if res.Code != http.StatusServiceUnavailable {
+			t.Fatal("unexpected status code", res.Code)
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if body["started"] != true || body["completed"] != true {
+			t.Fatal("expected started and completed to be true, got", body)
+		}
+	}
+}
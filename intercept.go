@@ -0,0 +1,120 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"sync/atomic"
+)
+
+// NotifierInfo describes a shutdown function given to the interceptor,
+// see SetCallbackInterceptor.
+type NotifierInfo struct {
+	ID       uint64      // The id of the notifier returned for the function, see Notifier.ID.
+	Stage    Stage       // The stage of the function.
+	CallSite string      // Where the function was registered, see SetDebugMode.
+	Value    interface{} // The value given to the function.
+
+	simulated *int32 // Set by Simulated.
+}
+
+// Simulated records that the interceptor has simulated the function,
+// instead of skipping it, see CallbackSummary.
+func (i NotifierInfo) Simulated() {
+	if i.simulated != nil {
+		atomic.StoreInt32(i.simulated, 1)
+	}
+}
+
+// CallbackOutcome is what the interceptor did with a shutdown function.
+type CallbackOutcome string
+
+const (
+	CallbackExecuted  CallbackOutcome = "executed"  // The function was called.
+	CallbackSimulated CallbackOutcome = "simulated" // The function was not called, see NotifierInfo.Simulated.
+	CallbackSkipped   CallbackOutcome = "skipped"   // The function was not called.
+)
+
+// CallbackSummary describes a shutdown function given to the interceptor.
+type CallbackSummary struct {
+	ID      uint64 // The id of the notifier returned for the function.
+	Stage   int    // The stage, see WithGracefulDegradation.
+	Outcome CallbackOutcome
+}
+
+// SetCallbackInterceptor sets a function that is called instead of
+// each shutdown function, like FirstFunc, during shutdown.
+//
+// The interceptor decides whether the function is run, by calling invoke,
+// or not. This allows shutdown drills, where destructive functions are
+// replaced by logging. If the interceptor simulates the function, it
+// should call info.Simulated. What was done with each function is
+// recorded in Summary.Callbacks, see LastSummary. Calling invoke more
+// than once has no effect. The functions that wait for locks to be
+// released are not intercepted. Use nil to call the functions directly,
+// which is the default.
+func SetCallbackInterceptor(fn func(info NotifierInfo, invoke func())) {
+	defaultManager.SetCallbackInterceptor(fn)
+}
+
+// SetCallbackInterceptor sets a function that is called instead of each shutdown function of the manager.
+func (m *Manager) SetCallbackInterceptor(fn func(info NotifierInfo, invoke func())) {
+	m.srM.Lock()
+	m.interceptor = fn
+	m.srM.Unlock()
+}
+
+// intercept calls fn with v, through the interceptor, if one is set.
+// client is the notifier returned for the function.
+func (m *Manager) intercept(stage int, client Notifier, fn ShutdownFn, v interface{}) {
+	m.srM.RLock()
+	ic := m.interceptor
+	m.srM.RUnlock()
+	if ic == nil || isInternal(client) {
+		fn(v)
+		return
+	}
+	var invoked, simulated int32
+	info := NotifierInfo{
+		ID:        client.ID(),
+		Stage:     Stage{stage},
+		CallSite:  client.CallSite(),
+		Value:     v,
+		simulated: &simulated,
+	}
+	defer func() {
+		// Recorded even if the function panics.
+		outcome := CallbackSkipped
+		switch {
+		case atomic.LoadInt32(&invoked) != 0:
+			outcome = CallbackExecuted
+		case atomic.LoadInt32(&simulated) != 0:
+			outcome = CallbackSimulated
+		}
+		m.srM.Lock()
+		m.callbacks = append(m.callbacks, CallbackSummary{ID: info.ID, Stage: stage, Outcome: outcome})
+		m.srM.Unlock()
+	}()
+	ic(info, func() {
+		if atomic.CompareAndSwapInt32(&invoked, 0, 1) {
+			fn(v)
+		}
+	})
+}
+
+// markInternal marks a function notifier created by the package,
+// so it is called without the interceptor.
+func markInternal(n Notifier) {
+	nM.Lock()
+	if ns := notifiers[n]; ns != nil {
+		ns.internal = true
+	}
+	nM.Unlock()
+}
+
+// isInternal returns true if n is marked by markInternal.
+func isInternal(n Notifier) bool {
+	nM.Lock()
+	defer nM.Unlock()
+	ns := notifiers[n]
+	return ns != nil && ns.internal
+}
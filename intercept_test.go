@@ -0,0 +1,72 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSetCallbackInterceptor(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var mu sync.Mutex
+	ran := make(map[string]bool)
+	fn := func(v interface{}) {
+		mu.Lock()
+		ran[v.(string)] = true
+		mu.Unlock()
+	}
+	ids := make(map[uint64]string)
+	for _, name := range []string{"flush", "drop tables", "drop cache", "close"} {
+		n := SecondFunc(fn, name)
+		ids[n.ID()] = name
+	}
+	SetCallbackInterceptor(func(info NotifierInfo, invoke func()) {
+		name := fmt.Sprint(info.Value)
+		switch {
+		case name == "close":
+			info.Simulated()
+		case strings.HasPrefix(name, "drop"):
+		default:
+			invoke()
+		}
+	})
+	Shutdown()
+
+	if !ran["flush"] || ran["drop tables"] || ran["drop cache"] || ran["close"] {
+		t.Fatal("unexpected functions ran", ran)
+	}
+	want := map[string]CallbackOutcome{
+		"flush":       CallbackExecuted,
+		"drop tables": CallbackSkipped,
+		"drop cache":  CallbackSkipped,
+		"close":       CallbackSimulated,
+	}
+	s := LastSummary()
+	if len(s.Callbacks) != len(want) {
+		t.Fatalf("expected %d callbacks, got %+v", len(want), s.Callbacks)
+	}
+	for _, c := range s.Callbacks {
+		name := ids[c.ID]
+		if c.Outcome != want[name] || c.Stage != Stage2.n {
+			t.Errorf("%s: got %+v, want outcome %s", name, c, want[name])
+		}
+	}
+}
+
+func TestCallbackInterceptorDefault(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	ran := false
+	FirstFunc(func(interface{}) { ran = true }, nil)
+	Shutdown()
+	if !ran {
+		t.Fatal("function was not called")
+	}
+	if s := LastSummary(); s.Callbacks != nil {
+		t.Fatal("callbacks recorded without an interceptor", s.Callbacks)
+	}
+}
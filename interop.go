@@ -0,0 +1,101 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+)
+
+// copiesProfile is the name of the profile, see runtime/pprof, where each
+// copy of this package in the process registers itself, see registerCopy.
+// Unlike package state, profiles are shared by all copies in the process.
+// It must never change, since copies of other versions look for it.
+const copiesProfile = "github.com/klauspost/shutdown.copies"
+
+var registerOnce sync.Once
+
+// RegisterExternalTrigger calls fn in the given stage of the shutdown,
+// and waits for it to return, like any other shutdown function.
+//
+// This lets shutdown of another copy of this package chain off this one.
+// If a binary contains several copies, for instance of different major
+// versions or from vendored dependencies, each has its own state, and
+// signals handled by one don't reach functions registered with another.
+// Designate one copy as the primary, which handles signals, see OnSignal,
+// and give the TriggerExternal function of each of the others to it:
+//
+//	shutdown.RegisterExternalTrigger(shutdown.Stage2, othershutdown.TriggerExternal)
+//
+// The entire shutdown of the other copy then runs in the given stage,
+// after the stages before it have completed, and before the stages
+// after it start. The timeout of the stage applies. Don't chain copies
+// both ways, since each shutdown would then wait for the other.
+// The returned Notifier can be used to cancel it.
+//
+// Copies that chain others, or handle signals, register themselves,
+// and a warning is logged when more than one is registered.
+func RegisterExternalTrigger(s Stage, fn func()) Notifier {
+	return defaultManager.RegisterExternalTrigger(s, fn)
+}
+
+// RegisterExternalTrigger calls fn in the given stage of the shutdown of the manager.
+func (m *Manager) RegisterExternalTrigger(s Stage, fn func()) Notifier {
+	if fn == nil {
+		panic("shutdown: nil external trigger")
+	}
+	registerThisCopy()
+	return m.onFunc(s.n, func(interface{}) { fn() }, nil)
+}
+
+// TriggerExternal starts shutdown like Shutdown, when it was triggered by
+// another copy of this package, see RegisterExternalTrigger.
+// It returns when shutdown has completed.
+func TriggerExternal() {
+	defaultManager.TriggerExternal()
+}
+
+// TriggerExternal starts shutdown of the manager, when it was triggered by another copy of this package.
+func (m *Manager) TriggerExternal() {
+	m.shutdown(Reason{Cause: "external trigger"})
+}
+
+// registerThisCopy registers this copy of the package, see registerCopy.
+// It is done when needed, so nothing is registered by copies that aren't used.
+func registerThisCopy() {
+	registerOnce.Do(func() {
+		_, file, _, ok := runtime.Caller(0)
+		if !ok {
+			return
+		}
+		registerCopy(copiesRegistry(copiesProfile), filepath.Dir(file))
+	})
+}
+
+// copiesRegistry returns the profile with the given name, and creates it
+// if no copy of the package has done so.
+func copiesRegistry(name string) (p *pprof.Profile) {
+	defer func() {
+		if recover() != nil {
+			// Created by another copy in the meantime.
+			p = pprof.Lookup(name)
+		}
+	}()
+	if p = pprof.Lookup(name); p == nil {
+		p = pprof.NewProfile(name)
+	}
+	return p
+}
+
+// registerCopy registers a copy of the package located at dir in p,
+// and logs a warning if other copies are registered.
+// The profile holds the stack of each registration, which shows
+// where the copies are located.
+func registerCopy(p *pprof.Profile, dir string) {
+	p.Add(dir, 0)
+	if n := p.Count(); n > 1 {
+		Logger.Printf("WARNING: %d copies of the shutdown package are in use, one at %s. Each has its own shutdown, see RegisterExternalTrigger. The %s profile shows where they are.", n, dir, p.Name())
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"bytes"
+	"reflect"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestExternalTrigger(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	// The managers stand in for two copies of the package.
+	primary, secondary := NewManager(), NewManager()
+	var mu sync.Mutex
+	var events []string
+	record := func(event string) func(interface{}) {
+		return func(interface{}) {
+			mu.Lock()
+			events = append(events, event)
+			mu.Unlock()
+		}
+	}
+	primary.FirstFunc(record("primary first"), nil)
+	primary.ThirdFunc(record("primary third"), nil)
+	secondary.FirstFunc(record("secondary first"), nil)
+	secondary.ThirdFunc(record("secondary third"), nil)
+	primary.RegisterExternalTrigger(Stage2, secondary.TriggerExternal)
+	cancelled := primary.RegisterExternalTrigger(Stage2, func() { t.Error("cancelled trigger called") })
+	cancelled.Cancel()
+
+	primary.Shutdown()
+	want := []string{"primary first", "secondary first", "secondary third", "primary third"}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("got %q, want %q", events, want)
+	}
+	if r := secondary.Stats().Reason.Cause; r != "external trigger" {
+		t.Fatal("unexpected reason", r)
+	}
+	expectPanic(t, "shutdown: nil external trigger", func() {
+		RegisterExternalTrigger(Stage1, nil)
+	})
+}
+
+func TestRegisterCopy(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	p := copiesRegistry(copiesProfile + ".test")
+	if q := copiesRegistry(copiesProfile + ".test"); q != p {
+		t.Fatal("profile created twice")
+	}
+	defer p.Remove("/own/shutdown")
+	defer p.Remove("/vendor/other/shutdown")
+
+	lines, restore := logLines()
+	defer restore()
+	registerCopy(p, "/own/shutdown")
+	registerCopy(p, "/vendor/other/shutdown")
+	l := nextLine(t, lines, "2 copies of the shutdown package")
+	if !strings.Contains(l, "/vendor/other/shutdown") || !strings.Contains(l, p.Name()) {
+		t.Fatal("copies not logged:", l)
+	}
+	// The registrations show where the copies are.
+	var buf bytes.Buffer
+	p.WriteTo(&buf, 1)
+	if !strings.Contains(buf.String(), "interop.go") {
+		t.Fatal("registrations not in the profile:", buf.String())
+	}
+
+	registerThisCopy()
+	if p := pprof.Lookup(copiesProfile); p == nil || p.Count() != 1 {
+		t.Fatal("this copy is not registered")
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+// SetStageLIFO sets if the notifiers of a stage are signalled one at a time,
+// in reverse registration order.
+//
+// This works like defer: the most recently registered notifier is signalled
+// first, and the next one is not signalled until it has finished.
+// This can be used for resources that are acquired in sequence,
+// and must be released in the opposite order.
+// The stage timeout still applies to the stage as a whole. If it expires,
+// the remaining notifiers are not signalled, and their functions are not called.
+func SetStageLIFO(s Stage, lifo bool) {
+	defaultManager.SetStageLIFO(s, lifo)
+}
+
+// SetStageLIFO sets if the notifiers of a stage of the manager are signalled one at a time, in reverse order.
+func (m *Manager) SetStageLIFO(s Stage, lifo bool) {
+	m.srM.Lock()
+	m.lifo[s.n] = lifo
+	m.srM.Unlock()
+}
+
+// signalReverse signals the notifiers of queue one at a time, starting with the last.
+// done[i] is closed when queue[i] has finished.
+// The returned function stops signalling and returns the notifiers that were signalled.
+// Notifiers that were not signalled are expired, and their functions will not be called.
+// queue and fns must be copies, since they are used without holding m.sqM,
+// which must be held when it is called.
+func (m *Manager) signalReverse(stage int, queue []Notifier, fns []fnNotify, done []chan struct{}, bufSize int) func() []Notifier {
+	// The notifiers given to the interceptors, see Use.
+	clients := make([]Notifier, len(queue))
+	milestones := make([][]*Milestone, len(queue))
+	for i, n := range queue {
+		clients[i] = m.client(stage, n)
+		milestones[i] = m.milestonesOf(stage, n)
+	}
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	var signalled []Notifier
+	next := len(queue) - 1
+	go func() {
+		defer close(stopped)
+		for ; next >= 0; next-- {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			n := queue[next]
+			if !awaitMilestones(stage, "notifier "+describe(clients[next]), milestones[next], stop) {
+				return
+			}
+			if !fire(n) {
+				// Already signalled by a merged manager.
+				close(done[next])
+				continue
+			}
+			m.throttle()
+			c := make(chan struct{}, bufSize)
+			m.signalThrough(stage, clients[next], func() { n <- c })
+			signalled = append(signalled, n)
+			select {
+			case <-c:
+			case <-stop:
+				return
+			}
+			close(done[next])
+		}
+	}()
+	return func() []Notifier {
+		close(stop)
+		<-stopped
+		if next < 0 {
+			return signalled
+		}
+		// The stage timed out before these were signalled.
+		// The notifier at next was signalled, unless stopped before that.
+		if len(signalled) > 0 && signalled[len(signalled)-1] == queue[next] {
+			next--
+		}
+		m.sqM.Lock()
+		defer m.sqM.Unlock()
+		for _, n := range queue[:next+1] {
+			expire(n)
+			for _, fn := range fns {
+				if fn.internal != n {
+					continue
+				}
+				// Stop the goroutine waiting to call the function.
+				select {
+				case <-fn.cancel:
+				default:
+					close(fn.cancel)
+				}
+				expire(fn.client)
+			}
+		}
+		return signalled
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSetStageLIFO(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetStageLIFO(Stage2, true)
+	var mu sync.Mutex
+	var order []int
+	for i := 0; i < 3; i++ {
+		_ = SecondFunc(func(v interface{}) {
+			// Give functions running concurrently a chance to overtake.
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			order = append(order, v.(int))
+			mu.Unlock()
+		}, i)
+	}
+	Shutdown()
+	if len(order) != 3 || order[0] != 2 || order[1] != 1 || order[2] != 0 {
+		t.Fatal("unexpected order", order)
+	}
+}
+
+func TestSetStageLIFOTimeout(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetStageLIFO(Stage1, true)
+	SetTimeout(100 * time.Millisecond)
+	var first bool
+	f := FirstFunc(setBool, &first)
+	n := First()
+	go func() {
+		<-n
+		// Never finish
+	}()
+	Shutdown()
+	if first {
+		t.Fatal("function was called after the stage timed out")
+	}
+	select {
+	case <-f.Expired():
+	default:
+		t.Fatal("notifier was not expired")
+	}
+}
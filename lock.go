@@ -0,0 +1,119 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"sync/atomic"
+)
+
+// lockCounter counts locks held.
+//
+// The state is a single word. The lowest bit is set when shutdown
+// has started, and the rest is the number of locks held.
+type lockCounter struct {
+	v       int64         // Accessed atomically. Must be first for alignment.
+	drained chan struct{} // Closed when shutdown has started and all locks are released.
+}
+
+const (
+	lockShutdown = 1
+	lockOne      = 2
+)
+
+// tryLock acquires a lock, unless shutdown has started.
+func (l *lockCounter) tryLock() bool {
+	for {
+		v := atomic.LoadInt64(&l.v)
+		if v&lockShutdown != 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&l.v, v, v+lockOne) {
+			return true
+		}
+	}
+}
+
+// unlock releases a lock.
+// If shutdown has started and this was the last lock, the drain is signalled.
+func (l *lockCounter) unlock() {
+	v := atomic.AddInt64(&l.v, -lockOne)
+	if v < 0 {
+		panic("shutdown: Unlock called without a lock")
+	}
+	if v == lockShutdown {
+		close(l.drained)
+	}
+}
+
+// close prevents new locks from being acquired.
+// If no locks are held, the drain is signalled.
+// It returns the number of locks held.
+func (l *lockCounter) close() int {
+	for {
+		v := atomic.LoadInt64(&l.v)
+		if v&lockShutdown != 0 {
+			return int(v / lockOne)
+		}
+		if atomic.CompareAndSwapInt64(&l.v, v, v|lockShutdown) {
+			if v == 0 {
+				close(l.drained)
+			}
+			return int(v / lockOne)
+		}
+	}
+}
+
+// held returns the number of locks held.
+func (l *lockCounter) held() int {
+	return int(atomic.LoadInt64(&l.v) / lockOne)
+}
+
+// closeLocks prevents new locks from being acquired.
+// For each stage where locks are held, a function waiting
+// for the locks to be released is added to the stage.
+func (m *Manager) closeLocks() {
+	m.locks.close()
+	// Release locks waiting because of Quiesce, so they fail.
+	m.Unquiesce()
+	m.closeSemaphores()
+	for stage := 1; stage < numStages; stage++ {
+		l := m.stageLocks[stage]
+		if l.close() > 0 {
+			markInternal(m.onFunc(stage, func(interface{}) {
+				<-l.drained
+			}, nil))
+		}
+	}
+}
+
+// LockStage works like Lock, but instead of the Preshutdown stage,
+// the given stage will wait for the lock to be released.
+// This allows the work to continue while earlier stages run.
+// Like Lock, no new locks can be acquired once shutdown has started.
+//
+// If the function returned true, you must call UnlockStage
+// with the same stage once to release the lock.
+func LockStage(s Stage) bool {
+	return defaultManager.LockStage(s)
+}
+
+// LockStage acquires a lock of the manager that the given stage waits for.
+func (m *Manager) LockStage(s Stage) bool {
+	if m.isDisabled() {
+		return true
+	}
+	return m.stageLocks[s.n].tryLock()
+}
+
+// UnlockStage will release a lock acquired with LockStage.
+func UnlockStage(s Stage) {
+	defaultManager.UnlockStage(s)
+}
+
+// UnlockStage will release a lock of the manager acquired with LockStage.
+func (m *Manager) UnlockStage(s Stage) {
+	if m.isDisabled() {
+		return
+	}
+	m.stageLocks[s.n].unlock()
+}
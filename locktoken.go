@@ -0,0 +1,176 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// maxNoteLen is the maximum length of a note of a LockToken.
+// Longer notes are truncated.
+const maxNoteLen = 128
+
+// A LockToken is a lock, see Lock, that the holder can attach a note to.
+//
+// The notes of locks that are still held are logged when the Preshutdown
+// stage times out, and written by DumpPending. This tells what the holders
+// are doing, for instance which request they are serving.
+type LockToken struct {
+	m *Manager
+
+	mu         sync.Mutex // Mutex for below
+	note       string
+	released   bool
+	handedOver bool // Released by ShutdownFromLocked, see Unlock.
+}
+
+// LockWithToken acquires a lock like Lock, and returns a token for it.
+//
+// If shutdown has already been initiated, nil and false is returned,
+// and you did not get a lock. Otherwise you must call Unlock on the
+// token once to release the lock.
+func LockWithToken() (*LockToken, bool) {
+	return defaultManager.LockWithToken()
+}
+
+// LockWithToken acquires a lock of the manager, and returns a token for it.
+func (m *Manager) LockWithToken() (*LockToken, bool) {
+	if m.isDisabled() {
+		// Not tracked, see Disable.
+		return &LockToken{}, true
+	}
+	m.waitQuiesce()
+	if !m.locks.tryLock() {
+		return nil, false
+	}
+	t := &LockToken{m: m}
+	m.tokM.Lock()
+	if m.tokens == nil {
+		m.tokens = make(map[*LockToken]struct{})
+	}
+	m.tokens[t] = struct{}{}
+	m.tokM.Unlock()
+	return t, true
+}
+
+// SetNote sets the note of the lock, replacing the previous one.
+// It can be called as often as needed, for instance when the holder
+// moves to another phase of its work. Notes longer than 128 bytes are truncated.
+func (t *LockToken) SetNote(note string) {
+	if len(note) > maxNoteLen {
+		// Don't cut a rune in half.
+		n := maxNoteLen
+		for n > 0 && !utf8.RuneStart(note[n]) {
+			n--
+		}
+		note = note[:n]
+	}
+	t.mu.Lock()
+	t.note = note
+	t.mu.Unlock()
+}
+
+// Note returns the note of the lock.
+func (t *LockToken) Note() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.note
+}
+
+// Unlock releases the lock and clears the note.
+// It panics if the lock has already been released.
+// If the lock was released by ShutdownFromLocked, the first call does nothing.
+func (t *LockToken) Unlock() {
+	t.mu.Lock()
+	if t.handedOver {
+		t.handedOver = false
+		t.mu.Unlock()
+		return
+	}
+	if t.released {
+		t.mu.Unlock()
+		panic("shutdown: LockToken unlocked twice")
+	}
+	t.mu.Unlock()
+	t.release(false)
+}
+
+// release releases the lock, unless it has already been released.
+// It returns false if it had. If handOver is true, the next Unlock does nothing.
+func (t *LockToken) release(handOver bool) bool {
+	t.mu.Lock()
+	if t.released {
+		t.mu.Unlock()
+		return false
+	}
+	t.released = true
+	t.handedOver = handOver
+	t.note = ""
+	t.mu.Unlock()
+	if t.m == nil {
+		return true
+	}
+	t.m.tokM.Lock()
+	delete(t.m.tokens, t)
+	t.m.tokM.Unlock()
+	t.m.locks.unlock()
+	return true
+}
+
+// ShutdownFromLocked starts shutdown like Shutdown, from a goroutine
+// that holds the lock of token, see LockWithToken.
+//
+// Shutdown waits for all locks to be released, so calling Shutdown while
+// holding a lock makes it wait for the Preshutdown timeout, since the lock
+// can't be released before Shutdown returns. ShutdownFromLocked releases
+// the lock of the token first, and logs it. The holder may still call
+// Unlock on the token once, for instance in a deferred call, which then
+// does nothing.
+func ShutdownFromLocked(token *LockToken) {
+	defaultManager.ShutdownFromLocked(token)
+}
+
+// ShutdownFromLocked starts shutdown of the manager from a goroutine that holds the lock of token.
+func (m *Manager) ShutdownFromLocked(token *LockToken) {
+	if token.m == m && token.release(true) {
+		Logger.Println("Shutdown called while holding a lock, releasing it")
+	}
+	m.shutdown(Reason{Cause: "Shutdown called"})
+}
+
+// lockNotes returns the notes of the lock tokens that are held,
+// sorted and quoted. Tokens without a note are not included.
+func (m *Manager) lockNotes() []string {
+	m.tokM.Lock()
+	tokens := make([]*LockToken, 0, len(m.tokens))
+	for t := range m.tokens {
+		tokens = append(tokens, t)
+	}
+	m.tokM.Unlock()
+	var notes []string
+	for _, t := range tokens {
+		if note := t.Note(); note != "" {
+			notes = append(notes, strconv.Quote(note))
+		}
+	}
+	sort.Strings(notes)
+	return notes
+}
+
+// logLocks logs the locks that are still held, and their notes.
+func (m *Manager) logLocks() {
+	held := m.locks.held()
+	if held == 0 {
+		return
+	}
+	notes := m.lockNotes()
+	if len(notes) == 0 {
+		Logger.Printf("Lock: %d locks still held", held)
+		return
+	}
+	Logger.Printf("Lock: %d locks still held, notes: %s", held, strings.Join(notes, ", "))
+}
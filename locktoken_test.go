@@ -0,0 +1,107 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestLockToken(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeout(100 * time.Millisecond)
+	a, ok := LockWithToken()
+	if !ok {
+		t.Fatal("unable to lock")
+	}
+	b, _ := LockWithToken()
+	a.SetNote("req 1, phase=parse")
+	a.SetNote("req 1, phase=db")
+	b.SetNote("req 2, phase=write")
+	c, _ := LockWithToken()
+	c.Unlock()
+	if c.Note() != "" {
+		t.Fatal("note not cleared on unlock")
+	}
+	expectPanic(t, "shutdown: LockToken unlocked twice", c.Unlock)
+
+	var buf bytes.Buffer
+	if err := DumpPending(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Locks: 2 held\n\t\"req 1, phase=db\"\n\t\"req 2, phase=write\"\n") {
+		t.Fatal("unexpected output", buf.String())
+	}
+
+	lines, restore := logLines()
+	defer restore()
+	Shutdown()
+	l := nextLine(t, lines, "Lock: 2 locks still held")
+	if !strings.Contains(l, `notes: "req 1, phase=db", "req 2, phase=write"`) {
+		t.Fatal("unexpected log line", l)
+	}
+	if _, ok := LockWithToken(); ok {
+		t.Fatal("locked after shutdown")
+	}
+	a.Unlock()
+	b.Unlock()
+}
+
+func TestLockTokenNoteLength(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	l, _ := LockWithToken()
+	defer l.Unlock()
+	l.SetNote(strings.Repeat("x", 1000))
+	if n := len(l.Note()); n != maxNoteLen {
+		t.Fatal("note not truncated", n)
+	}
+	// Runes are not cut in half.
+	l.SetNote("x" + strings.Repeat("æ", 100))
+	if n := len(l.Note()); n != maxNoteLen-1 {
+		t.Fatal("unexpected note length", n)
+	}
+	if !utf8.ValidString(l.Note()) {
+		t.Fatal("truncated note is not valid UTF-8")
+	}
+}
+
+func TestShutdownFromLocked(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	const timeout = 200 * time.Millisecond
+	SetTimeoutN(Preshutdown, timeout)
+
+	// Shutdown waits for a lock held by the caller until the timeout.
+	if !Lock() {
+		t.Fatal("unable to lock")
+	}
+	start := time.Now()
+	Shutdown()
+	if d := time.Since(start); d < timeout {
+		t.Fatalf("shutdown took %v, expected it to wait for the timeout", d)
+	}
+	Unlock()
+
+	reset()
+	SetTimeoutN(Preshutdown, timeout)
+	token, ok := LockWithToken()
+	if !ok {
+		t.Fatal("unable to lock")
+	}
+	lines, restore := logLines()
+	defer restore()
+	start = time.Now()
+	ShutdownFromLocked(token)
+	if d := time.Since(start); d >= timeout {
+		t.Fatalf("shutdown took %v, expected it not to wait for the lock", d)
+	}
+	nextLine(t, lines, "Shutdown called while holding a lock, releasing it")
+	// The holder may still unlock once.
+	token.Unlock()
+	expectPanic(t, "shutdown: LockToken unlocked twice", token.Unlock)
+}
@@ -0,0 +1,493 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Manager is an independent shutdown manager.
+//
+// Each manager has its own notifiers, timeouts and locks.
+// The package level functions all operate on a default manager,
+// so you only need to create a Manager if you need a shutdown
+// sequence that is separate from the one of the application.
+type Manager struct {
+	locks      lockCounter             // Must be first for alignment.
+	stageLocks [numStages]*lockCounter // Locks held for a specific stage, see LockStage.
+	release    func()                  // Releases a lock, returned by BeginWork.
+
+	sqM             sync.Mutex // Mutex for below
+	shutdownQueue   [numStages][]Notifier
+	shutdownFnQueue [numStages][]fnNotify
+	semaphores      []*Semaphore
+	pools           []*ContextPool // Cancelled as stages start, see NewContextPool.
+	disabled        int32          // Accessed atomically. Set while holding sqM, see Disable.
+
+	tokM   sync.Mutex              // Mutex for below
+	tokens map[*LockToken]struct{} // Held lock tokens, see LockWithToken.
+
+	quM            sync.Mutex    // Mutex for below
+	quiesced       chan struct{} // Closed by Unquiesce, nil if not quiesced, see Quiesce.
+	quiesceTimeout time.Duration // See SetQuiesceTimeout.
+	quiescing      int32         // Accessed atomically. 1 while quiesced, set while holding quM.
+
+	lockWaiters int32 // Accessed atomically, see LockWaiters.
+
+	closed    chan struct{} // Closed by Close.
+	closeOnce sync.Once
+	watchers  sync.WaitGroup // Goroutines Close waits for, see addWatcher.
+
+	crumbM   sync.Mutex // Mutex for below
+	crumbs   *os.File   // Breadcrumb file, see SetCrashBreadcrumbs.
+	crumbSeq uint64
+
+	completed []uint64 // Notifiers completed by the last shutdown, guarded by awM, see AwaitCompletion.
+
+	streamM sync.Mutex        // Mutex for below
+	streams []*progressStream // See StreamProgress.
+
+	msM        sync.Mutex            // Mutex for below
+	milestones map[string]*Milestone // See NamedMilestone.
+
+	srM               sync.RWMutex // Mutex for below
+	shutdownRequested bool
+	done              chan struct{}    // Closed when shutdown has completed.
+	signals           []chan os.Signal // Channels of OnSignal, stopped by Close.
+	reason            Reason
+	coalesced         int
+	cycles            uint64 // Shutdowns started, see Monotonic.
+	debounce          time.Duration
+	timeout           time.Duration                  // Timeout of stages without their own, see SetTimeout.
+	timeouts          [numStages]time.Duration       // Timeouts set by SetTimeoutN, 0 if not set.
+	minDurations      [numStages]time.Duration       // Set by SetStageMinDuration.
+	predicates        [numStages]func(r Reason) bool // Set by SetStagePredicate.
+	lifo              [numStages]bool                // Set by SetStageLIFO.
+	parallel          [numStages]int                 // Group of each stage, 0 if none, see SetParallelStages.
+	startedMono       time.Duration                  // Monotonic time shutdown was started, see clock.
+	stageDeadline     [numStages]time.Duration       // Monotonic time each stage times out.
+	stageDone         [numStages]chan struct{}       // Closed when each stage has completed.
+	progress          [numStages]stageProgress       // See StageStatuses.
+	order             [numStages]int                 // Order the stages are run in, see RemapStages.
+	current           int                            // Position in order of the running stage, -1 before shutdown.
+	drainExtended     time.Duration
+	maxDrainExtension time.Duration
+	hardTimeout       time.Duration // See SetHardTimeout.
+	criticalCeiling   time.Duration
+	clock             Clock
+	onStageTimeout    func(stage int)
+	stageTimeoutFns   [numStages]func(pending []string) // Set by OnStageTimeout.
+	allDrainedFns     []*func()                         // Set by OnAllDrained.
+	domains           []*Domain
+	parallelDomains   bool
+	lastWish          *func() // Set by SetLastWish.
+	exitFn            func(code int)
+	exitCode          int    // Code given to Exit or OnSignal.
+	exiting           bool   // Set by exit, see PlannedExitCode.
+	exitCycle         uint64 // Shutdown the exit function was last called for, see exit.
+	failureExitCode   int
+	exitFlushDelay    time.Duration
+	exitHooks         []*func(ctx context.Context) error // Set by OnBeforeExit.
+	exitHookTimeout   time.Duration
+	chaos             ChaosConfig
+	panics            int
+	interceptor       func(info NotifierInfo, invoke func())     // Set by SetCallbackInterceptor.
+	middleware        []func(stage int, n Notifier, next func()) // Added by Use.
+	callbacks         []CallbackSummary                          // Functions given to the interceptor.
+	channels          []*channelDrain                            // See DrainChannel.
+	last              Summary
+	history           []Summary // Oldest first, see History.
+	historySize       int
+	trace             *shutdownTrace // The last completed shutdown, see WriteTrace.
+	profile           io.Writer
+	record            io.Writer               // See SetRecordShutdown.
+	identities        map[string]callIdentity // Only used by the shutdown goroutine, see recordIdentities.
+	logOnComplete     bool
+	timeoutsDisabled  bool
+	timM              sync.Mutex     // Guards timings while stages are waited for, see SetParallelStages.
+	timings           []callbackTime // Only used by the shutdown goroutine.
+	rateLimit         int
+	notifierBuffer    int                 // See SetNotifierBufferSize.
+	waiting           [numStages][]string // Notifiers each running stage is waiting for, see DumpPending.
+	running           map[Notifier]uint64 // Goroutines of running functions, see DumpPending.
+	nextSignal        time.Duration       // Monotonic time the next notifier may be signalled, see throttle.
+
+	lastWishOnce  sync.Once
+	exitHooksOnce sync.Once
+}
+
+// An Option configures a Manager.
+type Option func(*Manager)
+
+// NewManager returns a new Manager with default timeouts.
+// The options are applied in order.
+func NewManager(opts ...Option) *Manager {
+	m := &Manager{
+		timeout:           5 * time.Second,
+		maxDrainExtension: 30 * time.Second,
+		quiesceTimeout:    defaultQuiesceTimeout,
+		clock:             realClock{},
+		exitFn:            os.Exit,
+		exitFlushDelay:    defaultExitFlushDelay,
+		exitHookTimeout:   defaultExitHookTimeout,
+		criticalCeiling:   defaultCriticalCeiling,
+		historySize:       defaultHistorySize,
+		order:             stageOrder,
+		current:           -1,
+		timeoutsDisabled:  timeoutsDisabledByEnv(),
+		done:              make(chan struct{}),
+		closed:            make(chan struct{}),
+	}
+	m.locks.drained = make(chan struct{})
+	m.stageLocks[0] = &m.locks
+	for i := 1; i < numStages; i++ {
+		m.stageLocks[i] = &lockCounter{drained: make(chan struct{})}
+	}
+	for i := range m.stageDone {
+		m.stageDone[i] = make(chan struct{})
+	}
+	m.release = m.locks.unlock
+	m.Configure(opts...)
+	return m
+}
+
+// Configure applies options to the default manager.
+func Configure(opts ...Option) {
+	defaultManager.Configure(opts...)
+}
+
+// Configure applies options to the manager.
+func (m *Manager) Configure(opts ...Option) {
+	m.srM.Lock()
+	defer m.srM.Unlock()
+	for _, opt := range opts {
+		opt(m)
+	}
+}
+
+// Reset makes the default manager ready for another shutdown.
+// See Manager.Reset.
+func Reset() {
+	defaultManager.Reset()
+}
+
+// Reset makes the manager ready for another shutdown.
+//
+// If a shutdown is running, it waits for it to finish.
+// The configuration, like timeouts and options, is kept,
+// and so is the summary returned by LastSummary.
+// Notifiers that were not signalled are kept as well.
+// Reset must not be called while locks are held,
+// or concurrently with other functions of the manager.
+// It is mainly intended for tests that run several shutdowns.
+func (m *Manager) Reset() {
+	m.srM.RLock()
+	started, done, domains := m.shutdownRequested, m.done, m.domains
+	m.srM.RUnlock()
+	if started {
+		<-done
+	}
+	for _, d := range domains {
+		d.Reset()
+	}
+
+	m.srM.Lock()
+	m.shutdownRequested = false
+	m.done = make(chan struct{})
+	m.reason = Reason{}
+	m.coalesced = 0
+	m.panics = 0
+	m.callbacks = nil
+	m.channels = nil
+	m.startedMono = 0
+	m.stageDeadline = [numStages]time.Duration{}
+	m.progress = [numStages]stageProgress{}
+	for i := range m.stageDone {
+		m.stageDone[i] = make(chan struct{})
+	}
+	m.order = stageOrder
+	m.current = -1
+	m.drainExtended = 0
+	m.exitCode = 0
+	m.exiting = false
+	m.nextSignal = 0
+	m.lastWishOnce = sync.Once{}
+	m.exitHooksOnce = sync.Once{}
+	m.srM.Unlock()
+
+	atomic.StoreInt64(&m.locks.v, 0)
+	m.locks.drained = make(chan struct{})
+	for i := 1; i < numStages; i++ {
+		m.stageLocks[i] = &lockCounter{drained: make(chan struct{})}
+	}
+	for _, s := range m.semaphores {
+		s.reset()
+	}
+}
+
+// ResetRegistrations makes the default manager ready for another shutdown,
+// and cancels all notifiers. See Manager.ResetRegistrations.
+func ResetRegistrations() {
+	defaultManager.ResetRegistrations()
+}
+
+// ResetRegistrations works like Reset, but also cancels the notifiers
+// and functions registered with the manager, like Cancel, and forgets
+// the outcomes of the last shutdown, see AwaitCompletion.
+//
+// The configuration, like timeouts, the exit function and options, is kept.
+// This is meant for table driven tests, where each test registers
+// its own notifiers, without configuring the manager again.
+func (m *Manager) ResetRegistrations() {
+	m.Reset()
+	m.cancelRegistrations()
+}
+
+// cancelRegistrations cancels the notifiers and functions registered with the manager.
+func (m *Manager) cancelRegistrations() {
+	m.sqM.Lock()
+	var registered []Notifier
+	for stage := range m.shutdownQueue {
+		for _, n := range m.shutdownQueue[stage] {
+			registered = append(registered, m.client(stage, n))
+		}
+	}
+	m.pools = nil
+	m.sqM.Unlock()
+	for _, n := range registered {
+		// Notifiers merged with other managers are only removed here.
+		if fn := m.cancel(n); fn != nil {
+			fn()
+		}
+	}
+	// The outcomes would otherwise be kept until the next shutdown.
+	m.forgetCompletions()
+}
+
+// WithGracefulDegradation sets a function that is called when a stage times out.
+//
+// Normally shutdown will just proceed to the next stage when a stage times out.
+// The function is called synchronously with the stage that timed out,
+// before the next stage is started, so it can for instance force close
+// connections that the next stage relies on being closed.
+// If the function panics the panic is logged and shutdown proceeds.
+func WithGracefulDegradation(fn func(stage int)) Option {
+	return func(m *Manager) {
+		m.onStageTimeout = fn
+	}
+}
+
+// Merge returns a new Manager that holds the notifiers of both m and other.
+//
+// When Shutdown is called on the returned manager all notifiers of
+// both managers are signalled in stage order. The timeouts of m are used.
+//
+// The source managers are not modified, and calling Shutdown on one of them
+// will not start shutdown of the merged manager. A notifier is only
+// signalled once, so notifiers that have already been signalled by
+// one of the managers are skipped by the others.
+// Cancelling a notifier removes it from all managers holding it.
+func (m *Manager) Merge(other *Manager) *Manager {
+	merged := NewManager()
+	m.srM.RLock()
+	merged.timeout = m.timeout
+	merged.timeouts = m.timeouts
+	m.srM.RUnlock()
+
+	for _, src := range []*Manager{m, other} {
+		src.sqM.Lock()
+		for stage := range src.shutdownQueue {
+			for _, n := range src.shutdownQueue[stage] {
+				merged.shutdownQueue[stage] = append(merged.shutdownQueue[stage], n)
+				register(n, merged, stage)
+			}
+			for _, fn := range src.shutdownFnQueue[stage] {
+				merged.shutdownFnQueue[stage] = append(merged.shutdownFnQueue[stage], fn)
+				register(fn.client, merged, stage)
+			}
+		}
+		src.sqM.Unlock()
+	}
+	return merged
+}
+
+// WithParallelDomains sets whether the domains of the manager are shut
+// down in parallel or one at the time in the order they were created.
+// The default is to shut them down in sequence.
+func WithParallelDomains(parallel bool) Option {
+	return func(m *Manager) {
+		m.parallelDomains = parallel
+	}
+}
+
+// notifierState contains the state of a notifier,
+// which can be shared between managers.
+type notifierState struct {
+	owners    []*Manager
+	fired     bool
+	cancelled bool
+	stage     int
+	id        uint64
+	expired   chan struct{} // Closed when the stage no longer waits, see Notifier.Expired.
+	callSite  string        // Where the notifier was created, see SetDebugMode.
+	value     *fnValue      // Value of a function notifier, see Notifier.SetValue.
+	critical  bool          // See Notifier.WithNoHardTimeout.
+	onCancel  func()        // See Notifier.OnCancel.
+	delay     time.Duration // See Notifier.WithArtificialDelay.
+	timeout   time.Duration // See FirstFuncTimeout.
+	waitFor   []*Milestone  // See Notifier.WaitFor.
+	internal  bool          // Waits for locks, and is not intercepted, see SetCallbackInterceptor.
+}
+
+var nM sync.Mutex // Mutex for below
+var notifiers = make(map[Notifier]*notifierState)
+var notifierIDs = make(map[uint64]Notifier)
+var lastID uint64
+
+// register adds m as an owner of n in the given stage.
+// If n is new, it is given an id and its call site is recorded.
+func register(n Notifier, m *Manager, stage int) {
+	site := callSite()
+	nM.Lock()
+	ns := notifiers[n]
+	if ns == nil {
+		lastID++
+		ns = &notifierState{stage: stage, id: lastID, callSite: site, expired: make(chan struct{})}
+		notifierIDs[lastID] = n
+		notifiers[n] = ns
+	}
+	ns.owners = append(ns.owners, m)
+	nM.Unlock()
+}
+
+// release removes m as an owner of n.
+// When n has no owners left it is forgotten.
+func release(n Notifier, m *Manager) {
+	nM.Lock()
+	defer nM.Unlock()
+	ns := notifiers[n]
+	if ns == nil {
+		return
+	}
+	ns.removeOwner(m)
+	// A notifier with an OnCancel function is kept until it has been cancelled.
+	if len(ns.owners) == 0 && (ns.onCancel == nil || ns.fired || ns.cancelled) {
+		delete(notifiers, n)
+		delete(notifierIDs, ns.id)
+	}
+}
+
+// releaseCancelled removes m as an owner of n, which has been cancelled.
+// When n has no owners left it is closed, unless it has already been
+// signalled, and forgotten. The OnCancel function of n is returned
+// if it was closed, so it can be called without holding locks.
+func releaseCancelled(n Notifier, m *Manager) (onCancel func()) {
+	nM.Lock()
+	defer nM.Unlock()
+	ns := notifiers[n]
+	if ns == nil {
+		return nil
+	}
+	ns.removeOwner(m)
+	if len(ns.owners) > 0 {
+		return nil
+	}
+	onCancel = ns.cancel(n)
+	delete(notifiers, n)
+	delete(notifierIDs, ns.id)
+	return onCancel
+}
+
+// removeOwner removes m from the owners. nM must be held.
+func (ns *notifierState) removeOwner(m *Manager) {
+	for i, o := range ns.owners {
+		if o == m {
+			ns.owners = append(ns.owners[:i], ns.owners[i+1:]...)
+			return
+		}
+	}
+}
+
+// ownersOf returns the managers n is registered with.
+func ownersOf(n Notifier) []*Manager {
+	nM.Lock()
+	defer nM.Unlock()
+	ns := notifiers[n]
+	if ns == nil {
+		return nil
+	}
+	return append([]*Manager(nil), ns.owners...)
+}
+
+// fire marks n as signalled.
+// It returns false if n has already been signalled.
+func fire(n Notifier) bool {
+	nM.Lock()
+	defer nM.Unlock()
+	ns := notifiers[n]
+	if ns == nil || ns.fired || ns.cancelled {
+		return false
+	}
+	ns.fired = true
+	return true
+}
+
+// expire closes the expired channel of n.
+func expire(n Notifier) {
+	nM.Lock()
+	defer nM.Unlock()
+	if ns := notifiers[n]; ns != nil {
+		ns.expire()
+	}
+}
+
+// expire closes the expired channel, unless it is already closed.
+// nM must be held.
+func (ns *notifierState) expire() {
+	select {
+	case <-ns.expired:
+	default:
+		close(ns.expired)
+	}
+}
+
+// closeCancelled marks n as cancelled and closes it,
+// unless it has already been signalled or cancelled, or isn't registered.
+// The OnCancel function of n is returned, if it was cancelled,
+// so it can be called without holding nM.
+func closeCancelled(n Notifier) (onCancel func()) {
+	nM.Lock()
+	defer nM.Unlock()
+	ns := notifiers[n]
+	if ns == nil {
+		return nil
+	}
+	return ns.cancel(n)
+}
+
+// cancel marks n, which ns is the state of, as cancelled and closes it,
+// unless it has already been signalled or cancelled.
+// The OnCancel function is returned, if it was cancelled.
+// nM must be held.
+func (ns *notifierState) cancel(n Notifier) (onCancel func()) {
+	if ns.fired || ns.cancelled {
+		return nil
+	}
+	ns.cancelled = true
+	ns.expire()
+	// Wake those waiting, but don't keep the outcome, see AwaitCompletion.
+	awM.Lock()
+	if c := completions[ns.id]; c != nil {
+		resolve(n, ns.id, ErrNotifierCancelled)
+		delete(completions, ns.id)
+	}
+	awM.Unlock()
+	close(n)
+	onCancel, ns.onCancel = ns.onCancel, nil
+	return onCancel
+}
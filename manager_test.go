@@ -0,0 +1,247 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"testing"
+	"time"
+)
+
+func TestManagerIndependent(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	m := NewManager()
+	m.SetTimeout(time.Second)
+
+	var gotDefault, gotManager bool
+	_ = FirstFunc(setBool, &gotDefault)
+	_ = m.FirstFunc(setBool, &gotManager)
+
+	m.Shutdown()
+	if !m.Started() {
+		t.Fatal("manager shutdown not marked started")
+	}
+	if Started() {
+		t.Fatal("default manager was started by another manager")
+	}
+	if gotDefault || !gotManager {
+		t.Fatal("unexpected shutdown signals", gotDefault, gotManager)
+	}
+	if !Lock() {
+		t.Fatal("unable to lock default manager")
+	}
+	Unlock()
+}
+
+func TestMerge(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	a := NewManager()
+	b := NewManager()
+	a.SetTimeout(time.Second)
+
+	var order []int
+	b.ThirdFunc(func(interface{}) { order = append(order, 3) }, nil)
+	a.FirstFunc(func(interface{}) { order = append(order, 1) }, nil)
+	n2 := b.Second()
+	go func() {
+		n := <-n2
+		order = append(order, 2)
+		close(n)
+	}()
+
+	merged := a.Merge(b)
+	merged.Shutdown()
+	if len(order) != 3 || order[0] != 1 || order[1] != 2 || order[2] != 3 {
+		t.Fatal("unexpected shutdown order", order)
+	}
+	if !merged.Started() {
+		t.Fatal("merged manager not marked started")
+	}
+	if a.Started() || b.Started() {
+		t.Fatal("source manager was started by merged manager")
+	}
+	// Notifiers have already been signalled, so this must not wait.
+	tn := time.Now()
+	a.Shutdown()
+	if dur := time.Since(tn); dur > 500*time.Millisecond {
+		t.Fatal("shutdown of source manager waited", dur)
+	}
+	if len(order) != 3 {
+		t.Fatal("notifiers were signalled more than once", order)
+	}
+}
+
+func TestMergeSourceShutdown(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	a := NewManager()
+	b := NewManager()
+	a.SetTimeout(time.Second)
+	var gotA, gotB bool
+	a.FirstFunc(setBool, &gotA)
+	b.FirstFunc(setBool, &gotB)
+
+	merged := a.Merge(b)
+	a.Shutdown()
+	if merged.Started() {
+		t.Fatal("shutdown of source manager started merged manager")
+	}
+	if !gotA || gotB {
+		t.Fatal("unexpected shutdown signals", gotA, gotB)
+	}
+	merged.Shutdown()
+	if !gotB {
+		t.Fatal("merged manager did not signal remaining notifier")
+	}
+}
+
+func TestMergeCancel(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	a := NewManager()
+	b := NewManager()
+	var got bool
+	n := a.FirstFunc(setBool, &got)
+
+	merged := a.Merge(b)
+	n.Cancel()
+	merged.Shutdown()
+	a.Shutdown()
+	if got {
+		t.Fatal("cancelled notifier was signalled")
+	}
+}
+
+func TestGracefulDegradation(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var stages []int
+	var second bool
+	m := NewManager(WithGracefulDegradation(func(stage int) {
+		if second {
+			t.Error("degradation function called after next stage started")
+		}
+		stages = append(stages, stage)
+	}))
+	m.SetTimeout(time.Millisecond * 100)
+
+	f := m.First()
+	go func() {
+		<-f
+		// Never finish
+	}()
+	m.SecondFunc(setBool, &second)
+	m.ThirdFunc(func(interface{}) {}, nil)
+	m.Shutdown()
+	if len(stages) != 1 || stages[0] != 1 {
+		t.Fatal("unexpected degradation calls", stages)
+	}
+	if !second {
+		t.Fatal("shutdown did not proceed after degradation")
+	}
+}
+
+func TestGracefulDegradationPanic(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var third bool
+	Configure(WithGracefulDegradation(func(stage int) {
+		panic("This is expected")
+	}))
+	SetTimeout(time.Millisecond * 100)
+	f := First()
+	go func() {
+		<-f
+	}()
+	ThirdFunc(setBool, &third)
+	Shutdown()
+	if !third {
+		t.Fatal("shutdown did not proceed after degradation panic")
+	}
+}
+
+func TestReset(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var first, second bool
+	_ = FirstFunc(setBool, &first)
+	if !Lock() {
+		t.Fatal("unable to lock")
+	}
+	Unlock()
+	Shutdown()
+	if !first {
+		t.Fatal("first function not called")
+	}
+	before := LastSummary()
+
+	Reset()
+	if Started() {
+		t.Fatal("started after reset")
+	}
+	if s := LastSummary(); s.Reason != before.Reason || len(s.Stages) != len(before.Stages) {
+		t.Fatal("summary changed by reset", s, before)
+	}
+	if !Lock() {
+		t.Fatal("unable to lock after reset")
+	}
+	Unlock()
+
+	_ = SecondFunc(setBool, &second)
+	Shutdown()
+	if !second {
+		t.Fatal("second shutdown did not signal notifier")
+	}
+	if s := LastSummary(); len(s.Stages) != 2 || s.Stages[1].Stage != 2 {
+		t.Fatal("summary not replaced by second shutdown", s)
+	}
+}
+
+func TestResetRegistrations(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeoutN(Stage2, 123*time.Millisecond)
+	n := First()
+	called := false
+	FirstFunc(func(interface{}) { called = true }, nil)
+	SecondFunc(func(interface{}) { called = true }, nil)
+	if RegistrationCount(Stage1) != 2 {
+		t.Fatal("expected two registrations")
+	}
+
+	ResetRegistrations()
+	for _, s := range []Stage{Preshutdown, Stage1, Stage2, Stage3, ReadOnlyStage} {
+		if c := RegistrationCount(s); c != 0 {
+			t.Fatalf("stage %d: %d registrations after reset", s.n, c)
+		}
+	}
+	if !n.Cancelled() {
+		t.Fatal("notifier not cancelled")
+	}
+	if d := EffectiveTimeout(Stage2); d != 123*time.Millisecond {
+		t.Fatal("timeout not kept, got", d)
+	}
+	Shutdown()
+	if called {
+		t.Fatal("function called after reset")
+	}
+}
+
+func TestMonotonic(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	if n := Monotonic(); n != 0 {
+		t.Fatal("counter not 0 before shutdown", n)
+	}
+	Shutdown()
+	Shutdown()
+	if n := Monotonic(); n != 1 {
+		t.Fatal("waiting for the running shutdown was counted", n)
+	}
+	Reset()
+	<-ShutdownAsync()
+	if n := Monotonic(); n != 2 {
+		t.Fatal("unexpected count after reset", n)
+	}
+}
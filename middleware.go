@@ -0,0 +1,47 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+// Use adds an interceptor that is called when each notifier is signalled
+// during shutdown. See Manager.Use.
+func Use(interceptor func(stage int, n Notifier, next func())) {
+	defaultManager.Use(interceptor)
+}
+
+// Use adds an interceptor that is called when each notifier of the manager
+// is signalled during shutdown, and returns the manager, so calls can be chained.
+//
+// The interceptor is given the stage, the notifier, and a function that
+// continues signalling, by calling the next interceptor, or signalling
+// the notifier after the last one. This allows logging or tracing
+// before and after each notifier is signalled. For functions, like
+// FirstFunc, n is the notifier returned when registering.
+// Interceptors are called in the order they were added, so the first
+// one added is the outermost.
+//
+// If next is not called, the notifier is not signalled, and the stage
+// waits for it until it times out. Interceptors are called by the
+// shutdown sequence, so they must not register notifiers or block.
+func (m *Manager) Use(interceptor func(stage int, n Notifier, next func())) *Manager {
+	if interceptor == nil {
+		panic("shutdown: nil interceptor")
+	}
+	m.srM.Lock()
+	m.middleware = append(m.middleware, interceptor)
+	m.srM.Unlock()
+	return m
+}
+
+// signalThrough calls signal through the interceptors added by Use.
+// n is the notifier given to the interceptors.
+func (m *Manager) signalThrough(stage int, n Notifier, signal func()) {
+	m.srM.RLock()
+	mw := m.middleware
+	m.srM.RUnlock()
+	next := signal
+	for i := len(mw) - 1; i >= 0; i-- {
+		fn, inner := mw[i], next
+		next = func() { fn(stage, n, inner) }
+	}
+	next()
+}
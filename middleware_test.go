@@ -0,0 +1,56 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUse(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var events []string
+	trace := func(name string) func(stage int, n Notifier, next func()) {
+		return func(stage int, n Notifier, next func()) {
+			events = append(events, fmt.Sprintf("%s before %d:%d", name, stage, n.ID()))
+			next()
+			events = append(events, fmt.Sprintf("%s after %d:%d", name, stage, n.ID()))
+		}
+	}
+	m := NewManager()
+	if got := m.Use(trace("a")).Use(trace("b")); got != m {
+		t.Fatal("Use did not return the manager")
+	}
+	called := false
+	fn := m.SecondFunc(func(interface{}) { called = true }, nil)
+	id := fn.ID()
+	// Not signalled, so the stage times out.
+	m.SetTimeoutN(Stage3, 50*time.Millisecond)
+	m.Third()
+	m.Use(func(stage int, n Notifier, next func()) {
+		if stage != Stage3.n {
+			next()
+		}
+	})
+	m.Shutdown()
+	if !called {
+		t.Fatal("function was not called")
+	}
+	want := []string{
+		fmt.Sprintf("a before %d:%d", Stage2.n, id),
+		fmt.Sprintf("b before %d:%d", Stage2.n, id),
+		fmt.Sprintf("b after %d:%d", Stage2.n, id),
+		fmt.Sprintf("a after %d:%d", Stage2.n, id),
+	}
+	// The lock drain and the third stage are traced too.
+	if len(events) != 12 || !reflect.DeepEqual(events[4:8], want) {
+		t.Fatalf("unexpected events %q", events)
+	}
+	if s := m.LastSummary(); len(s.Stages) != 3 || !s.Stages[2].TimedOut {
+		t.Fatalf("expected third stage to time out, got %+v", s.Stages)
+	}
+	expectPanic(t, "shutdown: nil interceptor", func() { m.Use(nil) })
+}
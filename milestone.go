@@ -0,0 +1,161 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// A Milestone orders notifiers, regardless of the stage they are in.
+//
+// A notifier that waits for a milestone, see Notifier.WaitFor, is not
+// signalled until the milestone has been reached, see Signal. This lets
+// code in different packages order its shutdown, for instance closing
+// a cache after a database, without knowing the stages of the other.
+// A Barrier is different: it lets functions in a stage wait for each other.
+type Milestone struct {
+	name    string
+	once    sync.Once
+	reached chan struct{} // Closed by Signal.
+}
+
+// NamedMilestone returns the milestone with the given name.
+//
+// The milestone is created when it is first used, so it can be
+// referenced before the code signalling it has registered.
+// The same milestone is returned every time for a name.
+func NamedMilestone(name string) *Milestone {
+	return defaultManager.NamedMilestone(name)
+}
+
+// NamedMilestone returns the milestone of the manager with the given name.
+func (m *Manager) NamedMilestone(name string) *Milestone {
+	m.msM.Lock()
+	defer m.msM.Unlock()
+	if ms, ok := m.milestones[name]; ok {
+		return ms
+	}
+	if m.milestones == nil {
+		m.milestones = make(map[string]*Milestone)
+	}
+	ms := &Milestone{name: name, reached: make(chan struct{})}
+	m.milestones[name] = ms
+	return ms
+}
+
+// Name returns the name of the milestone.
+func (ms *Milestone) Name() string {
+	return ms.name
+}
+
+// Signal marks the milestone as reached, and signals the notifiers
+// waiting for it. It is typically called by a shutdown function when its
+// work is done. It can be called before shutdown, and more than once.
+// A milestone stays reached, also after Reset.
+func (ms *Milestone) Signal() {
+	ms.once.Do(func() { close(ms.reached) })
+}
+
+// Reached returns true if the milestone has been signalled.
+func (ms *Milestone) Reached() bool {
+	select {
+	case <-ms.reached:
+		return true
+	default:
+		return false
+	}
+}
+
+// WaitFor makes the notifier wait for the milestones to be reached
+// before it is signalled, see Milestone.
+// The notifier is returned, so it can be used when registering:
+//
+//	n := shutdown.Second().WaitFor(shutdown.NamedMilestone("db-closed"))
+//
+// The timeout of the stage of the notifier still applies. If the stage
+// stops waiting before the milestones are reached, for instance because
+// the code signalling them timed out or was cancelled, the notifier is
+// never signalled, and the milestones that were not reached are logged.
+// Waiting for a milestone that is signalled later in the same or an
+// earlier stage makes the stage time out.
+func (s Notifier) WaitFor(milestones ...*Milestone) Notifier {
+	for _, ms := range milestones {
+		if ms == nil {
+			panic("shutdown: nil milestone")
+		}
+	}
+	nM.Lock()
+	if ns := notifiers[s]; ns != nil {
+		ns.waitFor = append(ns.waitFor, milestones...)
+	}
+	nM.Unlock()
+	return s
+}
+
+// milestonesOf returns the milestones n, or the function notifier
+// it belongs to, waits for. m.sqM must be held.
+func (m *Manager) milestonesOf(stage int, n Notifier) []*Milestone {
+	n = m.client(stage, n)
+	nM.Lock()
+	defer nM.Unlock()
+	if ns := notifiers[n]; ns != nil {
+		return append([]*Milestone(nil), ns.waitFor...)
+	}
+	return nil
+}
+
+// awaitMilestones waits for the milestones to be reached.
+// If stop is closed first, the milestones that weren't reached
+// are logged, and false is returned.
+func awaitMilestones(stage int, label string, milestones []*Milestone, stop <-chan struct{}) bool {
+	for _, ms := range milestones {
+		select {
+		case <-ms.reached:
+			continue
+		case <-stop:
+		}
+		var missing []string
+		for _, ms := range milestones {
+			if !ms.Reached() {
+				missing = append(missing, fmt.Sprintf("%q", ms.name))
+			}
+		}
+		Logger.Printf("Stage %d: %s was not signalled, milestones not reached: %s", stage, label, strings.Join(missing, ", "))
+		return false
+	}
+	return true
+}
+
+// signalAfter signals n through signal when its milestones have been reached,
+// or stops the function of n if the stage stops waiting first.
+// m.sqM must be held.
+func (m *Manager) signalAfter(a *activeStage, label string, n Notifier, milestones []*Milestone, signal func()) {
+	if a.stopWaiters == nil {
+		a.stopWaiters = make(chan struct{})
+	}
+	var cancel chan struct{}
+	for _, fn := range m.shutdownFnQueue[a.stage] {
+		if fn.internal == n {
+			cancel = fn.cancel
+		}
+	}
+	go func(stop <-chan struct{}) {
+		if awaitMilestones(a.stage, label, milestones, stop) {
+			signal()
+			return
+		}
+		if cancel == nil {
+			return
+		}
+		// Stop the goroutine waiting to call the function.
+		m.sqM.Lock()
+		select {
+		case <-cancel:
+		default:
+			close(cancel)
+		}
+		m.sqM.Unlock()
+	}(a.stopWaiters)
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMilestoneCrossStage(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	if err := SetParallelStages([][]Stage{{Stage2, Stage3}}); err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var order []string
+	record := func(s string) {
+		mu.Lock()
+		order = append(order, s)
+		mu.Unlock()
+	}
+	// The waiter is registered before the milestone is signalled by anyone.
+	SecondFunc(func(interface{}) { record("cache") }, nil).WaitFor(NamedMilestone("db-closed"))
+	ThirdFunc(func(interface{}) {
+		time.Sleep(50 * time.Millisecond)
+		record("db")
+		NamedMilestone("db-closed").Signal()
+	}, nil)
+	Shutdown()
+	if len(order) != 2 || order[0] != "db" || order[1] != "cache" {
+		t.Fatal("unexpected order", order)
+	}
+	if LastSummary().TimedOut {
+		t.Fatal("timed out")
+	}
+}
+
+func TestMilestoneNeverSignalled(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeout(100 * time.Millisecond)
+	lines, restore := logLines()
+	defer restore()
+	called := make(chan struct{}, 1)
+	FirstFunc(func(interface{}) { called <- struct{}{} }, nil).WaitFor(NamedMilestone("db-closed"), NamedMilestone("other"))
+	NamedMilestone("other").Signal()
+	Shutdown()
+	nextLine(t, lines, `milestones not reached: "db-closed"`)
+	if !LastSummary().TimedOut {
+		t.Fatal("expected the stage to time out")
+	}
+	select {
+	case <-called:
+		t.Fatal("function called before the milestone was reached")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMilestoneSignalledBefore(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	ms := NamedMilestone("db-closed")
+	ms.Signal()
+	ms.Signal()
+	if !ms.Reached() || NamedMilestone("db-closed") != ms {
+		t.Fatal("milestone not kept")
+	}
+	n := First().WaitFor(ms)
+	go func() {
+		v := <-n
+		close(v)
+	}()
+	called := false
+	SetStageLIFO(Stage2, true)
+	SecondFunc(func(interface{}) { called = true }, nil).WaitFor(ms)
+	Shutdown()
+	if !called {
+		t.Fatal("function not called")
+	}
+	if LastSummary().TimedOut {
+		t.Fatal("timed out")
+	}
+}
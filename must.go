@@ -0,0 +1,111 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"fmt"
+)
+
+// The Must functions work like the functions without Must, but panic
+// if the registration cannot be honoured, because the stage has already
+// started, so the notifier would never be signalled.
+// This is useful when registration failing is a programming error,
+// for instance when registering in init().
+
+// MustPreShutdown works like PreShutdown, but panics if the stage has already started.
+func MustPreShutdown() Notifier {
+	return defaultManager.MustPreShutdown()
+}
+
+// MustPreShutdown works like PreShutdown of the manager, but panics if the stage has already started.
+func (m *Manager) MustPreShutdown() Notifier {
+	m.mustRegister(0)
+	return m.PreShutdown()
+}
+
+// MustPreShutdownFunc works like PreShutdownFunc, but panics if the stage has already started.
+func MustPreShutdownFunc(fn ShutdownFn, v interface{}) Notifier {
+	return defaultManager.MustPreShutdownFunc(fn, v)
+}
+
+// MustPreShutdownFunc works like PreShutdownFunc of the manager, but panics if the stage has already started.
+func (m *Manager) MustPreShutdownFunc(fn ShutdownFn, v interface{}) Notifier {
+	m.mustRegister(0)
+	return m.PreShutdownFunc(fn, v)
+}
+
+// MustFirst works like First, but panics if the stage has already started.
+func MustFirst() Notifier {
+	return defaultManager.MustFirst()
+}
+
+// MustFirst works like First of the manager, but panics if the stage has already started.
+func (m *Manager) MustFirst() Notifier {
+	m.mustRegister(1)
+	return m.First()
+}
+
+// MustFirstFunc works like FirstFunc, but panics if the stage has already started.
+func MustFirstFunc(fn ShutdownFn, v interface{}) Notifier {
+	return defaultManager.MustFirstFunc(fn, v)
+}
+
+// MustFirstFunc works like FirstFunc of the manager, but panics if the stage has already started.
+func (m *Manager) MustFirstFunc(fn ShutdownFn, v interface{}) Notifier {
+	m.mustRegister(1)
+	return m.FirstFunc(fn, v)
+}
+
+// MustSecond works like Second, but panics if the stage has already started.
+func MustSecond() Notifier {
+	return defaultManager.MustSecond()
+}
+
+// MustSecond works like Second of the manager, but panics if the stage has already started.
+func (m *Manager) MustSecond() Notifier {
+	m.mustRegister(2)
+	return m.Second()
+}
+
+// MustSecondFunc works like SecondFunc, but panics if the stage has already started.
+func MustSecondFunc(fn ShutdownFn, v interface{}) Notifier {
+	return defaultManager.MustSecondFunc(fn, v)
+}
+
+// MustSecondFunc works like SecondFunc of the manager, but panics if the stage has already started.
+func (m *Manager) MustSecondFunc(fn ShutdownFn, v interface{}) Notifier {
+	m.mustRegister(2)
+	return m.SecondFunc(fn, v)
+}
+
+// MustThird works like Third, but panics if the stage has already started.
+func MustThird() Notifier {
+	return defaultManager.MustThird()
+}
+
+// MustThird works like Third of the manager, but panics if the stage has already started.
+func (m *Manager) MustThird() Notifier {
+	m.mustRegister(3)
+	return m.Third()
+}
+
+// MustThirdFunc works like ThirdFunc, but panics if the stage has already started.
+func MustThirdFunc(fn ShutdownFn, v interface{}) Notifier {
+	return defaultManager.MustThirdFunc(fn, v)
+}
+
+// MustThirdFunc works like ThirdFunc of the manager, but panics if the stage has already started.
+func (m *Manager) MustThirdFunc(fn ShutdownFn, v interface{}) Notifier {
+	m.mustRegister(3)
+	return m.ThirdFunc(fn, v)
+}
+
+// mustRegister panics if the given stage has already started.
+func (m *Manager) mustRegister(stage int) {
+	m.srM.RLock()
+	started := m.current >= m.stagePos(stage)
+	m.srM.RUnlock()
+	if started {
+		panic(fmt.Sprintf("shutdown: cannot register in %s stage, it has already started", stageName(stage)))
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"testing"
+)
+
+func TestMust(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var ok0, ok1, ok2, ok3 bool
+	MustPreShutdownFunc(setBool, &ok0)
+	MustFirstFunc(setBool, &ok1)
+	MustSecondFunc(setBool, &ok2)
+	MustThirdFunc(setBool, &ok3)
+	for _, n := range []Notifier{MustPreShutdown(), MustFirst(), MustSecond(), MustThird()} {
+		go func(n Notifier) {
+			close(<-n)
+		}(n)
+	}
+	Shutdown()
+	if !ok0 || !ok1 || !ok2 || !ok3 {
+		t.Fatal("did not get expected shutdown signal", ok0, ok1, ok2, ok3)
+	}
+}
+
+func TestMustStarted(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var ok3 bool
+	FirstFunc(func(interface{}) {
+		expectPanic(t, "shutdown: cannot register in first stage, it has already started", func() {
+			MustFirstFunc(func(interface{}) {}, nil)
+		})
+		expectPanic(t, "shutdown: cannot register in preshutdown stage, it has already started", func() {
+			MustPreShutdown()
+		})
+		// Later stages can still be registered.
+		MustThirdFunc(setBool, &ok3)
+	}, nil)
+	Shutdown()
+	if !ok3 {
+		t.Fatal("function registered during shutdown was not called")
+	}
+	expectPanic(t, "shutdown: cannot register in third stage, it has already started", func() {
+		MustThird()
+	})
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"fmt"
+	"sync"
+)
+
+// SetParallelStages sets groups of stages that run concurrently.
+//
+// The notifiers of all stages in a group are signalled together, and the
+// group waits until each of its stages has finished or timed out, before
+// the next stage starts. For instance, {{Stage2, Stage3}} runs Stage1, then
+// Stage2 and Stage3 together, followed by the read-only stage. Each stage keeps
+// its own timeout, and its notifiers are signalled in their usual order.
+//
+// A group runs when the first of its stages would; the stages of a group
+// should be next to each other in the order, see RemapStages, otherwise
+// only the stages next to each other run concurrently. Groups with a single
+// stage have no effect. Use nil to run all stages in order, which is the default.
+// If a group contains the Preshutdown stage, which must finish first,
+// or a stage is in more than one group, an error is returned and the
+// groups are not changed.
+func SetParallelStages(groups [][]Stage) error {
+	return defaultManager.SetParallelStages(groups)
+}
+
+// SetParallelStages sets groups of stages of the manager that run concurrently.
+func (m *Manager) SetParallelStages(groups [][]Stage) error {
+	var parallel [numStages]int
+	for i, g := range groups {
+		for _, s := range g {
+			if s.n < 0 || s.n >= numStages {
+				return fmt.Errorf("shutdown: SetParallelStages: invalid stage %d", s.n)
+			}
+			if s.n == Preshutdown.n {
+				return fmt.Errorf("shutdown: SetParallelStages: %s stage can't run concurrently", stageName(s.n))
+			}
+			if parallel[s.n] != 0 {
+				return fmt.Errorf("shutdown: SetParallelStages: %s stage given more than once", stageName(s.n))
+			}
+			// Group 0 means not in a group.
+			parallel[s.n] = i + 1
+		}
+	}
+	m.srM.Lock()
+	m.parallel = parallel
+	m.srM.Unlock()
+	return nil
+}
+
+// groupLen returns the number of stages, starting at position pos
+// in the order, that run concurrently. It is at least 1.
+func (m *Manager) groupLen(pos int) int {
+	m.srM.RLock()
+	defer m.srM.RUnlock()
+	group := m.parallel[m.order[pos]]
+	n := 1
+	for group != 0 && pos+n < numStages && m.parallel[m.order[pos+n]] == group {
+		n++
+	}
+	return n
+}
+
+// waitStages waits for the stages, concurrently if there are more than one.
+func (m *Manager) waitStages(active []*activeStage) {
+	wait := func(a *activeStage) {
+		a.start = m.clock.Mono()
+		a.pending, a.ok = m.waitStage(a.stage, a.labels, a.clients, a.wait, a.critical, a.delays, a.timeouts)
+		a.end = m.clock.Mono()
+	}
+	if len(active) == 1 {
+		wait(active[0])
+		return
+	}
+	var wg sync.WaitGroup
+	wg.Add(len(active))
+	for _, a := range active {
+		go func(a *activeStage) {
+			defer wg.Done()
+			wait(a)
+		}(a)
+	}
+	wg.Wait()
+}
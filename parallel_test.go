@@ -0,0 +1,66 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSetParallelStages(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	if err := SetParallelStages([][]Stage{{Stage2, Stage3}}); err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	type span struct{ start, end time.Time }
+	spans := make(map[string]span)
+	fn := func(name string, d time.Duration) ShutdownFn {
+		return func(interface{}) {
+			start := time.Now()
+			time.Sleep(d)
+			mu.Lock()
+			spans[name] = span{start: start, end: time.Now()}
+			mu.Unlock()
+		}
+	}
+	FirstFunc(fn("first", 20*time.Millisecond), nil)
+	SecondFunc(fn("second", 100*time.Millisecond), nil)
+	ThirdFunc(fn("third", 100*time.Millisecond), nil)
+	// The grouped stages only add the longest timeout to the estimate.
+	grouped := EstimateMaxDuration()
+	SetParallelStages(nil)
+	if d := EstimateMaxDuration() - grouped; d != time.Second {
+		t.Fatalf("expected estimate to be a second shorter when grouped, got %v", d)
+	}
+	SetParallelStages([][]Stage{{Stage2, Stage3}})
+	Shutdown()
+
+	first, second, third := spans["first"], spans["second"], spans["third"]
+	if second.start.Before(first.end) || third.start.Before(first.end) {
+		t.Fatal("grouped stages started before the earlier stage finished")
+	}
+	if !second.start.Before(third.end) || !third.start.Before(second.end) {
+		t.Fatalf("grouped stages did not overlap: second %v-%v, third %v-%v", second.start, second.end, third.start, third.end)
+	}
+	sum := LastSummary()
+	if len(sum.Stages) != 4 {
+		t.Fatalf("expected 4 stages in summary, got %+v", sum.Stages)
+	}
+}
+
+func TestSetParallelStagesInvalid(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	for _, groups := range [][][]Stage{
+		{{Preshutdown, Stage1}},
+		{{Stage1, Stage2}, {Stage2, Stage3}},
+		{{Stage{numStages}}},
+	} {
+		if err := SetParallelStages(groups); err == nil {
+			t.Errorf("%v: expected error", groups)
+		}
+	}
+}
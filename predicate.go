@@ -0,0 +1,63 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+// SetStagePredicate sets a function that decides if the given stage is run.
+//
+// The function is called with the reason of the shutdown, when the stage
+// is about to start. If it returns false, the notifiers of the stage are
+// not signalled, and the stage is reported as skipped by LastSummary.
+// This can be used to skip slow stages, like draining connections,
+// when the application must exit fast.
+// If the function panics, the panic is logged and the stage is run.
+// The Preshutdown stage cannot be skipped, since it waits for locks.
+// Use nil to remove the predicate.
+func SetStagePredicate(s Stage, fn func(r Reason) bool) {
+	defaultManager.SetStagePredicate(s, fn)
+}
+
+// SetStagePredicate sets a function that decides if the given stage of the manager is run.
+func (m *Manager) SetStagePredicate(s Stage, fn func(r Reason) bool) {
+	if s == Preshutdown {
+		Logger.Println("SetStagePredicate: ignoring predicate, the preshutdown stage cannot be skipped")
+		return
+	}
+	m.srM.Lock()
+	m.predicates[s.n] = fn
+	m.srM.Unlock()
+}
+
+// runStage returns false if the predicate of the stage says it should be skipped.
+func (m *Manager) runStage(stage int, r Reason) (run bool) {
+	m.srM.RLock()
+	fn := m.predicates[stage]
+	m.srM.RUnlock()
+	if fn == nil {
+		return true
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			Logger.Printf("Panic in predicate of %s stage, running it: %v", stageName(stage), p)
+			run = true
+		}
+	}()
+	return fn(r)
+}
+
+// skipStage tells the notifiers of a stage that it will not be run.
+// Functions of the stage will not be called.
+// m.sqM must be held.
+func (m *Manager) skipStage(stage int) {
+	for _, n := range m.shutdownQueue[stage] {
+		expire(n)
+	}
+	for _, fn := range m.shutdownFnQueue[stage] {
+		// Stop the goroutine waiting to call the function.
+		select {
+		case <-fn.cancel:
+		default:
+			close(fn.cancel)
+		}
+		expire(fn.client)
+	}
+}
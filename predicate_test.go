@@ -0,0 +1,66 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStagePredicate(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	fakeExit()
+	SetExitFlushDelay(0)
+	// Skip the drain when exiting with an error.
+	SetStagePredicate(Stage2, func(r Reason) bool {
+		return !strings.HasPrefix(r.Cause, "Exit(1)")
+	})
+	var first, second, third bool
+	FirstFunc(setBool, &first)
+	n := SecondFunc(setBool, &second)
+	ThirdFunc(setBool, &third)
+	Exit(1)
+	if !first || second || !third {
+		t.Fatal("unexpected stages run", first, second, third)
+	}
+	select {
+	case <-n.Expired():
+	default:
+		t.Fatal("skipped notifier not expired")
+	}
+	s := LastSummary()
+	if len(s.Stages) != 4 || !s.Stages[2].Skipped || s.Stages[2].TimedOut || s.TimedOut {
+		t.Fatal("unexpected summary", s.Stages)
+	}
+
+	Reset()
+	first, third = false, false
+	FirstFunc(setBool, &first)
+	SecondFunc(setBool, &second)
+	ThirdFunc(setBool, &third)
+	Shutdown()
+	if !first || !second || !third {
+		t.Fatal("unexpected stages run", first, second, third)
+	}
+	if s := LastSummary(); s.Stages[2].Skipped {
+		t.Fatal("stage reported as skipped", s.Stages)
+	}
+}
+
+func TestStagePredicatePanic(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	lines, restore := logLines()
+	defer restore()
+	SetStagePredicate(Stage1, func(Reason) bool { panic("This is expected") })
+	SetStagePredicate(Preshutdown, func(Reason) bool { return false })
+	nextLine(t, lines, "preshutdown stage cannot be skipped")
+	var first bool
+	FirstFunc(setBool, &first)
+	Shutdown()
+	nextLine(t, lines, "Panic in predicate of first stage, running it: This is expected")
+	if !first {
+		t.Fatal("stage not run after predicate panic")
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"io"
+	"runtime/pprof"
+	"sort"
+	"time"
+)
+
+// SetProfileShutdown enables profiling of the shutdown.
+//
+// When shutdown starts, a CPU profile is started, which is written
+// to w when the shutdown has finished, before the application exits.
+// The time each notifier and function took is logged,
+// with the slowest first.
+// Use nil to disable profiling, which is the default.
+func SetProfileShutdown(w io.Writer) {
+	defaultManager.SetProfileShutdown(w)
+}
+
+// SetProfileShutdown enables profiling of the shutdown of the manager.
+func (m *Manager) SetProfileShutdown(w io.Writer) {
+	m.srM.Lock()
+	m.profile = w
+	m.srM.Unlock()
+}
+
+// callbackTime is the time a notifier took to finish.
+type callbackTime struct {
+	stage    int
+	label    string
+	start    time.Duration // Monotonic time the stage started waiting for the notifier.
+	d        time.Duration
+	finished bool
+}
+
+// startProfile starts profiling, if enabled.
+// The returned function stops it and logs the time of each notifier.
+func (m *Manager) startProfile() (stop func()) {
+	m.srM.RLock()
+	w := m.profile
+	m.srM.RUnlock()
+	if w == nil {
+		return func() {}
+	}
+	cpu := true
+	if err := pprof.StartCPUProfile(w); err != nil {
+		Logger.Println("Unable to start shutdown profile:", err)
+		cpu = false
+	}
+	return func() {
+		if cpu {
+			pprof.StopCPUProfile()
+		}
+		m.logTimings()
+	}
+}
+
+// recordTiming records the time a notifier took, since the stage started waiting for it at start.
+// The timings are logged when profiling, and used by WriteTrace.
+func (m *Manager) recordTiming(stage int, label string, start time.Duration, finished bool) {
+	d := m.clock.Mono() - start
+	m.timM.Lock()
+	m.timings = append(m.timings, callbackTime{stage: stage, label: label, start: start, d: d, finished: finished})
+	m.timM.Unlock()
+	if finished {
+		m.emitProgress("stage %s: %s finished after %v", stageName(stage), label, d)
+	} else {
+		m.emitProgress("stage %s: %s did not finish in %v", stageName(stage), label, d)
+	}
+}
+
+// logTimings logs the recorded timings, slowest first.
+func (m *Manager) logTimings() {
+	sort.SliceStable(m.timings, func(i, j int) bool {
+		return m.timings[i].d > m.timings[j].d
+	})
+	for _, t := range m.timings {
+		if t.finished {
+			Logger.Printf("Stage %d: %s took %v", t.stage, t.label, t.d)
+		} else {
+			Logger.Printf("Stage %d: %s did not finish in %v", t.stage, t.label, t.d)
+		}
+	}
+}
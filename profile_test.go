@@ -0,0 +1,67 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestProfileShutdown(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var buf bytes.Buffer
+	SetProfileShutdown(&buf)
+	lines, restore := logLines()
+	defer restore()
+
+	busy := FirstFunc(func(interface{}) {
+		for end := time.Now().Add(200 * time.Millisecond); time.Now().Before(end); {
+		}
+	}, nil)
+	SetTimeoutN(Stage2, 100*time.Millisecond)
+	hang := Second()
+	go func() {
+		<-hang
+		// Never finish
+	}()
+	want := []string{
+		fmt.Sprintf("Stage 1: notifier id %d took", busy.ID()),
+		fmt.Sprintf("Stage 2: notifier id %d did not finish in", hang.ID()),
+	}
+	Shutdown()
+
+	// The busy function is the slowest, so it is logged first.
+	for _, w := range want {
+		nextLine(t, lines, w)
+	}
+	// Profiles are gzip compressed protocol buffers.
+	r, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal("profile is not gzip compressed:", err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) == 0 {
+		t.Fatal("profile is empty")
+	}
+}
+
+func TestProfileShutdownDisabled(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var buf bytes.Buffer
+	SetProfileShutdown(&buf)
+	SetProfileShutdown(nil)
+	FirstFunc(func(interface{}) {}, nil)
+	Shutdown()
+	if buf.Len() != 0 {
+		t.Fatal("profile written when disabled")
+	}
+}
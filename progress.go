@@ -0,0 +1,157 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// progressStream receives the progress lines of a shutdown, see StreamProgress.
+type progressStream struct {
+	lines   []string      // Lines that haven't been written yet.
+	summary bool          // True when the summary has been added.
+	wake    chan struct{} // Has a value when lines have been added.
+}
+
+// StreamProgress writes the progress of the shutdown to w as it happens,
+// and returns when the shutdown has completed.
+//
+// A line is written when shutdown starts, when a stage starts, when each
+// notifier finishes or is no longer waited for, and when the stage is done,
+// times out or is skipped. When shutdown has completed, a summary line is
+// written and StreamProgress returns. If shutdown hasn't started, it waits
+// for it. If it has already completed, only the summary of it is written.
+//
+// Lines are written by the goroutine calling StreamProgress, so a slow
+// writer, like a terminal, doesn't delay the shutdown. It is meant for
+// tools showing the progress, for instance with w being os.Stdout.
+// If writing fails the error is returned. If the manager is closed
+// while waiting, see Close, nil is returned.
+func StreamProgress(w io.Writer) error {
+	return defaultManager.StreamProgress(w)
+}
+
+// StreamProgress writes the progress of the shutdown of the manager to w, and returns when it has completed.
+func (m *Manager) StreamProgress(w io.Writer) error {
+	s := &progressStream{wake: make(chan struct{}, 1)}
+	m.srM.RLock()
+	done := m.done
+	m.streamM.Lock()
+	m.streams = append(m.streams, s)
+	m.streamM.Unlock()
+	m.srM.RUnlock()
+	defer m.removeStream(s)
+
+	for {
+		select {
+		case <-s.wake:
+			if err := m.writeProgress(w, s); err != nil {
+				return err
+			}
+		case <-done:
+			if err := m.writeProgress(w, s); err != nil {
+				return err
+			}
+			m.streamM.Lock()
+			summarized := s.summary
+			m.streamM.Unlock()
+			if !summarized {
+				// Completed before s was added.
+				_, err := io.WriteString(w, progressSummary(m.LastSummary())+"\n")
+				return err
+			}
+			return nil
+		case <-m.closed:
+			return nil
+		}
+	}
+}
+
+// writeProgress writes the lines of s that haven't been written.
+func (m *Manager) writeProgress(w io.Writer, s *progressStream) error {
+	m.streamM.Lock()
+	lines := s.lines
+	s.lines = nil
+	m.streamM.Unlock()
+	for _, line := range lines {
+		if _, err := io.WriteString(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeStream stops sending progress lines to s.
+func (m *Manager) removeStream(s *progressStream) {
+	m.streamM.Lock()
+	defer m.streamM.Unlock()
+	for i, st := range m.streams {
+		if st == s {
+			m.streams = append(m.streams[:i:i], m.streams[i+1:]...)
+			return
+		}
+	}
+}
+
+// emitProgress sends a progress line to the streams of the manager.
+// A newline is added.
+func (m *Manager) emitProgress(format string, args ...interface{}) {
+	m.streamM.Lock()
+	defer m.streamM.Unlock()
+	m.emitLocked(fmt.Sprintf(format, args...))
+}
+
+// emitSummary sends the summary of the completed shutdown to the streams of the manager.
+func (m *Manager) emitSummary(sum Summary) {
+	m.streamM.Lock()
+	defer m.streamM.Unlock()
+	for _, s := range m.streams {
+		s.summary = true
+	}
+	m.emitLocked(progressSummary(sum))
+}
+
+// emitLocked adds a line to the streams of the manager. m.streamM must be held.
+func (m *Manager) emitLocked(line string) {
+	for _, s := range m.streams {
+		s.lines = append(s.lines, line+"\n")
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// emitStageState sends a line about a stage changing state to the streams of the manager.
+func (m *Manager) emitStageState(stage int, state StageState, d time.Duration) {
+	switch state {
+	case StageRunning:
+		m.emitProgress("stage %s started", stageName(stage))
+	case StageDone:
+		m.emitProgress("stage %s done in %v", stageName(stage), d)
+	case StageTimedOut:
+		m.emitProgress("stage %s timed out after %v", stageName(stage), d)
+	case StageSkipped:
+		m.emitProgress("stage %s skipped", stageName(stage))
+	}
+}
+
+// progressSummary returns the summary line of a completed shutdown.
+func progressSummary(s Summary) string {
+	var ran, timedOut, skipped int
+	for _, st := range s.Stages {
+		switch {
+		case st.Skipped:
+			skipped++
+		case st.TimedOut:
+			timedOut++
+			ran++
+		default:
+			ran++
+		}
+	}
+	return fmt.Sprintf("shutdown completed in %v (%s): %d stages run, %d timed out, %d skipped, %d panics",
+		s.Duration, s.Reason.Cause, ran, timedOut, skipped, s.Panics)
+}
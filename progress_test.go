@@ -0,0 +1,81 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitStreams waits until n streams are added to m, see StreamProgress.
+func waitStreams(t *testing.T, m *Manager, n int) {
+	for {
+		m.streamM.Lock()
+		added := len(m.streams)
+		m.streamM.Unlock()
+		if added >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStreamProgress(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeoutN(Stage2, 50*time.Millisecond)
+	flush := FirstFunc(func(interface{}) {}, nil).ID()
+	release := make(chan struct{})
+	defer close(release)
+	stuck := SecondFunc(func(interface{}) { <-release }, nil).ID()
+
+	var buf bytes.Buffer
+	streamed := make(chan error)
+	go func() {
+		streamed <- StreamProgress(&buf)
+	}()
+	waitStreams(t, defaultManager, 1)
+	Shutdown()
+	if err := <-streamed; err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	for _, want := range []string{
+		"shutdown started: Shutdown called",
+		"stage preshutdown started",
+		"stage preshutdown done in",
+		"stage first started",
+		fmt.Sprintf("stage first: notifier id %d finished after", flush),
+		"stage first done in",
+		"stage second started",
+		fmt.Sprintf("stage second: notifier id %d did not finish in", stuck),
+		"stage second timed out after",
+		"shutdown completed in",
+	} {
+		found := false
+		for len(lines) > 0 && !found {
+			found = strings.HasPrefix(lines[0], want)
+			lines = lines[1:]
+		}
+		if !found {
+			t.Fatalf("no line starting with %q in order, got:\n%s", want, buf.String())
+		}
+	}
+	last := strings.TrimSpace(buf.String())
+	if !strings.HasSuffix(last, "(Shutdown called): 3 stages run, 1 timed out, 0 skipped, 0 panics") {
+		t.Fatal("unexpected summary", last)
+	}
+
+	// Completed shutdowns only write the summary.
+	buf.Reset()
+	if err := StreamProgress(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); !strings.HasPrefix(out, "shutdown completed in") || strings.Count(out, "\n") != 1 {
+		t.Fatalf("unexpected output after shutdown: %q", out)
+	}
+}
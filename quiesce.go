@@ -0,0 +1,111 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// defaultQuiesceTimeout is the longest a lock waits while quiesced, see SetQuiesceTimeout.
+const defaultQuiesceTimeout = time.Minute
+
+// Quiesce makes new calls to Lock, LockWithToken, BeginWork and Go wait
+// until Unquiesce is called, shutdown has been initiated, or the quiesce
+// timeout has expired, see SetQuiesceTimeout.
+// Locks that are already held are not affected.
+//
+// Unlike shutdown, callers are not rejected, but wait. This is meant
+// for brief coordination windows, like swapping a configuration
+// while no new requests are started. When shutdown is initiated,
+// the waiting calls return like they would during shutdown.
+// Calling Quiesce while quiesced, or after shutdown has been initiated, does nothing.
+func Quiesce() {
+	defaultManager.Quiesce()
+}
+
+// Quiesce makes new locks of the manager wait until Unquiesce is called.
+func (m *Manager) Quiesce() {
+	m.quM.Lock()
+	defer m.quM.Unlock()
+	if m.quiesced != nil || m.Started() {
+		return
+	}
+	m.quiesced = make(chan struct{})
+	atomic.StoreInt32(&m.quiescing, 1)
+}
+
+// Unquiesce releases the calls waiting because of Quiesce.
+// Calling Unquiesce while not quiesced does nothing.
+func Unquiesce() {
+	defaultManager.Unquiesce()
+}
+
+// Unquiesce releases the calls waiting because of Quiesce of the manager.
+func (m *Manager) Unquiesce() {
+	m.quM.Lock()
+	defer m.quM.Unlock()
+	if m.quiesced != nil {
+		atomic.StoreInt32(&m.quiescing, 0)
+		close(m.quiesced)
+		m.quiesced = nil
+	}
+}
+
+// SetQuiesceTimeout sets the longest time a call waits while quiesced,
+// see Quiesce. When it expires, the call proceeds as if Unquiesce
+// had been called. The default is one minute.
+// A timeout of 0 or less makes calls wait until Unquiesce or shutdown.
+func SetQuiesceTimeout(d time.Duration) {
+	defaultManager.SetQuiesceTimeout(d)
+}
+
+// SetQuiesceTimeout sets the longest time a call waits while the manager is quiesced.
+func (m *Manager) SetQuiesceTimeout(d time.Duration) {
+	m.quM.Lock()
+	m.quiesceTimeout = d
+	m.quM.Unlock()
+}
+
+// waitQuiesce waits until the manager is no longer quiesced,
+// or the quiesce timeout has expired.
+func (m *Manager) waitQuiesce() {
+	// Locks are taken often, so only lock when quiesced.
+	if atomic.LoadInt32(&m.quiescing) == 0 {
+		return
+	}
+	m.quM.Lock()
+	q, timeout := m.quiesced, m.quiesceTimeout
+	m.quM.Unlock()
+	if q == nil {
+		return
+	}
+	atomic.AddInt32(&m.lockWaiters, 1)
+	defer atomic.AddInt32(&m.lockWaiters, -1)
+	if timeout <= 0 {
+		<-q
+		return
+	}
+	t := m.clock.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-q:
+	case <-t.C():
+		Logger.Printf("Quiesce timeout of %v expired, proceeding", timeout)
+	}
+}
+
+// LockWaiters returns the number of goroutines waiting to acquire a lock,
+// because the manager is quiesced, see Quiesce.
+//
+// This is a single atomic read, so it can be polled often,
+// for instance by an autoscaler or admission control that
+// needs to see backpressure.
+func LockWaiters() int {
+	return defaultManager.LockWaiters()
+}
+
+// LockWaiters returns the number of goroutines waiting to acquire a lock of the manager.
+func (m *Manager) LockWaiters() int {
+	return int(atomic.LoadInt32(&m.lockWaiters))
+}
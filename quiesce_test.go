@@ -0,0 +1,156 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuiesce(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	if !Lock() {
+		t.Fatal("could not get lock")
+	}
+	Quiesce()
+	// Locks held are not affected.
+	Unlock()
+
+	got := make(chan bool)
+	go func() {
+		got <- Lock()
+	}()
+	select {
+	case <-got:
+		t.Fatal("Lock returned while quiesced")
+	case <-time.After(50 * time.Millisecond):
+	}
+	Unquiesce()
+	select {
+	case ok := <-got:
+		if !ok {
+			t.Fatal("expected to get lock")
+		}
+		Unlock()
+	case <-time.After(time.Second):
+		t.Fatal("Lock did not return after Unquiesce")
+	}
+	// Unquiesce when not quiesced does nothing.
+	Unquiesce()
+}
+
+func TestQuiesceShutdown(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	Quiesce()
+	got := make(chan bool)
+	go func() {
+		got <- Lock()
+	}()
+	go func() {
+		_, ok := BeginWork()
+		got <- ok
+	}()
+	time.Sleep(10 * time.Millisecond)
+	Shutdown()
+	for i := 0; i < 2; i++ {
+		select {
+		case ok := <-got:
+			if ok {
+				t.Fatal("expected lock to fail after shutdown")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("lock did not return after shutdown")
+		}
+	}
+	// Quiesce after shutdown does nothing.
+	Quiesce()
+	if Lock() {
+		t.Fatal("expected lock to fail after shutdown")
+	}
+}
+
+// waitForWaiters waits until LockWaiters returns n.
+func waitForWaiters(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for LockWaiters() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d lock waiters, want %d", LockWaiters(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLockWaiters(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	if LockWaiters() != 0 {
+		t.Fatal("waiters before quiesce")
+	}
+	Quiesce()
+	got := make(chan bool)
+	for i := 0; i < 3; i++ {
+		go func() {
+			got <- Lock()
+		}()
+	}
+	waitForWaiters(t, 3)
+	Unquiesce()
+	for i := 0; i < 3; i++ {
+		if !<-got {
+			t.Fatal("lock not acquired")
+		}
+		Unlock()
+	}
+	waitForWaiters(t, 0)
+
+	// Waiters give up when shutdown is initiated.
+	Quiesce()
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, ok := BeginWork()
+			got <- ok
+		}()
+	}
+	waitForWaiters(t, 2)
+	Shutdown()
+	for i := 0; i < 2; i++ {
+		if <-got {
+			t.Fatal("lock acquired after shutdown")
+		}
+	}
+	waitForWaiters(t, 0)
+}
+
+func TestQuiesceTimeout(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetQuiesceTimeout(50 * time.Millisecond)
+	Quiesce()
+	defer Unquiesce()
+	start := time.Now()
+	if !Lock() {
+		t.Fatal("expected to get lock after the quiesce timeout")
+	}
+	Unlock()
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Fatal("Lock returned before the quiesce timeout", d)
+	}
+}
+
+func TestQuiesceGo(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	Quiesce()
+	started := make(chan bool)
+	go func() {
+		started <- Go(func() {})
+	}()
+	waitForWaiters(t, 1)
+	Unquiesce()
+	if !<-started {
+		t.Fatal("Go did not start after Unquiesce")
+	}
+}
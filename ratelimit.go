@@ -0,0 +1,41 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"time"
+)
+
+// NewRateLimitedManager returns a new Manager, which signals at most
+// rate notifiers per second. See WithRateLimit.
+// The options are applied after the rate limit.
+func NewRateLimitedManager(rate int, opts ...Option) *Manager {
+	return NewManager(append([]Option{WithRateLimit(rate)}, opts...)...)
+}
+
+// WithRateLimit limits the number of notifiers signalled per second
+// during shutdown to rate. The limit applies across all stages.
+//
+// This can be used when notifiers contact other services, which must
+// not be overloaded. Signalling is spread evenly, so with a rate of 10
+// a notifier is signalled every 100 milliseconds. The time spent waiting
+// counts towards the timeout of the stage, so set the timeouts accordingly.
+// A rate of 0 or less removes the limit, which is the default.
+func WithRateLimit(rate int) Option {
+	return func(m *Manager) {
+		m.rateLimit = rate
+	}
+}
+
+// throttle waits until the next notifier may be signalled.
+// It is only called by the shutdown goroutine.
+func (m *Manager) throttle() {
+	m.srM.RLock()
+	rate := m.rateLimit
+	m.srM.RUnlock()
+	if rate <= 0 {
+		return
+	}
+	m.waitUntil(m.nextSignal)
+	m.nextSignal = m.clock.Mono() + time.Second/time.Duration(rate)
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimitedManager(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	m := NewRateLimitedManager(20)
+	m.SetTimeout(time.Second)
+	var got [6]bool
+	for i := range got {
+		// Spread across stages, the limit is global.
+		switch i % 3 {
+		case 0:
+			m.FirstFunc(setBool, &got[i])
+		case 1:
+			m.SecondFunc(setBool, &got[i])
+		case 2:
+			m.ThirdFunc(setBool, &got[i])
+		}
+	}
+	tn := time.Now()
+	m.Shutdown()
+	// 7 notifiers including the lock drain, 50ms apart.
+	if d := time.Since(tn); d < 300*time.Millisecond {
+		t.Fatal("shutdown was not rate limited", d)
+	}
+	for i, ok := range got {
+		if !ok {
+			t.Fatal("function not called", i)
+		}
+	}
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	m := NewRateLimitedManager(0)
+	for i := 0; i < 100; i++ {
+		m.FirstFunc(func(interface{}) {}, nil)
+	}
+	tn := time.Now()
+	m.Shutdown()
+	if d := time.Since(tn); d > 500*time.Millisecond {
+		t.Fatal("shutdown was rate limited", d)
+	}
+}
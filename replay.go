@@ -0,0 +1,157 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// recording is a shutdown recorded by SetRecordShutdown.
+type recording struct {
+	Reason string         `json:"reason"`
+	Stages []recordedStep `json:"stages"`
+	Calls  []recordedCall `json:"calls"`
+}
+
+// recordedStep is a stage of a recorded shutdown.
+type recordedStep struct {
+	Stage    string        `json:"stage"`
+	Start    time.Duration `json:"start"` // Since the shutdown started.
+	Duration time.Duration `json:"duration"`
+	TimedOut bool          `json:"timed_out,omitempty"`
+	Skipped  bool          `json:"skipped,omitempty"`
+}
+
+// recordedCall is a notifier or function of a recorded shutdown.
+type recordedCall struct {
+	ID       string        `json:"id"`
+	Stage    string        `json:"stage"`
+	Label    string        `json:"label"`
+	Value    string        `json:"value,omitempty"`
+	Start    time.Duration `json:"start"` // Since the shutdown started.
+	Duration time.Duration `json:"duration"`
+	Finished bool          `json:"finished"`
+}
+
+// callIdentity identifies a notifier in a recording.
+type callIdentity struct {
+	id    string
+	value string
+}
+
+// SetRecordShutdown enables recording of the shutdown.
+//
+// When the shutdown has finished, a JSON document is written to w,
+// with the stages that were run, and the notifiers and functions of
+// each stage, in the order they finished. Each has an id, its label
+// in the log, the value given to it if it is a function, formatted
+// with fmt, the time it started and took, and whether it finished.
+// The id is the call site of the notifier if it was recorded, see
+// SetDebugMode, otherwise the id of the notifier, see Notifier.ID.
+// The recording can be replayed with Replay.
+// Use nil to disable recording, which is the default.
+func SetRecordShutdown(w io.Writer) {
+	defaultManager.SetRecordShutdown(w)
+}
+
+// SetRecordShutdown enables recording of the shutdown of the manager.
+func (m *Manager) SetRecordShutdown(w io.Writer) {
+	m.srM.Lock()
+	m.record = w
+	m.srM.Unlock()
+}
+
+// Replay calls the functions of a shutdown recorded by SetRecordShutdown.
+//
+// The recorded notifiers and functions are replayed one at a time,
+// stage by stage, in the order they finished when recorded. For each,
+// resolver is called with its id, and the returned function is called.
+// If resolver returns nil, the notifier is skipped. The recorded timings
+// are not reproduced, so sequencing problems can be reproduced
+// deterministically, for instance in a test or a debugger.
+func Replay(r io.Reader, resolver func(id string) func()) error {
+	var rec recording
+	if err := json.NewDecoder(r).Decode(&rec); err != nil {
+		return fmt.Errorf("shutdown: reading recording: %v", err)
+	}
+	for _, c := range rec.Calls {
+		if fn := resolver(c.ID); fn != nil {
+			fn()
+		}
+	}
+	return nil
+}
+
+// recordIdentities remembers the identity of the notifiers
+// of a stage by their label, if recording is enabled. m.sqM must be held.
+func (m *Manager) recordIdentities(stage int, queue []Notifier, labels []string) {
+	m.srM.RLock()
+	enabled := m.record != nil
+	m.srM.RUnlock()
+	if !enabled {
+		return
+	}
+	for i, n := range queue {
+		n = m.client(stage, n)
+		nM.Lock()
+		ns := notifiers[n]
+		var id callIdentity
+		switch {
+		case ns == nil || labels[i] == "lock drain":
+			id.id = labels[i]
+		case ns.callSite != "":
+			id.id = ns.callSite
+		default:
+			id.id = strconv.FormatUint(ns.id, 10)
+		}
+		if ns != nil && ns.value != nil {
+			ns.value.mu.Lock()
+			id.value = fmt.Sprint(ns.value.v)
+			ns.value.mu.Unlock()
+		}
+		nM.Unlock()
+		m.identities[labels[i]] = id
+	}
+}
+
+// writeRecording writes the shutdown that has just completed, if recording is enabled.
+func (m *Manager) writeRecording(stages []StageSummary, starts []time.Duration) {
+	m.srM.RLock()
+	w, start, reason := m.record, m.startedMono, m.reason.Cause
+	m.srM.RUnlock()
+	if w == nil {
+		return
+	}
+	rec := recording{Reason: reason, Stages: []recordedStep{}, Calls: []recordedCall{}}
+	for i, s := range stages {
+		rec.Stages = append(rec.Stages, recordedStep{
+			Stage:    stageName(s.Stage),
+			Start:    starts[i] - start,
+			Duration: s.Duration,
+			TimedOut: s.TimedOut,
+			Skipped:  s.Skipped,
+		})
+	}
+	for _, t := range m.timings {
+		id, ok := m.identities[t.label]
+		if !ok {
+			id.id = t.label
+		}
+		rec.Calls = append(rec.Calls, recordedCall{
+			ID:       id.id,
+			Stage:    stageName(t.stage),
+			Label:    t.label,
+			Value:    id.value,
+			Start:    t.start - start,
+			Duration: t.d,
+			Finished: t.finished,
+		})
+	}
+	if err := json.NewEncoder(w).Encode(rec); err != nil {
+		Logger.Println("Unable to write shutdown recording:", err)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRecordReplay(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var buf bytes.Buffer
+	SetRecordShutdown(&buf)
+	defer SetRecordShutdown(nil)
+
+	var mu sync.Mutex
+	var order []string
+	call := func(name string) func() {
+		return func() {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+		}
+	}
+	names := make(map[string]string)
+	register := func(name string, delay time.Duration, stage func(fn ShutdownFn, v interface{}) Notifier) {
+		n := stage(func(v interface{}) {
+			time.Sleep(delay)
+			call(name)()
+		}, name)
+		names[strconv.FormatUint(n.ID(), 10)] = name
+	}
+	register("slow first", 40*time.Millisecond, FirstFunc)
+	register("fast first", 0, FirstFunc)
+	register("second", 0, SecondFunc)
+	register("third", 0, ThirdFunc)
+	Shutdown()
+	want := []string{"fast first", "slow first", "second", "third"}
+	if !reflect.DeepEqual(order, want) {
+		t.Fatalf("got order %q, want %q", order, want)
+	}
+	if !strings.Contains(buf.String(), `"value":"slow first"`) {
+		t.Fatalf("value not recorded: %s", buf.String())
+	}
+
+	var replayed []string
+	err := Replay(&buf, func(id string) func() {
+		name, ok := names[id]
+		if !ok {
+			// The lock drain can't be replayed.
+			return nil
+		}
+		return func() { replayed = append(replayed, name) }
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(replayed, order) {
+		t.Fatalf("replayed %q, want %q", replayed, order)
+	}
+
+	if err := Replay(strings.NewReader("not json"), nil); err == nil {
+		t.Fatal("expected error for invalid recording")
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"sync"
+	"time"
+)
+
+// ScheduleShutdown will start the shutdown at the given time.
+// If the time has already passed, shutdown is started immediately.
+//
+// Call the returned function to cancel the scheduled shutdown.
+// If shutdown has already been started when it is called, it has no effect.
+func ScheduleShutdown(at time.Time) (cancel func()) {
+	return defaultManager.ScheduleShutdown(at)
+}
+
+// ScheduleShutdown will start the shutdown of the manager at the given time.
+func (m *Manager) ScheduleShutdown(at time.Time) (cancel func()) {
+	r := Reason{Cause: "shutdown scheduled at " + at.Format(time.RFC3339)}
+	cancelled := make(chan struct{})
+	var once sync.Once
+	cancel = func() {
+		once.Do(func() { close(cancelled) })
+	}
+
+	d := at.Sub(m.clock.Now())
+	if d <= 0 {
+		go m.shutdown(r)
+		return cancel
+	}
+	if !m.addWatcher() {
+		return cancel
+	}
+	m.srM.RLock()
+	done := m.done
+	m.srM.RUnlock()
+	t := m.clock.NewTimer(d)
+	go func() {
+		defer m.watchers.Done()
+		defer t.Stop()
+		select {
+		case <-t.C():
+			m.shutdown(r)
+		case <-cancelled:
+		case <-done:
+		case <-m.closed:
+		}
+	}()
+	return cancel
+}
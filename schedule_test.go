@@ -0,0 +1,81 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleShutdown(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	c := newFakeClock()
+	defaultManager.clock = c
+	var got bool
+	FirstFunc(setBool, &got)
+
+	at := c.Now().Add(time.Hour)
+	ScheduleShutdown(at)
+	c.waitTimers(t, 1)
+	c.Advance(time.Hour - time.Second)
+	time.Sleep(10 * time.Millisecond)
+	if Started() {
+		t.Fatal("shutdown started before scheduled time")
+	}
+	c.Advance(time.Second)
+	<-defaultManager.done
+	if !got {
+		t.Fatal("shutdown function not called")
+	}
+	if r := Stats().Reason.Cause; r != "shutdown scheduled at "+at.Format(time.RFC3339) {
+		t.Fatal("unexpected reason", r)
+	}
+}
+
+func TestScheduleShutdownPast(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	ScheduleShutdown(time.Now().Add(-time.Hour))
+	<-defaultManager.done
+}
+
+func TestScheduleShutdownCancel(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	c := newFakeClock()
+	defaultManager.clock = c
+	cancel := ScheduleShutdown(c.Now().Add(time.Hour))
+	c.waitTimers(t, 1)
+	cancel()
+	cancel()
+	// The timer must be cleaned up.
+	c.waitTimers(t, 0)
+	c.Advance(2 * time.Hour)
+	time.Sleep(10 * time.Millisecond)
+	if Started() {
+		t.Fatal("cancelled shutdown was started")
+	}
+}
+
+// A manual shutdown must clean up the scheduled shutdown.
+func TestScheduleShutdownManual(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	c := newFakeClock()
+	defaultManager.clock = c
+	ScheduleShutdown(c.Now().Add(time.Hour))
+	c.waitTimers(t, 1)
+	Shutdown()
+	c.waitTimers(t, 0)
+}
+
+func TestScheduleShutdownReset(t *testing.T) {
+	defer close(startTimer(t))
+	m := NewManager()
+	defer m.Close()
+	cancel := m.ScheduleShutdown(time.Now().Add(time.Hour))
+	defer cancel()
+	// Must not race with the goroutine waiting for the schedule.
+	m.Reset()
+}
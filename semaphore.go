@@ -0,0 +1,199 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// ErrShutdownInProgress is returned by Semaphore.Acquire
+// when shutdown has started.
+var ErrShutdownInProgress = errors.New("shutdown: shutdown in progress")
+
+// A Semaphore bounds the number of concurrent jobs, that must finish before the first stage.
+//
+// It works like Lock, but only a limited number of permits can be held
+// at the same time. When shutdown starts, no more permits are granted,
+// and the Preshutdown stage waits for all permits to be returned.
+// If the stage times out first, the holders are logged. Where permits
+// were acquired is only known when debug mode is enabled, see SetDebugMode.
+type Semaphore struct {
+	m    *Manager
+	size int
+
+	mu      sync.Mutex // Mutex for below
+	held    int
+	holders []semaphoreHolder
+	closed  bool
+	wake    chan struct{} // Closed and replaced when a permit is released.
+	drained chan struct{} // Closed when closed and all permits are released.
+}
+
+type semaphoreHolder struct {
+	site     string
+	function string
+}
+
+// NewSemaphore returns a new Semaphore with n permits.
+func NewSemaphore(n int) *Semaphore {
+	return defaultManager.NewSemaphore(n)
+}
+
+// NewSemaphore returns a new Semaphore with n permits, that shutdown of the manager waits for.
+func (m *Manager) NewSemaphore(n int) *Semaphore {
+	if n <= 0 {
+		panic("shutdown: semaphore size must be positive")
+	}
+	s := &Semaphore{m: m, size: n}
+	s.reset()
+	m.sqM.Lock()
+	m.semaphores = append(m.semaphores, s)
+	m.sqM.Unlock()
+	return s
+}
+
+// Acquire acquires a permit, blocking until one is available.
+//
+// If shutdown has started, ErrShutdownInProgress is returned,
+// also while waiting. If ctx is cancelled while waiting, its error is returned.
+// If nil is returned, you must call Release once.
+func (s *Semaphore) Acquire(ctx context.Context) error {
+	site, function := caller()
+	for {
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return ErrShutdownInProgress
+		}
+		if s.held < s.size {
+			s.acquire(site, function)
+			s.mu.Unlock()
+			return nil
+		}
+		wake := s.wake
+		s.mu.Unlock()
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// TryAcquire acquires a permit, if one is available and shutdown hasn't started.
+// If true is returned, you must call Release once.
+func (s *Semaphore) TryAcquire() bool {
+	site, function := caller()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || s.held >= s.size {
+		return false
+	}
+	s.acquire(site, function)
+	return true
+}
+
+// acquire records a permit as held. s.mu must be held.
+func (s *Semaphore) acquire(site, function string) {
+	s.held++
+	if site != "" {
+		s.holders = append(s.holders, semaphoreHolder{site: site, function: function})
+	}
+}
+
+// Release returns a permit.
+func (s *Semaphore) Release() {
+	_, function := caller()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.held == 0 {
+		panic("shutdown: Release called without a permit")
+	}
+	s.held--
+	// Forget the holder in the same function, or else the oldest.
+	if len(s.holders) > 0 {
+		i := 0
+		for j, h := range s.holders {
+			if h.function == function {
+				i = j
+				break
+			}
+		}
+		s.holders = append(s.holders[:i], s.holders[i+1:]...)
+	}
+	close(s.wake)
+	s.wake = make(chan struct{})
+	if s.closed && s.held == 0 {
+		close(s.drained)
+	}
+}
+
+// close stops granting permits, and wakes up waiting callers.
+// It returns the number of permits held, and a channel that
+// is closed when they have been released.
+func (s *Semaphore) close() (int, chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	close(s.wake)
+	s.wake = make(chan struct{})
+	if s.held == 0 {
+		close(s.drained)
+	}
+	return s.held, s.drained
+}
+
+// reset makes the semaphore grant permits again.
+func (s *Semaphore) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = false
+	s.wake = make(chan struct{})
+	s.drained = make(chan struct{})
+}
+
+// logHolders logs the permits that are still held.
+func (s *Semaphore) logHolders() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.held == 0 {
+		return
+	}
+	sites := make([]string, 0, len(s.holders))
+	for _, h := range s.holders {
+		sites = append(sites, h.site)
+	}
+	if len(sites) == 0 {
+		Logger.Printf("Semaphore: %d permits still held", s.held)
+		return
+	}
+	Logger.Printf("Semaphore: %d permits still held, acquired at %s", s.held, strings.Join(sites, ", "))
+}
+
+// closeSemaphores stops the semaphores of the manager from granting permits.
+// For each semaphore with permits held, a Preshutdown function
+// waiting for them to be released is added.
+func (m *Manager) closeSemaphores() {
+	m.sqM.Lock()
+	semaphores := append([]*Semaphore(nil), m.semaphores...)
+	m.sqM.Unlock()
+	for _, s := range semaphores {
+		held, drained := s.close()
+		if held == 0 {
+			continue
+		}
+		s := s
+		var n Notifier
+		n = m.onFunc(0, func(interface{}) {
+			select {
+			case <-drained:
+			case <-n.Expired():
+				s.logHolders()
+			}
+		}, nil)
+		markInternal(n)
+	}
+}
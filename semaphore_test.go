@@ -0,0 +1,99 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSemaphore(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	s := NewSemaphore(2)
+	if !s.TryAcquire() || !s.TryAcquire() {
+		t.Fatal("unable to acquire permits")
+	}
+	if s.TryAcquire() {
+		t.Fatal("acquired more permits than the size")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Acquire(ctx); err != context.DeadlineExceeded {
+		t.Fatal("unexpected error", err)
+	}
+
+	acquired := make(chan error)
+	go func() {
+		acquired <- s.Acquire(context.Background())
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired permit while none were free")
+	case <-time.After(50 * time.Millisecond):
+	}
+	s.Release()
+	if err := <-acquired; err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	s.Release()
+	s.Release()
+	expectPanic(t, "shutdown: Release called without a permit", s.Release)
+}
+
+func TestSemaphoreShutdown(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	s := NewSemaphore(1)
+	if !s.TryAcquire() {
+		t.Fatal("unable to acquire permit")
+	}
+	waiting := make(chan error)
+	go func() {
+		waiting <- s.Acquire(context.Background())
+	}()
+
+	var first bool
+	_ = FirstFunc(setBool, &first)
+	finished := make(chan struct{})
+	go func() {
+		Shutdown()
+		close(finished)
+	}()
+	if err := <-waiting; err != ErrShutdownInProgress {
+		t.Fatal("unexpected error", err)
+	}
+	if s.TryAcquire() {
+		t.Fatal("acquired permit after shutdown started")
+	}
+	select {
+	case <-finished:
+		t.Fatal("shutdown did not wait for permit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	s.Release()
+	<-finished
+	if !first {
+		t.Fatal("first stage did not run")
+	}
+}
+
+func TestSemaphoreTimeout(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetDebugMode(true)
+	defer SetDebugMode(false)
+	SetTimeout(100 * time.Millisecond)
+	lines, restore := logLines()
+	defer restore()
+	s := NewSemaphore(3)
+	_ = s.TryAcquire()
+	_ = s.Acquire(context.Background())
+	Shutdown()
+	l := nextLine(t, lines, "Semaphore:")
+	if !strings.Contains(l, "2 permits still held") || strings.Count(l, "semaphore_test.go") != 2 {
+		t.Fatal("unexpected log line", l)
+	}
+}
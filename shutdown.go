@@ -10,9 +10,12 @@
 package shutdown
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"runtime/debug"
+	"strings"
 	"sync"
 	"time"
 )
@@ -31,9 +34,18 @@ var Stage1 = Stage{1}      // Indicates first stage of timeouts.
 var Stage2 = Stage{2}      // Indicates second stage of timeouts.
 var Stage3 = Stage{3}      // Indicates third stage of timeouts.
 
+var ReadOnlyStage = Stage{4} // Indicates stage after Preshutdown where writes should be stopped.
+
+// Number of stages.
+const numStages = 5
+
+// The default order the stages are executed in, see RemapStages.
+var stageOrder = [numStages]int{0, 4, 1, 2, 3}
+
 // Notifier is a channel, that will be sent a channel
 // once the application shuts down.
 // When you have performed your shutdown actions close the channel you are given.
+// See SetNotifierBufferSize for sending a value instead.
 type Notifier chan chan struct{}
 
 type fnNotify struct {
@@ -42,80 +54,453 @@ type fnNotify struct {
 	cancel   chan struct{}
 }
 
-var sqM sync.Mutex // Mutex for below
-var shutdownQueue [4][]Notifier
-var shutdownFnQueue [4][]fnNotify
-
-var srM sync.RWMutex // Mutex for below
-var shutdownRequested = false
-var timeouts = [4]time.Duration{5 * time.Second, 5 * time.Second, 5 * time.Second, 5 * time.Second}
+var defaultManager = NewManager()
 
 // SetTimeout sets maximum delay to wait for each stage to finish.
 // When the timeout has expired for a stage the next stage will be initiated.
+// Stages that have a timeout set by SetTimeoutN keep that timeout.
+// Timeouts that are zero or negative are ignored with a warning.
 func SetTimeout(d time.Duration) {
-	srM.Lock()
-	for i := range timeouts {
-		timeouts[i] = d
+	defaultManager.SetTimeout(d)
+}
+
+// SetTimeout sets maximum delay to wait for each stage of the manager to finish.
+func (m *Manager) SetTimeout(d time.Duration) {
+	if !validTimeout("SetTimeout", d) {
+		return
 	}
-	srM.Unlock()
+	m.srM.Lock()
+	m.timeout = d
+	m.srM.Unlock()
 }
 
 // SetTimeoutN set maximum delay to wait for a specific stage to finish.
 // When the timeout expired for a stage the next stage will be initiated.
 // The stage can be obtained by using the exported variables called 'Stage1, etc.
+// This takes precedence over the timeout set by SetTimeout.
+// Timeouts that are zero or negative are ignored with a warning.
 func SetTimeoutN(s Stage, d time.Duration) {
-	srM.Lock()
-	timeouts[s.n] = d
-	srM.Unlock()
+	defaultManager.SetTimeoutN(s, d)
+}
+
+// SetTimeoutN set maximum delay to wait for a specific stage of the manager to finish.
+func (m *Manager) SetTimeoutN(s Stage, d time.Duration) {
+	if !validTimeout("SetTimeoutN", d) {
+		return
+	}
+	m.srM.Lock()
+	m.timeouts[s.n] = d
+	m.srM.Unlock()
+}
+
+// SetStageMinDuration sets the minimum time the given stage takes.
+//
+// The next stage is not started before d has passed since the stage started,
+// even if all notifiers have finished. This gives external systems time
+// to catch up, for instance load balancers when connections are drained.
+// A stage with a minimum duration waits, even if it has no notifiers.
+// The minimum cannot be longer than the timeout of the stage. If it is,
+// a warning is logged and the timeout is used. Use 0 to remove the minimum.
+func SetStageMinDuration(s Stage, d time.Duration) {
+	defaultManager.SetStageMinDuration(s, d)
+}
+
+// SetStageMinDuration sets the minimum time the given stage of the manager takes.
+func (m *Manager) SetStageMinDuration(s Stage, d time.Duration) {
+	if d < 0 {
+		Logger.Printf("SetStageMinDuration: negative duration %v, using 0", d)
+		d = 0
+	}
+	m.srM.Lock()
+	defer m.srM.Unlock()
+	if to := m.effectiveTimeout(s.n); d > to {
+		Logger.Printf("SetStageMinDuration: duration %v is longer than the timeout of the stage, using %v", d, to)
+		d = to
+	}
+	m.minDurations[s.n] = d
+}
+
+// OnStageTimeout sets a function that is called when the given stage times out,
+// with the notifiers that hadn't finished.
+//
+// Notifiers are described by their id, and where they were registered if
+// debug mode is enabled, for instance "id 12 (server.go:40)".
+// The lock drain of the Preshutdown stage is described as "lock drain".
+// The function is called synchronously before the function set by
+// WithGracefulDegradation and before the next stage is started.
+// If the function panics the panic is logged and shutdown proceeds.
+// Use nil to remove the function.
+func OnStageTimeout(s Stage, fn func(pending []string)) {
+	defaultManager.OnStageTimeout(s, fn)
+}
+
+// OnStageTimeout sets a function that is called when the given stage of the manager times out.
+func (m *Manager) OnStageTimeout(s Stage, fn func(pending []string)) {
+	m.srM.Lock()
+	m.stageTimeoutFns[s.n] = fn
+	m.srM.Unlock()
+}
+
+// SetNotifierBufferSize sets the buffer size of the channels
+// that are sent to notifiers when shutdown starts.
+//
+// By default the channels are unbuffered, and a notifier signals that
+// it is done by closing the channel. With a buffer, a notifier can
+// instead send a value on the channel, and the send will not block,
+// even if the stage has timed out and is no longer waiting.
+// A negative size is treated as 0 with a warning.
+func SetNotifierBufferSize(n int) {
+	defaultManager.SetNotifierBufferSize(n)
+}
+
+// SetNotifierBufferSize sets the buffer size of the channels sent to notifiers of the manager.
+func (m *Manager) SetNotifierBufferSize(n int) {
+	if n < 0 {
+		Logger.Printf("SetNotifierBufferSize: negative size %d, using 0", n)
+		n = 0
+	}
+	m.srM.Lock()
+	m.notifierBuffer = n
+	m.srM.Unlock()
+}
+
+// waitUntil waits until the monotonic clock reaches t.
+func (m *Manager) waitUntil(t time.Duration) {
+	d := t - m.clock.Mono()
+	if d <= 0 {
+		return
+	}
+	tm := m.clock.NewTimer(d)
+	defer tm.Stop()
+	<-tm.C()
+}
+
+// EffectiveTimeout returns the timeout that will be used for the given stage.
+//
+// The timeout set for the stage by SetTimeoutN is used if there is one,
+// otherwise the timeout set by SetTimeout.
+func EffectiveTimeout(s Stage) time.Duration {
+	return defaultManager.EffectiveTimeout(s)
+}
+
+// EffectiveTimeout returns the timeout that will be used for the given stage of the manager.
+func (m *Manager) EffectiveTimeout(s Stage) time.Duration {
+	m.srM.RLock()
+	defer m.srM.RUnlock()
+	return m.effectiveTimeout(s.n)
+}
+
+// effectiveTimeout returns the timeout of a stage.
+// m.srM must be held.
+func (m *Manager) effectiveTimeout(stage int) time.Duration {
+	if m.timeoutsDisabled {
+		return noTimeout
+	}
+	if d := m.timeouts[stage]; d > 0 {
+		return d
+	}
+	return m.timeout
+}
+
+// noTimeout is used as the timeout of all stages when timeouts are disabled.
+// It is low enough that adding it to the monotonic time cannot overflow.
+const noTimeout = time.Duration(1 << 60)
+
+// SetTimeoutsDisabled disables all timeouts when set to true.
+//
+// Stages, including the wait for locks, will then wait for their
+// notifiers forever, and no warnings about slow notifiers are logged.
+// This is intended for stepping through shutdown code in a debugger,
+// and must not be used in production. A warning is logged when
+// it is enabled and when shutdown starts, and it is reported by Stats.
+//
+// Timeouts can also be disabled by setting the environment variable
+// SHUTDOWN_NO_TIMEOUT=1, which is read when the first manager is created.
+func SetTimeoutsDisabled(disabled bool) {
+	defaultManager.SetTimeoutsDisabled(disabled)
+}
+
+// SetTimeoutsDisabled disables all timeouts of the manager when set to true.
+func (m *Manager) SetTimeoutsDisabled(disabled bool) {
+	if disabled {
+		Logger.Println("WARNING: shutdown timeouts are disabled, shutdown may hang forever")
+	}
+	m.srM.Lock()
+	m.timeoutsDisabled = disabled
+	m.srM.Unlock()
+}
+
+var (
+	noTimeoutEnvOnce sync.Once
+	noTimeoutEnv     bool
+)
+
+// timeoutsDisabledByEnv returns true if timeouts are disabled by the environment.
+// The environment is only read once.
+func timeoutsDisabledByEnv() bool {
+	noTimeoutEnvOnce.Do(func() {
+		noTimeoutEnv = os.Getenv("SHUTDOWN_NO_TIMEOUT") == "1"
+	})
+	return noTimeoutEnv
+}
+
+// validTimeout returns true if d can be used as a stage timeout.
+// Otherwise a warning is logged.
+func validTimeout(caller string, d time.Duration) bool {
+	if d <= 0 {
+		Logger.Printf("%s: ignoring invalid timeout %v", caller, d)
+		return false
+	}
+	return true
 }
 
 // Cancel a Notifier.
 // This will remove a notifier from the shutdown queue,
 // and it will not be signalled when shutdown starts.
+// The notifier channel is closed, so goroutines waiting for
+// the notifier will receive a nil channel. Use Cancelled to check
+// if a notifier has been cancelled.
 // If the shutdown has already started this will not have any effect.
 func (s *Notifier) Cancel() {
-	srM.RLock()
-	if shutdownRequested {
-		srM.RUnlock()
+	for _, m := range ownersOf(*s) {
+		if fn := m.cancel(*s); fn != nil {
+			fn()
+		}
+	}
+}
+
+// Cancelled returns true if the notifier has been cancelled.
+func (s Notifier) Cancelled() bool {
+	nM.Lock()
+	defer nM.Unlock()
+	if ns := notifiers[s]; ns != nil {
+		return ns.cancelled
+	}
+	// Cancelled notifiers are forgotten when they are closed.
+	// A signalled notifier is open, or holds its notification,
+	// and nothing is sent to a notifier that isn't registered.
+	if len(s) > 0 {
+		return false
+	}
+	select {
+	case _, ok := <-s:
+		return !ok
+	default:
+		return false
+	}
+}
+
+// OnCancel sets a function that is called when the notifier is cancelled,
+// see Cancel. This can be used to release resources that belong to the
+// notifier, like a registration with another service.
+//
+// The function is called synchronously by Cancel. If the notifier has
+// already been cancelled or signalled, or is unknown, fn is called
+// immediately. Only one function is kept; calling OnCancel again replaces it.
+func (s Notifier) OnCancel(fn func()) {
+	nM.Lock()
+	ns := notifiers[s]
+	if ns != nil && !ns.fired && !ns.cancelled {
+		ns.onCancel = fn
+		nM.Unlock()
 		return
 	}
-	srM.RUnlock()
-	sqM.Lock()
+	nM.Unlock()
+	fn()
+}
+
+// Peek returns true if a notification is waiting to be received
+// from the notifier, without receiving it.
+// It returns false when the notification has been received,
+// or if the notifier has been cancelled.
+func (s Notifier) Peek() bool {
+	// Notifiers are buffered, so a sent notification is kept in the buffer.
+	return len(s) > 0
+}
+
+// String returns a description of the notifier, for logging.
+func (s Notifier) String() string {
+	nM.Lock()
+	defer nM.Unlock()
+	ns := notifiers[s]
+	if ns == nil {
+		return "Notifier{active:false}"
+	}
+	active := !ns.fired && !ns.cancelled
+	return fmt.Sprintf("Notifier{stage:%d, id:%q, active:%t}", ns.stage, fmt.Sprintf("%s#%d", stageName(ns.stage), ns.id), active)
+}
+
+// ID returns the id of the notifier.
+//
+// Ids are unique within the process and never reused,
+// so they can be stored instead of the notifier,
+// and used with CancelByID and NotifierByID.
+// If the notifier is no longer known, for instance because
+// it has been cancelled, 0 is returned.
+func (s Notifier) ID() uint64 {
+	nM.Lock()
+	defer nM.Unlock()
+	ns := notifiers[s]
+	if ns == nil {
+		return 0
+	}
+	return ns.id
+}
+
+// NotifierByID returns the notifier with the given id.
+// If no notifier with the id is known, false is returned.
+func NotifierByID(id uint64) (Notifier, bool) {
+	nM.Lock()
+	defer nM.Unlock()
+	n, ok := notifierIDs[id]
+	return n, ok
+}
+
+// CancelByID cancels the notifier with the given id. See Notifier.Cancel.
+// If no notifier with the id is known, false is returned.
+func CancelByID(id uint64) bool {
+	n, ok := NotifierByID(id)
+	if !ok {
+		return false
+	}
+	n.Cancel()
+	return true
+}
+
+// describe returns the id of the notifier, and the call site, if known.
+func describe(n Notifier) string {
+	nM.Lock()
+	defer nM.Unlock()
+	ns := notifiers[n]
+	if ns == nil {
+		return "id 0"
+	}
+	if ns.callSite != "" {
+		return fmt.Sprintf("id %d (%s)", ns.id, ns.callSite)
+	}
+	return fmt.Sprintf("id %d", ns.id)
+}
+
+// stageName returns the name of a stage.
+func stageName(stage int) string {
+	switch stage {
+	case 0:
+		return "preshutdown"
+	case 1:
+		return "first"
+	case 2:
+		return "second"
+	case 3:
+		return "third"
+	case 4:
+		return "readonly"
+	}
+	return fmt.Sprintf("stage%d", stage)
+}
+
+// Expired returns a channel that is closed when the shutdown
+// is no longer waiting for the notifier.
+//
+// When the stage of the notifier times out, the shutdown proceeds to
+// the next stage. Code that has received from the notifier can use this
+// to abort its work, since later stages may already have released what
+// it relies on. The channel is also closed when the stage has finished normally.
+func (s Notifier) Expired() <-chan struct{} {
+	nM.Lock()
+	defer nM.Unlock()
+	ns := notifiers[s]
+	if ns == nil {
+		return closedChan
+	}
+	return ns.expired
+}
+
+// closedChan is a closed channel.
+var closedChan = make(chan struct{})
+
+func init() {
+	close(closedChan)
+}
+
+// UnblockAfter will cancel the notifier after d, unless it has been signalled
+// by then. This will unblock goroutines waiting for the notifier, even if
+// shutdown is never started, so they do not leak.
+//
+// Like with Cancel, goroutines waiting for the notifier will receive
+// a nil channel, and Cancelled will return true.
+func (s Notifier) UnblockAfter(d time.Duration) {
+	time.AfterFunc(d, func() {
+		s.Cancel()
+		if fn := closeCancelled(s); fn != nil {
+			fn()
+		}
+	})
+}
+
+// cancel will remove a notifier from the queues of the manager.
+// It does nothing if shutdown of the manager has started
+// or the notifier wasn't found.
+// If the notifier was closed, its OnCancel function is returned,
+// see releaseCancelled.
+func (m *Manager) cancel(s Notifier) (onCancel func()) {
+	m.srM.RLock()
+	if m.shutdownRequested {
+		m.srM.RUnlock()
+		return nil
+	}
+	m.srM.RUnlock()
+	m.sqM.Lock()
+	defer m.sqM.Unlock()
 	var a chan chan struct{}
 	var b chan chan struct{}
-	a = *s
-	for n := 0; n < len(shutdownQueue); n++ {
-		for i := range shutdownQueue[n] {
-			b = shutdownQueue[n][i]
+	a = s
+	for n := 0; n < len(m.shutdownQueue); n++ {
+		for i := range m.shutdownQueue[n] {
+			b = m.shutdownQueue[n][i]
 			if a == b {
-				shutdownQueue[n] = append(shutdownQueue[n][:i], shutdownQueue[n][i+1:]...)
+				m.shutdownQueue[n] = append(m.shutdownQueue[n][:i], m.shutdownQueue[n][i+1:]...)
+				return releaseCancelled(s, m)
 			}
 		}
-		for i, fn := range shutdownFnQueue[n] {
+		for i, fn := range m.shutdownFnQueue[n] {
 			b = fn.client
 			if a == b {
 				// Find the matching internal and remove that.
-				for i := range shutdownQueue[n] {
-					b = shutdownQueue[n][i]
+				for i := range m.shutdownQueue[n] {
+					b = m.shutdownQueue[n][i]
 					if fn.internal == b {
-						shutdownQueue[n] = append(shutdownQueue[n][:i], shutdownQueue[n][i+1:]...)
+						m.shutdownQueue[n] = append(m.shutdownQueue[n][:i], m.shutdownQueue[n][i+1:]...)
+						break
 					}
 				}
 				// Cancel, so the goroutine exits.
-				close(fn.cancel)
+				// A merged manager may already have done so.
+				select {
+				case <-fn.cancel:
+				default:
+					close(fn.cancel)
+				}
 				// Remove this
-				shutdownFnQueue[n] = append(shutdownFnQueue[n][:i], shutdownFnQueue[n][i+1:]...)
+				m.shutdownFnQueue[n] = append(m.shutdownFnQueue[n][:i], m.shutdownFnQueue[n][i+1:]...)
+				release(fn.internal, m)
+				return releaseCancelled(fn.client, m)
 			}
 		}
 	}
-	sqM.Unlock()
+	return nil
 }
 
 // PreShutdown will return a Notifier that will be fired as soon as the shutdown
 // is signalled, before locks are released.
 // This allows to for instance send signals to upstream servers not to send more requests.
 func PreShutdown() Notifier {
-	return onShutdown(0)
+	return defaultManager.PreShutdown()
+}
+
+// PreShutdown will return a Notifier that will be fired as soon as the shutdown
+// of the manager is signalled, before locks are released.
+func (m *Manager) PreShutdown() Notifier {
+	return m.onShutdown(0)
 }
 
 type ShutdownFn func(interface{})
@@ -124,182 +509,971 @@ type ShutdownFn func(interface{})
 // is signalled, before locks are released.
 // This allows to for instance send signals to upstream servers not to send more requests.
 func PreShutdownFunc(fn ShutdownFn, v interface{}) Notifier {
-	return onFunc(0, fn, v)
+	return defaultManager.PreShutdownFunc(fn, v)
+}
+
+// PreShutdownFunc registers a function that will be called as soon as the shutdown
+// of the manager is signalled, before locks are released.
+func (m *Manager) PreShutdownFunc(fn ShutdownFn, v interface{}) Notifier {
+	return m.onFunc(0, fn, v)
+}
+
+// ReadOnly returns a notifier that will be called after PreShutdown
+// notifiers have finished and locks have been released, but before the first stage.
+// This is the place to stop accepting writes while still serving reads,
+// for instance by setting a read-only flag.
+func ReadOnly() Notifier {
+	return defaultManager.ReadOnly()
+}
+
+// ReadOnly returns a notifier that will be called in the read-only stage of the manager.
+func (m *Manager) ReadOnly() Notifier {
+	return m.onShutdown(4)
+}
+
+// ReadOnlyFunc executes a function after PreShutdown notifiers have finished
+// and locks have been released, but before the first stage.
+// This is the place to stop accepting writes while still serving reads.
+func ReadOnlyFunc(fn ShutdownFn, v interface{}) Notifier {
+	return defaultManager.ReadOnlyFunc(fn, v)
+}
+
+// ReadOnlyFunc executes a function in the read-only stage of the manager.
+func (m *Manager) ReadOnlyFunc(fn ShutdownFn, v interface{}) Notifier {
+	return m.onFunc(4, fn, v)
 }
 
 // First returns a notifier that will be called in the first stage of shutdowns
 func First() Notifier {
-	return onShutdown(1)
+	return defaultManager.First()
+}
+
+// First returns a notifier that will be called in the first stage of shutdowns of the manager
+func (m *Manager) First() Notifier {
+	return m.onShutdown(1)
 }
 
 // FirstFunc executes a function in the first stage of the shutdown
 func FirstFunc(fn ShutdownFn, v interface{}) Notifier {
-	return onFunc(1, fn, v)
+	return defaultManager.FirstFunc(fn, v)
+}
+
+// FirstFunc executes a function in the first stage of the shutdown of the manager
+func (m *Manager) FirstFunc(fn ShutdownFn, v interface{}) Notifier {
+	return m.onFunc(1, fn, v)
 }
 
 // Second returns a notifier that will be called in the second stage of shutdowns
 func Second() Notifier {
-	return onShutdown(2)
+	return defaultManager.Second()
+}
+
+// Second returns a notifier that will be called in the second stage of shutdowns of the manager
+func (m *Manager) Second() Notifier {
+	return m.onShutdown(2)
 }
 
 // SecondFunc executes a function in the second stage of the shutdown
 func SecondFunc(fn ShutdownFn, v interface{}) Notifier {
-	return onFunc(2, fn, v)
+	return defaultManager.SecondFunc(fn, v)
+}
+
+// SecondFunc executes a function in the second stage of the shutdown of the manager
+func (m *Manager) SecondFunc(fn ShutdownFn, v interface{}) Notifier {
+	return m.onFunc(2, fn, v)
 }
 
 // Third returns a notifier that will be called in the third stage of shutdowns
 func Third() Notifier {
-	return onShutdown(3)
+	return defaultManager.Third()
+}
+
+// Third returns a notifier that will be called in the third stage of shutdowns of the manager
+func (m *Manager) Third() Notifier {
+	return m.onShutdown(3)
 }
 
 // ThirdFunc executes a function in the third stage of the shutdown
 // The returned Notifier is only really useful for cancelling the shutdown function
 func ThirdFunc(fn ShutdownFn, v interface{}) Notifier {
-	return onFunc(3, fn, v)
+	return defaultManager.ThirdFunc(fn, v)
+}
+
+// ThirdFunc executes a function in the third stage of the shutdown of the manager
+func (m *Manager) ThirdFunc(fn ShutdownFn, v interface{}) Notifier {
+	return m.onFunc(3, fn, v)
 }
 
 // Create a function notifier.
-func onFunc(prio int, fn ShutdownFn, i interface{}) Notifier {
+// A nil function panics, so misuse is caught where the function is given.
+func (m *Manager) onFunc(prio int, fn ShutdownFn, i interface{}) Notifier {
+	if fn == nil {
+		panic("shutdown: nil shutdown function")
+	}
+	if !m.addWatcher() {
+		return spentNotifier
+	}
 	f := fnNotify{
-		internal: onShutdown(prio),
+		internal: make(Notifier, 1),
 		cancel:   make(chan struct{}),
 		client:   make(Notifier, 1),
 	}
+	val := &fnValue{v: i}
 	go func() {
 		select {
 		case <-f.cancel:
+			m.watchers.Done()
 			return
 		case c := <-f.internal:
+			// The function is no longer waited for by Close.
+			m.watchers.Done()
 			{
 				defer func() {
 					if r := recover(); r != nil {
-						Logger.Println("Panic in shutdown function:", r)
+						Logger.Printf("Panic in shutdown function %s: %v", describe(f.client), r)
+						m.srM.Lock()
+						m.panics++
+						m.srM.Unlock()
 					}
 					if c != nil {
 						close(c)
 					}
 				}()
-				fn(i)
+				defer m.trackRunning(f.client)()
+				if !isInternal(f.client) {
+					// See SetCrashBreadcrumbs.
+					defer m.breadcrumb(func() string {
+						return fmt.Sprintf("%s stage notifier %s, function %s", stageName(prio), describe(f.client), funcName(fn))
+					})()
+				}
+				m.intercept(prio, f.client, fn, val.take())
 			}
 		}
 	}()
-	sqM.Lock()
-	shutdownFnQueue[prio] = append(shutdownFnQueue[prio], f)
-	sqM.Unlock()
+	m.sqM.Lock()
+	if m.isDisabled() {
+		m.sqM.Unlock()
+		// Stop the goroutine waiting to call the function.
+		close(f.cancel)
+		return spentNotifier
+	}
+	m.enqueue(prio, f.internal)
+	m.shutdownFnQueue[prio] = append(m.shutdownFnQueue[prio], f)
+	register(f.client, m, prio)
+	setFnValue(f.client, val)
+	m.sqM.Unlock()
 	return f.client
 }
 
 // onShutdown will request a shutdown notifier.
-func onShutdown(prio int) Notifier {
-	sqM.Lock()
+func (m *Manager) onShutdown(prio int) Notifier {
 	n := make(Notifier, 1)
-	shutdownQueue[prio] = append(shutdownQueue[prio], n)
-	sqM.Unlock()
+	m.sqM.Lock()
+	defer m.sqM.Unlock()
+	if m.isDisabled() {
+		return spentNotifier
+	}
+	m.enqueue(prio, n)
 	return n
 }
 
+// enqueue adds n to the queue of the stage and registers it.
+// Notifiers are registered under sqM, so the order of the queues
+// and the order of the ids always match the registration order.
+// m.sqM must be held.
+func (m *Manager) enqueue(prio int, n Notifier) {
+	m.shutdownQueue[prio] = append(m.shutdownQueue[prio], n)
+	register(n, m, prio)
+}
+
 // OnSignal will start the shutdown when any of the given signals arrive
 //
 // A good shutdown default is
 //    shutdown.OnSignal(0, os.Interrupt, syscall.SIGTERM)
 // which will do shutdown on Ctrl+C and when the program is terminated.
 func OnSignal(exitCode int, sig ...os.Signal) {
+	defaultManager.OnSignal(exitCode, sig...)
+}
+
+// OnSignal will start the shutdown of the manager when any of the given signals arrive.
+func (m *Manager) OnSignal(exitCode int, sig ...os.Signal) {
 	// capture signal and shut down.
+	m.notifySignals(exitCode, -1, sig)
+}
+
+// OnSignalWaitStage will start the shutdown when any of the given signals
+// arrive, like OnSignal, but exits as soon as the given stage has completed.
+//
+// The exit never happens before the stage has completed, or timed out,
+// so the cleanup of the stage is done, even if the later stages are slow.
+// These keep running until the application exits. If the stage doesn't
+// run, for instance because it has nothing registered, the exit happens
+// when the stages before it have completed, or at the latest when
+// shutdown has completed.
+func OnSignalWaitStage(exitCode int, s Stage, sig ...os.Signal) {
+	defaultManager.OnSignalWaitStage(exitCode, s, sig...)
+}
+
+// OnSignalWaitStage will start the shutdown of the manager when any of the given signals arrive,
+// and exit when the given stage has completed.
+func (m *Manager) OnSignalWaitStage(exitCode int, s Stage, sig ...os.Signal) {
+	m.notifySignals(exitCode, s.n, sig)
+}
+
+// notifySignals starts a goroutine handling the signals, see handleSignals.
+// The goroutine returns when the manager is closed, see Close.
+func (m *Manager) notifySignals(exitCode int, stage int, sig []os.Signal) {
+	registerThisCopy()
+	m.srM.Lock()
+	defer m.srM.Unlock()
+	if m.isClosed() {
+		return
+	}
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, sig...)
+	m.signals = append(m.signals, c)
+	m.watchers.Add(1)
 	go func() {
-		for _ = range c {
-			Shutdown()
-			os.Exit(exitCode)
-		}
+		defer m.watchers.Done()
+		m.handleSignals(c, exitCode, stage)
 	}()
 }
 
+// handleSignals starts shutdown when a signal arrives on c, and exits
+// when shutdown has completed, or when the stage has, if it isn't -1.
+func (m *Manager) handleSignals(c <-chan os.Signal, exitCode int, stage int) {
+	for s := range c {
+		r := Reason{Cause: "signal: " + s.String(), Signal: s}
+		if stage < 0 {
+			// Don't run user code on this goroutine, see ShutdownAsync.
+			<-m.shutdownAsync(r, func() {
+				m.exit(exitCode)
+			})
+			continue
+		}
+		finished := m.stageFinished(stage)
+		done := m.shutdownAsync(r, nil)
+		exited := make(chan struct{})
+		go func() {
+			defer close(exited)
+			select {
+			case <-finished:
+			case <-done:
+			}
+			m.exit(exitCode)
+		}()
+		<-exited
+	}
+}
+
 // Exit performs shutdown operations and exits with the given exit code.
 func Exit(code int) {
-	Shutdown()
-	os.Exit(code)
+	defaultManager.Exit(code)
+}
+
+// Exit performs shutdown operations of the manager and exits with the given exit code.
+func (m *Manager) Exit(code int) {
+	m.shutdown(Reason{Cause: fmt.Sprintf("Exit(%d) called", code)})
+	m.exit(code)
 }
 
 // Shutdown will signal all notifiers in three stages.
 // It will first check that all locks have been released - see Lock()
+//
+// Only the first call will start the shutdown. If shutdown has already
+// been started, the call will wait for the running shutdown to complete.
 func Shutdown() {
-	srM.Lock()
-	shutdownRequested = true
-	srM.Unlock()
+	defaultManager.Shutdown()
+}
+
+// Shutdown will signal all notifiers of the manager in three stages.
+// It will first check that all locks of the manager have been released.
+func (m *Manager) Shutdown() {
+	m.shutdown(Reason{Cause: "Shutdown called"})
+}
+
+// shutdown runs the shutdown sequence, unless it has already been started.
+// In that case the trigger is coalesced with the running shutdown,
+// and we wait for it to complete.
+func (m *Manager) shutdown(r Reason) {
+	if m.isDisabled() {
+		return
+	}
+	m.srM.Lock()
+	if m.isClosed() {
+		// Closed after the check above.
+		m.srM.Unlock()
+		return
+	}
+	if m.shutdownRequested {
+		m.coalesced++
+		first := m.reason
+		log := m.clock.Mono()-m.startedMono >= m.debounce
+		done := m.done
+		m.srM.Unlock()
+		if log {
+			Logger.Printf("Shutdown already in progress (%s), ignoring: %s", first.Cause, r.Cause)
+		}
+		<-done
+		return
+	}
+	m.shutdownRequested = true
+	m.cycles++
+	r.Time = m.clock.Now()
+	m.startedMono = m.clock.Mono()
+	r.Stack = string(debug.Stack())
+	m.reason = r
+	noTimeouts := m.timeoutsDisabled
+	m.srM.Unlock()
+	m.emitProgress("shutdown started: %s", r.Cause)
+	if noTimeouts {
+		Logger.Println("WARNING: shutdown timeouts are disabled, shutdown may hang forever")
+	}
+	defer close(m.done)
+	m.forgetCompletions()
+	m.timings = []callbackTime{}
+	m.identities = make(map[string]callIdentity)
+	stopProfile := m.startProfile()
+	defer stopProfile()
+	m.closeLocks()
+	// Nothing is registered, see OnAllDrained.
+	drained := !m.registeredAfter(-1)
+	if drained {
+		m.allDrained()
+	}
 
 	// Add a pre-shutdown function that waits for all locks to be released.
-	PreShutdownFunc(func(interface{}) {
-		srM.Lock()
-		wait := wg
-		srM.Unlock()
-		wait.Wait()
+	var drain Notifier
+	drain = m.PreShutdownFunc(func(interface{}) {
+		select {
+		case <-m.locks.drained:
+		case <-drain.Expired():
+			m.logLocks()
+		}
 	}, nil)
+	markInternal(drain)
 
-	sqM.Lock()
-	for stage := 0; stage < 4; stage++ {
-		srM.Lock()
-		to := timeouts[stage]
-		srM.Unlock()
-
-		queue := shutdownQueue[stage]
-		if len(queue) == 0 {
+	var stages []StageSummary
+	var starts []time.Duration // Monotonic start of each stage, for WriteTrace.
+	m.sqM.Lock()
+	for pos := 0; pos < numStages; {
+		// Stages may run concurrently, see SetParallelStages.
+		n := m.groupLen(pos)
+		var active []*activeStage
+		for p := pos; p < pos+n; p++ {
+			a, skipped := m.startStage(p, r, drain)
+			if skipped {
+				stages = append(stages, StageSummary{Stage: a.stage, Skipped: true})
+				starts = append(starts, m.clock.Mono())
+				continue
+			}
+			if a != nil {
+				active = append(active, a)
+			}
+		}
+		pos += n
+		if len(active) == 0 {
+			m.finishPositions(pos-n, n)
 			continue
 		}
-		if stage == 0 {
-			Logger.Println("Initiating shutdown")
-		} else {
-			Logger.Println("Shutdown stage", stage)
+
+		// We don't lock while we are waiting for notifiers to return
+		m.sqM.Unlock()
+		m.waitStages(active)
+		if !drained && !m.registeredAfter(pos-1) {
+			drained = true
+			m.allDrained()
 		}
-		wait := make([]chan struct{}, len(queue))
+		for _, a := range active {
+			if a.ok {
+				m.waitUntil(a.start + a.minDur)
+				if min := a.start + a.minDur; a.end < min {
+					a.end = min
+				}
+			}
+			m.finishStage(a)
+			stages = append(stages, StageSummary{Stage: a.stage, Duration: a.end - a.start, TimedOut: !a.ok})
+			starts = append(starts, a.start)
+		}
+		m.finishPositions(pos-n, n)
+		m.sqM.Lock()
+	}
+	// Reset - mainly for tests.
+	for stage := range m.shutdownQueue {
+		for _, n := range m.shutdownQueue[stage] {
+			// Skipped or abandoned, see AwaitCompletion.
+			m.complete(m.client(stage, n), ErrNotifierNotRun)
+		}
+		for _, n := range m.shutdownQueue[stage] {
+			release(n, m)
+		}
+		for _, fn := range m.shutdownFnQueue[stage] {
+			release(fn.client, m)
+			if len(ownersOf(fn.client)) > 0 {
+				// Still queued with a merged manager.
+				continue
+			}
+			// Stop the goroutine of functions that were not called,
+			// like functions registered for a stage that had already run.
+			select {
+			case <-fn.cancel:
+			default:
+				close(fn.cancel)
+			}
+		}
+	}
+	m.shutdownQueue = [numStages][]Notifier{}
+	m.shutdownFnQueue = [numStages][]fnNotify{}
+	m.sqM.Unlock()
+	if !drained {
+		// The last registrations were in stages that were skipped.
+		m.allDrained()
+	}
+	m.summarize(stages)
+	m.saveTrace(stages, starts)
+	m.writeRecording(stages, starts)
+}
+
+// finishPositions marks the n stages from position pos in the order as completed.
+func (m *Manager) finishPositions(pos, n int) {
+	m.srM.Lock()
+	defer m.srM.Unlock()
+	now := m.clock.Now()
+	for p := pos; p < pos+n; p++ {
+		stage := m.order[p]
+		close(m.stageDone[stage])
+		if m.progress[stage].state == "" {
+			// There was nothing to wait for.
+			m.progress[stage] = stageProgress{state: StageDone, start: now, end: now}
+		}
+	}
+}
+
+// stageFinished returns a channel that is closed when the stage
+// of the running or next shutdown has completed, or was skipped.
+func (m *Manager) stageFinished(stage int) <-chan struct{} {
+	m.srM.RLock()
+	defer m.srM.RUnlock()
+	return m.stageDone[stage]
+}
+
+// activeStage is a stage that has been signalled, and is waited for.
+type activeStage struct {
+	stage       int
+	minDur      time.Duration
+	labels      []string
+	clients     []Notifier // See AwaitCompletion.
+	wait        []chan struct{}
+	critical    []bool
+	delays      []time.Duration // See Notifier.WithArtificialDelay.
+	timeouts    []time.Duration // See FirstFuncTimeout.
+	stopWaiters chan struct{}   // Closed when finished, nil if no notifier waits for a milestone.
+	signalled   []Notifier
+	stopReverse func() []Notifier // Set when the stage runs LIFO, see SetStageLIFO.
 
-		// Send notification to all waiting
+	// Set when the stage has been waited for.
+	start, end time.Duration
+	pending    []string
+	ok         bool
+}
+
+// startStage signals the notifiers of the stage at position pos in the order.
+// It returns nil if the stage has nothing to wait for. If the stage was
+// skipped by its predicate, skipped is true, and only a.stage is set.
+// m.sqM must be held.
+func (m *Manager) startStage(pos int, r Reason, drain Notifier) (a *activeStage, skipped bool) {
+	m.srM.Lock()
+	// The order may be changed while we run, see RemapStages.
+	stage := m.order[pos]
+	to := m.effectiveTimeout(stage)
+	minDur := m.minDurations[stage]
+	bufSize := m.notifierBuffer
+	lifo := m.lifo[stage]
+	m.current = pos
+	m.srM.Unlock()
+	if minDur > to {
+		minDur = to
+	}
+
+	queue := m.shutdownQueue[stage]
+	chaos := m.chaosTimeout(stage)
+	if len(queue) == 0 && !chaos && minDur == 0 {
+		return nil, false
+	}
+	// The predicate may register notifiers, so don't hold the lock.
+	m.sqM.Unlock()
+	run := m.runStage(stage, r)
+	m.sqM.Lock()
+	if !run {
+		Logger.Printf("Skipping %s stage", stageName(stage))
+		m.skipStage(stage)
+		m.setStageState(stage, StageSkipped)
+		return &activeStage{stage: stage}, true
+	}
+	queue = m.shutdownQueue[stage]
+	switch stage {
+	case 0:
+		Logger.Println("Initiating shutdown")
+	case 4:
+		Logger.Println("Shutdown read-only stage")
+	default:
+		Logger.Println("Shutdown stage", stage)
+	}
+	labels := m.labels(stage, drain)
+	if m.hardTimedOut() {
+		Logger.Printf("Hard timeout expired, only signalling critical notifiers of %s stage", stageName(stage))
+		queue, labels = m.abandon(stage, labels)
+	}
+	m.recordIdentities(stage, queue, labels)
+	wait := make([]chan struct{}, len(queue))
+
+	// Record when this stage times out, so context functions can use it.
+	m.srM.Lock()
+	m.stageDeadline[stage] = m.clock.Mono() + to
+	m.srM.Unlock()
+	if chaos {
+		// Wait for something that never finishes, so the stage times out.
+		wait = append(wait, make(chan struct{}))
+		labels = append(labels, "chaos")
+	}
+	a = &activeStage{stage: stage, minDur: minDur, labels: labels, wait: wait}
+	m.setStageState(stage, StageRunning)
+	m.cancelPools(stage)
+	a.clients = make([]Notifier, len(wait))
+	a.critical = make([]bool, len(wait))
+	a.delays = make([]time.Duration, len(wait))
+	a.timeouts = make([]time.Duration, len(wait))
+	for i, n := range queue {
+		a.clients[i] = m.client(stage, n)
+		a.critical[i] = m.critical(stage, n)
+		a.delays[i] = m.artificialDelay(stage, n)
+		a.timeouts[i] = m.funcTimeout(stage, n)
+	}
+
+	// Send notification to all waiting
+	if lifo {
+		// Signal one at a time, see SetStageLIFO.
 		for i := range queue {
 			wait[i] = make(chan struct{})
-			queue[i] <- wait[i]
 		}
+		queue := append([]Notifier(nil), queue...)
+		fns := append([]fnNotify(nil), m.shutdownFnQueue[stage]...)
+		a.stopReverse = m.signalReverse(stage, queue, fns, wait, bufSize)
+	} else {
+		for i := range queue {
+			wait[i] = make(chan struct{}, bufSize)
+			if !fire(queue[i]) {
+				// Already signalled by a merged manager.
+				close(wait[i])
+				continue
+			}
+			m.throttle()
+			n, w := queue[i], wait[i]
+			client := m.client(stage, n)
+			signal := func() { m.signalThrough(stage, client, func() { n <- w }) }
+			if ms := m.milestonesOf(stage, n); len(ms) > 0 {
+				// Signalled when the milestones are reached, see Notifier.WaitFor.
+				m.signalAfter(a, labels[i], n, ms, signal)
+			} else {
+				signal()
+			}
+			a.signalled = append(a.signalled, queue[i])
+		}
+	}
 
-		// Send notification to all function notifiers, but don't wait
-		for _, notifier := range shutdownFnQueue[stage] {
-			notifier.client <- make(chan struct{})
-			close(notifier.client)
+	// Send notification to all function notifiers, but don't wait
+	for _, notifier := range m.shutdownFnQueue[stage] {
+		if !fire(notifier.client) {
+			continue
 		}
+		notifier.client <- make(chan struct{}, bufSize)
+		close(notifier.client)
+		a.signalled = append(a.signalled, notifier.client)
+	}
+	return a, false
+}
 
-		// We don't lock while we are waiting for notifiers to return
-		sqM.Unlock()
+// finishStage tells the notifiers of a stage that has been waited for
+// that we no longer wait for them, and handles a timeout of the stage.
+func (m *Manager) finishStage(a *activeStage) {
+	if a.ok {
+		m.setStageState(a.stage, StageDone)
+	} else {
+		m.setStageState(a.stage, StageTimedOut)
+	}
+	if a.stopReverse != nil {
+		a.signalled = append(a.signalled, a.stopReverse()...)
+	}
+	if a.stopWaiters != nil {
+		close(a.stopWaiters)
+	}
+	// Tell the notifiers we are no longer waiting for them.
+	for _, n := range a.signalled {
+		expire(n)
+	}
+	if !a.ok {
+		m.degrade(a.stage, a.pending)
+	}
+	if a.stage == 0 {
+		// Locks have been released, so domains can shut down.
+		// This is the only place domains are shut down by the manager.
+		// The Preshutdown stage always runs, since the lock drain is
+		// registered with it, and it can't be skipped, see SetStagePredicate.
+		m.shutdownDomains()
+	}
+}
 
-		// Wait for all to return, no more than the shutdown delay
-		timeout := time.After(to)
+// Initial and maximum interval between warnings about
+// notifiers that haven't finished.
+const (
+	warnInterval    = time.Second
+	maxWarnInterval = 30 * time.Second
+)
 
-		for i := range wait {
+// waitStage waits for all notifiers of a stage to finish or the stage to time out.
+// It returns false if the stage timed out,
+// and the labels of the notifiers that hadn't finished.
+// When the hard timeout expires, only critical notifiers are waited for,
+// until the critical ceiling, see SetHardTimeout.
+//
+// While waiting, notifiers that haven't finished are logged.
+// To avoid flooding the log the interval between warnings is doubled
+// every time, and when a notifier we have warned about finishes,
+// a single line with the total wait is logged.
+//
+// Notifiers with a timeout of their own, see FirstFuncTimeout, are
+// no longer waited for when it expires. This doesn't make the stage time out.
+func (m *Manager) waitStage(stage int, labels []string, clients []Notifier, wait []chan struct{}, critical []bool, delays, timeouts []time.Duration) (unfinished []string, ok bool) {
+	start := m.clock.Mono()
+	m.srM.RLock()
+	logComplete := m.logOnComplete
+	interval := warnInterval
+	if m.timeoutsDisabled {
+		interval = noTimeout
+	}
+	m.srM.RUnlock()
+	done := make(chan int, len(wait))
+	// Notifiers that are no longer waited for, see FirstFuncTimeout.
+	cut := make(chan int, len(wait))
+	stop := make(chan struct{})
+	defer close(stop)
+	for i := range wait {
+		delay := m.chaosDelay()
+		go func(i int) {
+			// Only used if the notifier has a timeout.
+			var limit <-chan time.Time
+			if timeouts[i] > 0 {
+				t := m.clock.NewTimer(timeouts[i])
+				defer t.Stop()
+				limit = t.C()
+			}
+			if delays[i] > 0 {
+				t := m.clock.NewTimer(delays[i])
+				defer t.Stop()
+				select {
+				case <-t.C():
+				case <-limit:
+					cut <- i
+					return
+				case <-stop:
+					return
+				}
+			}
 			select {
 			case <-wait[i]:
-			case <-timeout:
-				Logger.Println("timeout waiting to shutdown, forcing shutdown")
-				break
+			case <-limit:
+				cut <- i
+				return
+			case <-stop:
+				return
 			}
+			if delay > 0 {
+				t := m.clock.NewTimer(delay)
+				defer t.Stop()
+				select {
+				case <-t.C():
+				case <-limit:
+					cut <- i
+					return
+				case <-stop:
+					return
+				}
+			}
+			done <- i
+		}(i)
+	}
+
+	// The deadline may be extended while we wait, see ExtendDrain.
+	timeout := m.clock.NewTimer(m.untilDeadline(stage))
+	defer timeout.Stop()
+	// Only used if there is a hard timeout, see SetHardTimeout.
+	var hard <-chan time.Time
+	hardAt, ceilingAt, hasHard := m.hardDeadlines()
+	if hasHard {
+		t := m.clock.NewTimer(hardAt - m.clock.Mono())
+		defer t.Stop()
+		hard = t.C()
+	}
+	forced := false
+	warn := m.clock.NewTimer(interval)
+	defer warn.Stop()
+
+	finished := make([]bool, len(wait))
+	warned := make([]int, len(wait))
+	m.setWaiting(stage, labels, finished)
+	defer m.setWaiting(stage, nil, nil)
+	for pending := len(wait); pending > 0; {
+		select {
+		case i := <-done:
+			if finished[i] {
+				// Abandoned at the hard timeout.
+				continue
+			}
+			pending--
+			finished[i] = true
+			m.setWaiting(stage, labels, finished)
+			m.recordTiming(stage, labels[i], start, true)
+			m.complete(clients[i], nil)
+			if warned[i] > 0 {
+				Logger.Printf("Stage %d: %s finished after %v, warned %d times", stage, labels[i], m.clock.Mono()-start, warned[i])
+			} else if logComplete {
+				Logger.Printf("Stage %d: %s finished after %v", stage, labels[i], m.clock.Mono()-start)
+			}
+		case i := <-cut:
+			if finished[i] {
+				continue
+			}
+			pending--
+			finished[i] = true
+			m.setWaiting(stage, labels, finished)
+			m.recordTiming(stage, labels[i], start, false)
+			m.complete(clients[i], ErrNotifierTimedOut)
+			Logger.Printf("Stage %d: %s timed out after %v, no longer waiting for it", stage, labels[i], m.clock.Mono()-start)
+		case <-hard:
+			// Stop waiting for notifiers that are not critical.
+			forced = true
+			for i := range wait {
+				if !finished[i] && !critical[i] {
+					finished[i] = true
+					pending--
+					m.recordTiming(stage, labels[i], start, false)
+					m.complete(clients[i], ErrNotifierTimedOut)
+					unfinished = append(unfinished, labels[i])
+				}
+			}
+			if pending == 0 {
+				Logger.Println("hard timeout expired, forcing shutdown")
+				return unfinished, false
+			}
+			Logger.Printf("hard timeout expired, waiting for %d critical notifiers", pending)
+			m.setWaiting(stage, labels, finished)
+			timeout.Reset(ceilingAt - m.clock.Mono())
+		case <-timeout.C():
+			if forced {
+				if remain := ceilingAt - m.clock.Mono(); remain > 0 {
+					timeout.Reset(remain)
+					continue
+				}
+			} else if remain := m.untilDeadline(stage); remain > 0 {
+				timeout.Reset(remain)
+				continue
+			}
+			Logger.Println("timeout waiting to shutdown, forcing shutdown")
+			for i := range wait {
+				if !finished[i] {
+					m.recordTiming(stage, labels[i], start, false)
+					m.complete(clients[i], ErrNotifierTimedOut)
+					unfinished = append(unfinished, labels[i])
+				}
+			}
+			return unfinished, false
+		case <-warn.C():
+			if interval *= 2; interval > maxWarnInterval {
+				interval = maxWarnInterval
+			}
+			warn.Reset(interval)
+			var waiting []string
+			for i := range wait {
+				if !finished[i] {
+					warned[i]++
+					waiting = append(waiting, labels[i])
+				}
+			}
+			Logger.Printf("Stage %d: still waiting after %v for %s", stage, m.clock.Mono()-start, strings.Join(waiting, ", "))
 		}
-		sqM.Lock()
 	}
-	// Reset - mainly for tests.
-	shutdownQueue = [4][]Notifier{}
-	shutdownFnQueue = [4][]fnNotify{}
-	sqM.Unlock()
+	return unfinished, unfinished == nil
+}
+
+// degrade calls the functions set by OnStageTimeout and WithGracefulDegradation
+// after a stage has timed out. pending are the labels of the notifiers
+// that hadn't finished.
+func (m *Manager) degrade(stage int, pending []string) {
+	m.srM.RLock()
+	fn := m.onStageTimeout
+	pendingFn := m.stageTimeoutFns[stage]
+	m.srM.RUnlock()
+	if pendingFn != nil {
+		names := make([]string, len(pending))
+		for i, l := range pending {
+			names[i] = strings.TrimPrefix(l, "notifier ")
+		}
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					Logger.Printf("Panic in timeout function of %s stage: %v", stageName(stage), r)
+				}
+			}()
+			pendingFn(names)
+		}()
+	}
+	if fn == nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			Logger.Println("Panic in graceful degradation function:", r)
+		}
+	}()
+	fn(stage)
+}
+
+// client returns the notifier returned to the caller for an internal
+// function notifier of the stage, or n if it isn't one.
+// m.sqM must be held.
+func (m *Manager) client(stage int, n Notifier) Notifier {
+	for _, fn := range m.shutdownFnQueue[stage] {
+		if fn.internal == n {
+			return fn.client
+		}
+	}
+	return n
+}
+
+// labels returns a description of each notifier in the queue of a stage.
+// m.sqM must be held.
+func (m *Manager) labels(stage int, drain Notifier) []string {
+	queue := m.shutdownQueue[stage]
+	labels := make([]string, len(queue))
+	for i, n := range queue {
+		// Function notifiers are known by the notifier returned to the caller.
+		n = m.client(stage, n)
+		if n == drain {
+			labels[i] = "lock drain"
+			continue
+		}
+		labels[i] = "notifier " + describe(n)
+	}
+	return labels
+}
+
+// ExtendDrain will extend the time shutdown waits for locks to be released
+// and Preshutdown notifiers to finish by d.
+//
+// This can be used when a long running request is known to be in flight,
+// for instance from a PreShutdown function. The total extension is limited
+// by SetMaxDrainExtension. The extension that was granted is returned.
+// If shutdown hasn't been started or the Preshutdown stage has finished,
+// no extension is granted.
+func ExtendDrain(d time.Duration) time.Duration {
+	return defaultManager.ExtendDrain(d)
+}
+
+// ExtendDrain will extend the time shutdown of the manager waits for locks to be released.
+func (m *Manager) ExtendDrain(d time.Duration) time.Duration {
+	m.srM.Lock()
+	defer m.srM.Unlock()
+	if !m.shutdownRequested || m.stageDeadline[0] == 0 || d <= 0 {
+		return 0
+	}
+	if m.progress[0].state != StageRunning {
+		// The Preshutdown stage has finished.
+		return 0
+	}
+	if left := m.maxDrainExtension - m.drainExtended; d > left {
+		d = left
+	}
+	m.stageDeadline[0] += d
+	m.drainExtended += d
+	return d
+}
+
+// SetMaxDrainExtension sets the maximum total time the lock drain
+// can be extended by ExtendDrain. The default is 30 seconds.
+// A negative duration is treated as 0 with a warning.
+func SetMaxDrainExtension(d time.Duration) {
+	defaultManager.SetMaxDrainExtension(d)
+}
+
+// SetMaxDrainExtension sets the maximum total time the lock drain of the manager can be extended.
+func (m *Manager) SetMaxDrainExtension(d time.Duration) {
+	if d < 0 {
+		Logger.Printf("SetMaxDrainExtension: negative duration %v, using 0", d)
+		d = 0
+	}
+	m.srM.Lock()
+	m.maxDrainExtension = d
+	m.srM.Unlock()
+}
+
+// TimeLeft returns the time left before the given stage times out.
+// If the stage hasn't started or has timed out, 0 is returned.
+//
+// Shutdown functions can use this to limit their work.
+// Timeouts are measured with a monotonic clock, so they are not
+// affected by changes to the wall clock.
+func TimeLeft(s Stage) time.Duration {
+	return defaultManager.TimeLeft(s)
+}
+
+// TimeLeft returns the time left before the given stage of the manager times out.
+func (m *Manager) TimeLeft(s Stage) time.Duration {
+	m.srM.RLock()
+	started := m.stageDeadline[s.n] != 0
+	m.srM.RUnlock()
+	if d := m.untilDeadline(s.n); started && d > 0 {
+		return d
+	}
+	return 0
+}
+
+// untilDeadline returns the time left before the given stage times out.
+func (m *Manager) untilDeadline(stage int) time.Duration {
+	m.srM.RLock()
+	deadline := m.stageDeadline[stage]
+	m.srM.RUnlock()
+	return deadline - m.clock.Mono()
+}
+
+// Monotonic returns the number of shutdowns that have been started.
+//
+// The counter starts at 0, and is incremented when a shutdown is started,
+// by Shutdown, Exit, a signal or otherwise. Calls that wait for a running
+// shutdown are not counted. It is not reset by Reset, so tests can check
+// how many shutdowns ran, and it can be used for metrics.
+func Monotonic() uint64 {
+	return defaultManager.Monotonic()
+}
+
+// Monotonic returns the number of shutdowns of the manager that have been started.
+func (m *Manager) Monotonic() uint64 {
+	m.srM.RLock()
+	defer m.srM.RUnlock()
+	return m.cycles
 }
 
 // Started returns true if shutdown has been started.
 // Note that shutdown can have been started before you check the value.
 func Started() bool {
-	srM.RLock()
-	started := shutdownRequested
-	srM.RUnlock()
-	return started
+	return defaultManager.Started()
 }
 
-var wg *sync.WaitGroup
-
-func init() {
-	wg = &sync.WaitGroup{}
+// Started returns true if shutdown of the manager has been started.
+func (m *Manager) Started() bool {
+	m.srM.RLock()
+	started := m.shutdownRequested
+	m.srM.RUnlock()
+	return started
 }
 
 // Lock will signal that you have a function running,
@@ -312,18 +1486,59 @@ func init() {
 //
 // You should not hold a lock when you start a shutdown.
 func Lock() bool {
-	srM.RLock()
-	s := shutdownRequested
-	if !s {
-		wg.Add(1)
+	return defaultManager.Lock()
+}
+
+// Lock will signal that you have a function running,
+// that you do not want to be interrupted by a shutdown of the manager.
+func (m *Manager) Lock() bool {
+	if m.isDisabled() {
+		return true
 	}
-	srM.RUnlock()
-	return !s
+	m.waitQuiesce()
+	return m.locks.tryLock()
 }
 
 // Unlock will release a shutdown lock.
 // This may only be called if you have previously called Lock and it has
 // returned true
 func Unlock() {
-	wg.Done()
+	defaultManager.Unlock()
+}
+
+// Unlock will release a shutdown lock of the manager.
+func (m *Manager) Unlock() {
+	if m.isDisabled() {
+		return
+	}
+	m.locks.unlock()
+}
+
+// BeginWork will signal that you have work running, that you do not
+// want to be interrupted by a shutdown.
+//
+// If ok is false shutdown has already been initiated, and you should
+// not start the work. Otherwise you must call release exactly once
+// when the work has finished.
+//
+// This does the same as checking Started and calling Lock,
+// but as a single atomic operation, so it is cheap to call on every request.
+// A successful call to BeginWork happens before the shutdown proceeds
+// past the Preshutdown stage, unless the Preshutdown timeout expires
+// before release has been called.
+func BeginWork() (release func(), ok bool) {
+	return defaultManager.BeginWork()
+}
+
+// BeginWork will signal that you have work running, that you do not
+// want to be interrupted by a shutdown of the manager.
+func (m *Manager) BeginWork() (release func(), ok bool) {
+	if m.isDisabled() {
+		return func() {}, true
+	}
+	m.waitQuiesce()
+	if !m.locks.tryLock() {
+		return nil, false
+	}
+	return m.release, true
 }
@@ -3,33 +3,32 @@
 package shutdown
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
 func reset() {
+	defaultManager = NewManager()
 	SetTimeout(1 * time.Second)
-	sqM.Lock()
-	defer sqM.Unlock()
-	srM.Lock()
-	defer srM.Unlock()
-	shutdownRequested = false
-	wg = &sync.WaitGroup{}
-	shutdownQueue = [4][]Notifier{}
-	shutdownFnQueue = [4][]fnNotify{}
 }
 
 func startTimer(t *testing.T) chan struct{} {
 	finished := make(chan struct{}, 0)
-	srM.RLock()
+	m := defaultManager
+	m.srM.RLock()
 	var to time.Duration
-	for i := range timeouts {
-		to += timeouts[i]
+	for i := range m.timeouts {
+		to += m.effectiveTimeout(i)
 	}
-	srM.RUnlock()
+	m.srM.RUnlock()
 	// Add some extra time.
 	toc := time.After((to * 10) / 9)
 	go func() {
@@ -103,6 +102,10 @@ func TestCancel(t *testing.T) {
 	go func() {
 		select {
 		case n := <-f:
+			// Cancelled notifiers are closed.
+			if n == nil {
+				return
+			}
 			ok = true
 			close(n)
 		}
@@ -114,6 +117,204 @@ func TestCancel(t *testing.T) {
 	}
 }
 
+func TestCancelUnblocks(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	f := First()
+	other := First()
+	got := make(chan chan struct{})
+	go func() {
+		n, ok := <-f
+		if ok {
+			t.Error("cancelled notifier was not closed")
+		}
+		got <- n
+	}()
+	if f.Cancelled() {
+		t.Fatal("notifier cancelled before Cancel was called")
+	}
+	f.Cancel()
+	select {
+	case n := <-got:
+		if n != nil {
+			t.Fatal("expected nil channel from cancelled notifier")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("goroutine was not unblocked by Cancel")
+	}
+	if !f.Cancelled() {
+		t.Fatal("notifier not marked cancelled")
+	}
+	// Cancelling again must not panic.
+	f.Cancel()
+
+	fn := FirstFunc(func(interface{}) {}, nil)
+	fn.Cancel()
+	if _, ok := <-fn; ok || !fn.Cancelled() {
+		t.Fatal("cancelled function notifier was not closed")
+	}
+
+	go func() {
+		n := <-other
+		close(n)
+	}()
+	Shutdown()
+	if other.Cancelled() {
+		t.Fatal("signalled notifier marked cancelled")
+	}
+	// Cancel after shutdown has no effect.
+	other.Cancel()
+	if other.Cancelled() {
+		t.Fatal("notifier cancelled after shutdown")
+	}
+}
+
+func TestCancelForgets(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	count := func() int {
+		nM.Lock()
+		defer nM.Unlock()
+		if len(notifierIDs) != len(notifiers) {
+			t.Fatalf("%d ids for %d notifiers", len(notifierIDs), len(notifiers))
+		}
+		return len(notifiers)
+	}
+	before := count()
+	for i := 0; i < 1000; i++ {
+		f := First()
+		f.Cancel()
+		f.Cancel()
+		if !f.Cancelled() {
+			t.Fatal("notifier not marked cancelled")
+		}
+		fn := FirstFunc(func(interface{}) {}, nil)
+		fn.Cancel()
+		_, finished := StopLoop(Stage2)
+		finished()
+	}
+	if n := count(); n != before {
+		t.Fatalf("notifiers grew from %d to %d", before, n)
+	}
+}
+
+func TestOnCancel(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	f := First()
+	var calls []string
+	f.OnCancel(func() { calls = append(calls, "replaced") })
+	f.OnCancel(func() { calls = append(calls, "cancel") })
+	if len(calls) != 0 {
+		t.Fatal("called before Cancel", calls)
+	}
+	f.Cancel()
+	if len(calls) != 1 || calls[0] != "cancel" {
+		t.Fatal("unexpected calls", calls)
+	}
+	// Cancelling again doesn't call it again.
+	f.Cancel()
+	if len(calls) != 1 {
+		t.Fatal("called twice", calls)
+	}
+	// Registered after cancellation, it is called immediately.
+	f.OnCancel(func() { calls = append(calls, "late") })
+	if len(calls) != 2 || calls[1] != "late" {
+		t.Fatal("not called immediately", calls)
+	}
+
+	fn := SecondFunc(func(interface{}) {}, nil)
+	called := false
+	fn.OnCancel(func() { called = true })
+	fn.Cancel()
+	if !called {
+		t.Fatal("function notifier OnCancel not called")
+	}
+
+	other := Third()
+	called = false
+	other.OnCancel(func() { called = true })
+	go func() {
+		n := <-other
+		close(n)
+	}()
+	Shutdown()
+	if called {
+		t.Fatal("called for signalled notifier")
+	}
+	other.OnCancel(func() { called = true })
+	if !called {
+		t.Fatal("not called immediately for signalled notifier")
+	}
+}
+
+func TestUnblockAfter(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	f := First()
+	f.UnblockAfter(50 * time.Millisecond)
+	tn := time.Now()
+	select {
+	case n := <-f:
+		if n != nil {
+			t.Fatal("expected nil channel from unblocked notifier")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("notifier was not unblocked")
+	}
+	if dur := time.Since(tn); dur < 40*time.Millisecond {
+		t.Fatal("notifier was unblocked early", dur)
+	}
+	if !f.Cancelled() {
+		t.Fatal("unblocked notifier not marked cancelled")
+	}
+	// Shutdown must not wait for the notifier.
+	tn = time.Now()
+	Shutdown()
+	if dur := time.Since(tn); dur > 500*time.Millisecond {
+		t.Fatal("shutdown waited for unblocked notifier", dur)
+	}
+}
+
+func TestUnblockAfterSignalled(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	f := First()
+	f.UnblockAfter(50 * time.Millisecond)
+	go func() {
+		n := <-f
+		close(n)
+	}()
+	Shutdown()
+	time.Sleep(100 * time.Millisecond)
+	if f.Cancelled() {
+		t.Fatal("signalled notifier was cancelled")
+	}
+}
+
+// UnblockAfter must also unblock a notifier, when shutdown has been started.
+func TestUnblockAfterStarted(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeout(time.Second)
+	f := Third()
+	f.UnblockAfter(50 * time.Millisecond)
+	// Holds the shutdown in the first stage.
+	_ = FirstFunc(func(interface{}) {
+		time.Sleep(100 * time.Millisecond)
+	}, nil)
+	go Shutdown()
+	select {
+	case n := <-f:
+		if n != nil {
+			t.Fatal("expected nil channel from unblocked notifier")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("notifier was not unblocked")
+	}
+	Shutdown()
+}
+
 func TestTimeout(t *testing.T) {
 	reset()
 	SetTimeout(time.Millisecond * 100)
@@ -196,8 +397,13 @@ func TestLock(t *testing.T) {
 		t.Fatal("Unable to aquire lock")
 	}
 	Unlock()
+	// Wait for the goroutines, so they don't use the manager of the next test.
+	var wg sync.WaitGroup
+	defer wg.Wait()
 	for i := 0; i < 10; i++ {
+		wg.Add(1)
 		go func() {
+			defer wg.Done()
 			if Lock() {
 				time.Sleep(time.Second)
 				Unlock()
@@ -213,6 +419,101 @@ func TestLock(t *testing.T) {
 	}
 }
 
+func TestBeginWork(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	release, ok := BeginWork()
+	if !ok {
+		t.Fatal("Unable to begin work")
+	}
+	var finished int32
+	_ = PreShutdownFunc(func(interface{}) {
+		time.Sleep(50 * time.Millisecond)
+		atomic.StoreInt32(&finished, 1)
+		release()
+	}, nil)
+	_ = FirstFunc(func(interface{}) {
+		if atomic.LoadInt32(&finished) != 1 {
+			t.Error("first stage started before work was released")
+		}
+	}, nil)
+	Shutdown()
+	if _, ok := BeginWork(); ok {
+		t.Fatal("began work after shutdown")
+	}
+	if Lock() {
+		t.Fatal("got lock after shutdown")
+	}
+}
+
+// Shutdown races many calls to BeginWork.
+// No work may be in flight when the first stage starts.
+func TestBeginWorkStress(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var inflight, total int64
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				release, ok := BeginWork()
+				if !ok {
+					return
+				}
+				atomic.AddInt64(&inflight, 1)
+				atomic.AddInt64(&total, 1)
+				atomic.AddInt64(&inflight, -1)
+				release()
+			}
+		}()
+	}
+	_ = FirstFunc(func(interface{}) {
+		if n := atomic.LoadInt64(&inflight); n != 0 {
+			t.Errorf("%d requests in flight after drain", n)
+		}
+	}, nil)
+	time.Sleep(50 * time.Millisecond)
+	Shutdown()
+	wg.Wait()
+	if atomic.LoadInt64(&total) == 0 {
+		t.Fatal("no work was done")
+	}
+}
+
+func TestUnlockWithoutLock(t *testing.T) {
+	reset()
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected Unlock without Lock to panic")
+		}
+	}()
+	Unlock()
+}
+
+func BenchmarkLockStarted(b *testing.B) {
+	reset()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if !Started() && Lock() {
+				Unlock()
+			}
+		}
+	})
+}
+
+func BenchmarkBeginWork(b *testing.B) {
+	reset()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if release, ok := BeginWork(); ok {
+				release()
+			}
+		}
+	})
+}
+
 func TestLockUnrelease(t *testing.T) {
 	reset()
 	defer close(startTimer(t))
@@ -234,6 +535,152 @@ func TestLockUnrelease(t *testing.T) {
 	Unlock()
 }
 
+func TestExtendDrain(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeout(time.Millisecond * 200)
+	if got := ExtendDrain(time.Second); got != 0 {
+		t.Fatal("drain was extended before shutdown:", got)
+	}
+	if !Lock() {
+		t.Fatal("Unable to aquire lock")
+	}
+	var granted time.Duration
+	_ = PreShutdownFunc(func(interface{}) {
+		granted = ExtendDrain(time.Millisecond * 400)
+	}, nil)
+	released := make(chan time.Time, 1)
+	go func() {
+		time.Sleep(time.Millisecond * 450)
+		released <- time.Now()
+		Unlock()
+	}()
+	Shutdown()
+	end := time.Now()
+	if granted != time.Millisecond*400 {
+		t.Fatal("unexpected drain extension granted:", granted)
+	}
+	select {
+	case rel := <-released:
+		if end.Before(rel) {
+			t.Fatal("shutdown proceeded before lock was released")
+		}
+	default:
+		t.Fatal("shutdown did not wait for extended drain")
+	}
+}
+
+func TestExtendDrainMax(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeout(time.Millisecond * 100)
+	SetMaxDrainExtension(time.Millisecond * 150)
+	var granted [2]time.Duration
+	_ = PreShutdownFunc(func(interface{}) {
+		granted[0] = ExtendDrain(time.Millisecond * 100)
+		granted[1] = ExtendDrain(time.Millisecond * 100)
+	}, nil)
+	Shutdown()
+	if granted[0] != time.Millisecond*100 || granted[1] != time.Millisecond*50 {
+		t.Fatal("unexpected drain extension granted:", granted)
+	}
+}
+
+func TestExtendDrainAfterPreshutdown(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	granted := time.Duration(-1)
+	_ = PreShutdownFunc(func(interface{}) {}, nil)
+	_ = FirstFunc(func(interface{}) {
+		granted = ExtendDrain(time.Second)
+	}, nil)
+	Shutdown()
+	if granted != 0 {
+		t.Fatal("drain was extended after the Preshutdown stage:", granted)
+	}
+}
+
+// logLines redirects Logger output to the returned channel.
+// Call the returned function to restore output.
+func logLines() (chan string, func()) {
+	lines := make(chan string, 100)
+	r, w := io.Pipe()
+	Logger.SetOutput(w)
+	go func() {
+		s := bufio.NewScanner(r)
+		for s.Scan() {
+			lines <- s.Text()
+		}
+	}()
+	return lines, func() {
+		Logger.SetOutput(os.Stderr)
+		w.Close()
+	}
+}
+
+// nextLine returns the next log line containing the given string.
+func nextLine(t *testing.T, lines chan string, contains string) string {
+	for {
+		select {
+		case l := <-lines:
+			if strings.Contains(l, contains) {
+				return l
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no log line containing %q", contains)
+		}
+	}
+}
+
+func TestStallWarnings(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeout(time.Minute)
+	c := newFakeClock()
+	defaultManager.clock = c
+	lines, restore := logLines()
+	defer restore()
+
+	f := First()
+	label := fmt.Sprintf("notifier id %d", f.ID())
+	got := make(chan chan struct{})
+	go func() {
+		got <- <-f
+	}()
+	finished := make(chan struct{})
+	go func() {
+		Shutdown()
+		close(finished)
+	}()
+	n := <-got
+	c.waitTimers(t, 2)
+
+	// Warnings are repeated with a doubling interval.
+	elapsed := time.Duration(0)
+	for _, d := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second} {
+		c.Advance(d)
+		elapsed += d
+		l := nextLine(t, lines, "still waiting")
+		if !strings.Contains(l, "Stage 1: still waiting after "+elapsed.String()+" for "+label) {
+			t.Fatal("unexpected warning:", l)
+		}
+	}
+	c.Advance(time.Second)
+	close(n)
+	l := nextLine(t, lines, "finished after")
+	if !strings.Contains(l, "Stage 1: "+label+" finished after 16s, warned 4 times") {
+		t.Fatal("unexpected summary:", l)
+	}
+	<-finished
+	select {
+	case l := <-lines:
+		if strings.Contains(l, "still waiting") {
+			t.Fatal("unexpected warning:", l)
+		}
+	default:
+	}
+}
+
 func TestOrder(t *testing.T) {
 	reset()
 	defer close(startTimer(t))
@@ -301,6 +748,49 @@ func TestOrder(t *testing.T) {
 	}
 }
 
+func TestReadOnlyOrder(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var mu sync.Mutex
+	var order []string
+	add := func(i interface{}) {
+		mu.Lock()
+		order = append(order, i.(string))
+		mu.Unlock()
+	}
+	_ = FirstFunc(add, "first")
+	_ = ReadOnlyFunc(add, "readonly")
+	_ = PreShutdownFunc(add, "pre")
+	ro := ReadOnly()
+	go func() {
+		n := <-ro
+		add("readonly-notifier")
+		close(n)
+	}()
+
+	Shutdown()
+	if len(order) != 4 || order[0] != "pre" || order[3] != "first" {
+		t.Fatal("unexpected order", order)
+	}
+}
+
+func TestReadOnlyTimeout(t *testing.T) {
+	reset()
+	SetTimeout(time.Second)
+	SetTimeoutN(ReadOnlyStage, time.Millisecond*100)
+	defer close(startTimer(t))
+	f := ReadOnly()
+	go func() {
+		<-f
+	}()
+	tn := time.Now()
+	Shutdown()
+	dur := time.Since(tn)
+	if dur > time.Second || dur < time.Millisecond*50 {
+		t.Fatalf("timeout time was unexpected:%v", dur)
+	}
+}
+
 func TestRecursive(t *testing.T) {
 	reset()
 	defer close(startTimer(t))
@@ -643,3 +1133,563 @@ func ExampleSetTimeoutN() {
 	// But give second stage more time
 	SetTimeoutN(Stage2, time.Second*10)
 }
+
+// expectPanic calls fn and fails if it doesn't panic with the given message.
+func expectPanic(t *testing.T, want string, fn func()) {
+	defer func() {
+		if r := recover(); r != want {
+			t.Fatalf("expected panic %q, got %v", want, r)
+		}
+	}()
+	fn()
+}
+
+func TestNilFunc(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	const want = "shutdown: nil shutdown function"
+	expectPanic(t, want, func() { PreShutdownFunc(nil, nil) })
+	expectPanic(t, want, func() { ReadOnlyFunc(nil, nil) })
+	expectPanic(t, want, func() { FirstFunc(nil, nil) })
+	expectPanic(t, want, func() { SecondFunc(nil, nil) })
+	expectPanic(t, want, func() { ThirdFunc(nil, nil) })
+	expectPanic(t, want, func() { NewManager().FirstFunc(nil, nil) })
+	// Nothing must have been registered.
+	for stage := range defaultManager.shutdownFnQueue {
+		if n := len(defaultManager.shutdownFnQueue[stage]); n != 0 {
+			t.Fatalf("stage %d has %d functions", stage, n)
+		}
+	}
+	Shutdown()
+}
+
+func TestInvalidTimeout(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	lines, restore := logLines()
+	defer restore()
+
+	SetTimeout(0)
+	nextLine(t, lines, "SetTimeout: ignoring invalid timeout 0s")
+	SetTimeoutN(Stage2, -time.Second)
+	nextLine(t, lines, "SetTimeoutN: ignoring invalid timeout -1s")
+	for stage := range defaultManager.timeouts {
+		if d := defaultManager.effectiveTimeout(stage); d != time.Second {
+			t.Fatalf("stage %d timeout changed to %v", stage, d)
+		}
+	}
+
+	SetMaxDrainExtension(-time.Second)
+	nextLine(t, lines, "SetMaxDrainExtension: negative duration -1s, using 0")
+	if d := defaultManager.maxDrainExtension; d != 0 {
+		t.Fatal("unexpected max drain extension", d)
+	}
+}
+
+func TestEffectiveTimeout(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeout(2 * time.Second)
+	SetTimeoutN(Stage2, 500*time.Millisecond)
+	// The stage timeout must take precedence, regardless of order.
+	SetTimeout(3 * time.Second)
+	SetTimeoutN(ReadOnlyStage, time.Second)
+
+	want := map[Stage]time.Duration{
+		Preshutdown:   3 * time.Second,
+		ReadOnlyStage: time.Second,
+		Stage1:        3 * time.Second,
+		Stage2:        500 * time.Millisecond,
+		Stage3:        3 * time.Second,
+	}
+	d := NewDomain("test")
+	for s, w := range want {
+		if got := EffectiveTimeout(s); got != w {
+			t.Errorf("stage %d: expected %v, got %v", s.n, w, got)
+		}
+		if got := d.EffectiveTimeout(s); got != w {
+			t.Errorf("domain stage %d: expected %v, got %v", s.n, w, got)
+		}
+	}
+}
+
+func TestNotifierString(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	f := First()
+	s := fmt.Sprint(f)
+	if !strings.HasPrefix(s, `Notifier{stage:1, id:"first#`) || !strings.HasSuffix(s, `", active:true}`) {
+		t.Fatal("unexpected string", s)
+	}
+	r := ReadOnlyFunc(func(interface{}) {}, nil)
+	if s := r.String(); !strings.HasPrefix(s, `Notifier{stage:4, id:"readonly#`) {
+		t.Fatal("unexpected string", s)
+	}
+	if f.String() == First().String() {
+		t.Fatal("notifiers have the same id")
+	}
+	f.Cancel()
+	if s := f.String(); !strings.HasSuffix(s, "active:false}") {
+		t.Fatal("cancelled notifier is active", s)
+	}
+	Shutdown()
+	if s := r.String(); s != "Notifier{active:false}" {
+		t.Fatal("unexpected string after shutdown", s)
+	}
+}
+
+func TestNotifierID(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	m := NewManager()
+	all := []Notifier{First(), Second(), ThirdFunc(func(interface{}) {}, nil), m.First(), m.PreShutdown(), NewDomain("ids").First()}
+	seen := make(map[uint64]bool)
+	for _, n := range all {
+		id := n.ID()
+		if id == 0 || seen[id] {
+			t.Fatal("id is not unique", id)
+		}
+		seen[id] = true
+		got, ok := NotifierByID(id)
+		if !ok || got != n {
+			t.Fatal("notifier not found by id", id)
+		}
+	}
+
+	var got bool
+	n := FirstFunc(setBool, &got)
+	id := n.ID()
+	if !CancelByID(id) {
+		t.Fatal("notifier not cancelled by id")
+	}
+	if !n.Cancelled() {
+		t.Fatal("notifier not marked cancelled")
+	}
+	// Cancelled notifiers are forgotten.
+	if n.ID() != 0 {
+		t.Fatal("cancelled notifier still has an id", n.ID())
+	}
+	if CancelByID(id) {
+		t.Fatal("cancelled notifier found by id")
+	}
+	if _, ok := NotifierByID(1 << 62); ok {
+		t.Fatal("unknown id found")
+	}
+	Shutdown()
+	if got {
+		t.Fatal("notifier cancelled by id was called")
+	}
+}
+
+func TestNotifierExpired(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeout(time.Millisecond * 100)
+
+	// This consumer aborts its work when the stage stops waiting for it.
+	honor := First()
+	aborted := make(chan time.Duration, 1)
+	go func() {
+		n := <-honor
+		tn := time.Now()
+		select {
+		case <-time.After(time.Minute):
+			close(n)
+		case <-honor.Expired():
+			aborted <- time.Since(tn)
+		}
+	}()
+
+	// This consumer ignores it, and is abandoned.
+	ignore := First()
+	go func() {
+		<-ignore
+		// Never finish
+	}()
+	var second bool
+	SecondFunc(setBool, &second)
+
+	Shutdown()
+	select {
+	case d := <-aborted:
+		if d < 50*time.Millisecond || d > 500*time.Millisecond {
+			t.Fatal("consumer was not aborted at the deadline", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("consumer was not aborted")
+	}
+	if !second {
+		t.Fatal("shutdown did not proceed")
+	}
+}
+
+func TestNotifierExpiredFinished(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	f := First()
+	expired := f.Expired()
+	go func() {
+		close(<-f)
+	}()
+	c := First()
+	c.Cancel()
+	select {
+	case <-c.Expired():
+	default:
+		t.Fatal("cancelled notifier not expired")
+	}
+	select {
+	case <-expired:
+		t.Fatal("notifier expired before shutdown")
+	default:
+	}
+	Shutdown()
+	<-expired
+}
+
+// queueOrder returns the values given to the functions of a stage,
+// in the order of the queue, and checks that ids are ascending.
+func queueOrder(t *testing.T, m *Manager, stage int, values map[Notifier]int) []int {
+	m.sqM.Lock()
+	defer m.sqM.Unlock()
+	var order []int
+	var last uint64
+	for _, fn := range m.shutdownFnQueue[stage] {
+		if id := fn.client.ID(); id <= last {
+			t.Fatalf("id %d after id %d", id, last)
+		} else {
+			last = id
+		}
+		order = append(order, values[fn.client])
+	}
+	last = 0
+	for _, n := range m.shutdownQueue[stage] {
+		if id := n.ID(); id <= last {
+			t.Fatalf("internal id %d after id %d", id, last)
+		} else {
+			last = id
+		}
+	}
+	return order
+}
+
+func TestRegistrationOrder(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	values := make(map[Notifier]int)
+	var mu sync.Mutex
+	add := func(v int) {
+		n := FirstFunc(func(interface{}) {}, nil)
+		mu.Lock()
+		values[n] = v
+		mu.Unlock()
+	}
+	// Simulate init functions of two packages, which run in sequence.
+	initA := func() { add(1); add(2) }
+	initB := func() { add(3); add(4) }
+	initA()
+	initB()
+	want := []int{1, 2, 3, 4}
+	if got := queueOrder(t, defaultManager, 1, values); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatal("unexpected order", got)
+	}
+
+	// Cancelling keeps the order of the rest.
+	for n, v := range values {
+		if v == 2 {
+			n.Cancel()
+		}
+	}
+	want = []int{1, 3, 4}
+	if got := queueOrder(t, defaultManager, 1, values); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatal("unexpected order after cancel", got)
+	}
+}
+
+func TestRegistrationOrderConcurrent(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	const goroutines, each = 8, 50
+	values := make(map[Notifier]int)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				n := SecondFunc(func(interface{}) {}, nil)
+				mu.Lock()
+				values[n] = g*each + i
+				mu.Unlock()
+			}
+		}(g)
+	}
+	wg.Wait()
+	got := queueOrder(t, defaultManager, 2, values)
+	if len(got) != goroutines*each {
+		t.Fatal("unexpected number of functions", len(got))
+	}
+	// Each goroutine must see its own registrations in order.
+	last := make([]int, goroutines)
+	for i := range last {
+		last[i] = -1
+	}
+	for _, v := range got {
+		g := v / each
+		if v <= last[g] {
+			t.Fatalf("registration %d of goroutine %d after %d", v%each, g, last[g]%each)
+		}
+		last[g] = v
+	}
+}
+
+func TestTimeoutsDisabled(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	c := newFakeClock()
+	defaultManager.clock = c
+	lines, restore := logLines()
+	defer restore()
+	SetTimeoutsDisabled(true)
+	defer SetTimeoutsDisabled(false)
+	nextLine(t, lines, "timeouts are disabled")
+	if !Stats().TimeoutsDisabled {
+		t.Fatal("disabled timeouts not reported by Stats")
+	}
+	if !Lock() {
+		t.Fatal("unable to lock")
+	}
+	f := First()
+	finished := make(chan struct{})
+	go func() {
+		Shutdown()
+		close(finished)
+	}()
+	nextLine(t, lines, "timeouts are disabled")
+
+	// Neither the lock nor the notifier may time out.
+	for _, unblock := range []func(){Unlock, func() { close(<-f) }} {
+		c.waitTimers(t, 2)
+		c.Advance(24 * time.Hour)
+		select {
+		case <-finished:
+			t.Fatal("shutdown finished with timeouts disabled")
+		case <-time.After(20 * time.Millisecond):
+		}
+		unblock()
+	}
+	<-finished
+	if Stats().Reason.Cause == "" {
+		t.Fatal("shutdown did not run")
+	}
+	for {
+		select {
+		case l := <-lines:
+			if strings.Contains(l, "still waiting") || strings.Contains(l, "timeout waiting") {
+				t.Fatal("unexpected log line", l)
+			}
+		case <-time.After(20 * time.Millisecond):
+			return
+		}
+	}
+}
+
+func TestStageMinDuration(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetStageMinDuration(Stage1, 200*time.Millisecond)
+	var second bool
+	_ = FirstFunc(func(interface{}) {}, nil)
+	_ = SecondFunc(setBool, &second)
+	tn := time.Now()
+	Shutdown()
+	if d := time.Since(tn); d < 200*time.Millisecond {
+		t.Fatal("stage finished before its minimum duration", d)
+	}
+	if !second {
+		t.Fatal("second stage did not run")
+	}
+	s := LastSummary()
+	if s.Stages[1].Stage != 1 || s.Stages[1].Duration < 200*time.Millisecond || s.Stages[1].TimedOut {
+		t.Fatal("unexpected stage summary", s.Stages[1])
+	}
+}
+
+func TestStageMinDurationClamp(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	lines, restore := logLines()
+	defer restore()
+	SetTimeoutN(Stage2, 100*time.Millisecond)
+	SetStageMinDuration(Stage2, time.Second)
+	nextLine(t, lines, "SetStageMinDuration: duration 1s is longer than the timeout of the stage, using 100ms")
+	SetStageMinDuration(Stage2, -time.Second)
+	nextLine(t, lines, "SetStageMinDuration: negative duration -1s, using 0")
+
+	// An empty stage waits for its minimum.
+	SetStageMinDuration(Stage2, 100*time.Millisecond)
+	tn := time.Now()
+	Shutdown()
+	if d := time.Since(tn); d < 100*time.Millisecond || d > 900*time.Millisecond {
+		t.Fatal("unexpected shutdown duration", d)
+	}
+}
+
+func TestOnStageTimeout(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeout(100 * time.Millisecond)
+	var got []string
+	OnStageTimeout(Stage1, func(pending []string) {
+		got = pending
+	})
+	OnStageTimeout(Stage2, func([]string) {
+		panic("This is expected")
+	})
+	degraded := 0
+	Configure(WithGracefulDegradation(func(stage int) {
+		if got == nil {
+			t.Error("degradation function called before timeout function")
+		}
+		degraded++
+	}))
+	_ = FirstFunc(func(interface{}) {}, nil)
+	slow := First()
+	id := slow.ID()
+	go func() {
+		<-slow
+		// Never finish
+	}()
+	slow2 := Second()
+	go func() {
+		<-slow2
+		// Never finish
+	}()
+	lines, restore := logLines()
+	defer restore()
+	Shutdown()
+	if len(got) != 1 || got[0] != fmt.Sprintf("id %d", id) {
+		t.Fatal("unexpected pending notifiers", got)
+	}
+	nextLine(t, lines, "Panic in timeout function of second stage: This is expected")
+	if degraded != 2 {
+		t.Fatal("degradation function not called after each timeout", degraded)
+	}
+}
+
+func TestNotifierBufferSize(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	lines, restore := logLines()
+	defer restore()
+	SetNotifierBufferSize(-1)
+	nextLine(t, lines, "SetNotifierBufferSize: negative size -1, using 0")
+	SetNotifierBufferSize(1)
+	SetTimeout(100 * time.Millisecond)
+
+	f := First()
+	sent := make(chan struct{})
+	go func() {
+		n := <-f
+		if cap(n) != 1 {
+			t.Error("unexpected buffer size", cap(n))
+		}
+		n <- struct{}{}
+		close(sent)
+	}()
+	late := Second()
+	lateSent := make(chan struct{})
+	go func() {
+		n := <-late
+		// Acknowledge after the stage has timed out.
+		<-late.Expired()
+		n <- struct{}{}
+		close(lateSent)
+	}()
+	var third bool
+	ThirdFunc(setBool, &third)
+	Shutdown()
+	<-sent
+	select {
+	case <-lateSent:
+	case <-time.After(time.Second):
+		t.Fatal("late acknowledgment blocked")
+	}
+	if !third {
+		t.Fatal("third stage did not run")
+	}
+	s := LastSummary()
+	if s.Stages[1].TimedOut || !s.Stages[2].TimedOut {
+		t.Fatal("unexpected stages", s.Stages)
+	}
+}
+
+func TestNotifierPeek(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	f := First()
+	c := Second()
+	c.Cancel()
+	if f.Peek() || c.Peek() {
+		t.Fatal("notification available before shutdown")
+	}
+	var peeked bool
+	SecondFunc(func(interface{}) {
+		peeked = f.Peek()
+	}, nil)
+	go Shutdown()
+	for i := 0; !f.Peek(); i++ {
+		if i > 1000 {
+			t.Fatal("notification not available")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// Peeking must not receive the notification.
+	n := <-f
+	if f.Peek() || peeked {
+		t.Fatal("notification still available after receiving it")
+	}
+	close(n)
+	<-defaultManager.done
+	if c.Peek() {
+		t.Fatal("cancelled notifier has a notification")
+	}
+}
+
+func TestOnSignalWaitStage(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	exited := make(chan int, 1)
+	SetExitFunc(func(code int) { exited <- code })
+	first := make(chan struct{})
+	second := make(chan struct{})
+	FirstFunc(func(interface{}) { <-first }, nil)
+	SecondFunc(func(interface{}) { <-second }, nil)
+
+	c := make(chan os.Signal, 1)
+	go defaultManager.handleSignals(c, 3, Stage1.n)
+	c <- os.Interrupt
+	select {
+	case <-exited:
+		t.Fatal("exited before the stage completed")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(first)
+	select {
+	case code := <-exited:
+		if code != 3 {
+			t.Fatal("unexpected exit code", code)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("did not exit when the stage completed")
+	}
+	if !Started() {
+		t.Fatal("shutdown not started")
+	}
+	if s := Stats(); s.Reason.Signal != os.Interrupt {
+		t.Fatal("unexpected reason", s.Reason)
+	}
+	close(second)
+	<-defaultManager.done
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ShutdownError describes a shutdown that did not complete cleanly,
+// because a stage timed out or a function panicked.
+type ShutdownError struct {
+	Summary Summary
+}
+
+func (e *ShutdownError) Error() string {
+	timedOut := 0
+	for _, s := range e.Summary.Stages {
+		if s.TimedOut {
+			timedOut++
+		}
+	}
+	return fmt.Sprintf("shutdown: %d stages timed out, %d functions panicked (%s)", timedOut, e.Summary.Panics, e.Summary.Reason.Cause)
+}
+
+// A ShutdownGroup shuts down several managers together.
+//
+// This can be used when an application consists of components
+// with their own managers, which must all be shut down.
+type ShutdownGroup struct {
+	mu       sync.Mutex // Mutex for below
+	managers []*Manager
+}
+
+// NewShutdownGroup returns a group of the given managers.
+func NewShutdownGroup(managers ...*Manager) *ShutdownGroup {
+	return &ShutdownGroup{managers: append([]*Manager(nil), managers...)}
+}
+
+// Add adds a manager to the group.
+func (g *ShutdownGroup) Add(m *Manager) {
+	g.mu.Lock()
+	g.managers = append(g.managers, m)
+	g.mu.Unlock()
+}
+
+// Shutdown shuts down all managers of the group concurrently,
+// and waits for all of them to complete.
+//
+// A *ShutdownError is returned for each manager that did not shut down
+// cleanly, in the order the managers were added. If a manager has already
+// been shut down, its last shutdown is reported.
+func (g *ShutdownGroup) Shutdown() []error {
+	g.mu.Lock()
+	managers := append([]*Manager(nil), g.managers...)
+	g.mu.Unlock()
+
+	summaries := make([]Summary, len(managers))
+	var wg sync.WaitGroup
+	for i, m := range managers {
+		wg.Add(1)
+		go func(i int, m *Manager) {
+			defer wg.Done()
+			m.shutdown(Reason{Cause: "ShutdownGroup.Shutdown called"})
+			summaries[i] = m.LastSummary()
+		}(i, m)
+	}
+	wg.Wait()
+
+	var errs []error
+	for _, s := range summaries {
+		if s.TimedOut || s.Panics > 0 {
+			errs = append(errs, &ShutdownError{Summary: s})
+		}
+	}
+	return errs
+}
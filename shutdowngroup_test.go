@@ -0,0 +1,62 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShutdownGroup(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	a, b, c := NewManager(), NewManager(), NewManager()
+	var gotA, gotB, gotC bool
+	a.FirstFunc(setBool, &gotA)
+	b.SecondFunc(setBool, &gotB)
+	c.ThirdFunc(setBool, &gotC)
+
+	g := NewShutdownGroup(a, b)
+	g.Add(c)
+	if errs := g.Shutdown(); len(errs) != 0 {
+		t.Fatal("unexpected errors", errs)
+	}
+	if !gotA || !gotB || !gotC {
+		t.Fatal("not all managers were shut down", gotA, gotB, gotC)
+	}
+	if !a.Started() || !b.Started() || !c.Started() {
+		t.Fatal("managers not marked started")
+	}
+}
+
+func TestShutdownGroupErrors(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	a, b, c := NewManager(), NewManager(), NewManager()
+	a.SetTimeout(100 * time.Millisecond)
+	f := a.First()
+	go func() {
+		<-f
+		// Never finish
+	}()
+	c.FirstFunc(func(interface{}) { panic("This is expected") }, nil)
+
+	start := time.Now()
+	errs := NewShutdownGroup(a, b, c).Shutdown()
+	if len(errs) != 2 {
+		t.Fatal("unexpected errors", errs)
+	}
+	if se, ok := errs[0].(*ShutdownError); !ok || !se.Summary.TimedOut {
+		t.Fatal("unexpected error", errs[0])
+	}
+	if se, ok := errs[1].(*ShutdownError); !ok || se.Summary.Panics != 1 {
+		t.Fatal("unexpected error", errs[1])
+	}
+	if !strings.Contains(errs[0].Error(), "1 stages timed out") {
+		t.Fatal("unexpected message", errs[0])
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatal("managers were not shut down concurrently", d)
+	}
+}
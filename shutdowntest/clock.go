@@ -0,0 +1,159 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdowntest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/klauspost/shutdown"
+)
+
+// FakeClock is a shutdown.Clock that only moves when it is advanced.
+// Use it with shutdown.WithClock.
+type FakeClock struct {
+	mu      sync.Mutex // Mutex for below
+	now     time.Time
+	mono    time.Duration
+	timers  []*fakeTimer
+	changes int // Incremented when a timer is created, stopped, reset or fired.
+}
+
+// NewFakeClock returns a FakeClock, starting at a fixed wall time.
+func NewFakeClock() *FakeClock {
+	return &FakeClock{now: time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+// Now returns the wall time of the clock.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Mono returns the time the clock has been advanced.
+func (c *FakeClock) Mono() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mono
+}
+
+// NewTimer returns a timer that fires when the clock has been advanced by d.
+func (c *FakeClock) NewTimer(d time.Duration) shutdown.ClockTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{c: c, ch: make(chan time.Time, 1), when: c.mono + d, active: true}
+	c.timers = append(c.timers, t)
+	c.changes++
+	c.fire()
+	return t
+}
+
+// Advance moves the clock forward and fires all expired timers.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mono += d
+	c.fire()
+	c.mu.Unlock()
+}
+
+// Active returns the number of timers that haven't fired or been stopped.
+func (c *FakeClock) Active() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, t := range c.timers {
+		if t.active {
+			n++
+		}
+	}
+	return n
+}
+
+// next returns the time until the first active timer fires.
+func (c *FakeClock) next() (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var next time.Duration
+	found := false
+	for _, t := range c.timers {
+		if t.active && (!found || t.when < next) {
+			next, found = t.when, true
+		}
+	}
+	return next - c.mono, found
+}
+
+// settle waits until no timers have been changed for a while,
+// which means that the goroutines using the clock are waiting.
+func (c *FakeClock) settle() {
+	const quiet = 10
+	last, same := -1, 0
+	for i := 0; same < quiet && i < 1000; i++ {
+		c.mu.Lock()
+		changes := c.changes
+		c.mu.Unlock()
+		if changes == last {
+			same++
+		} else {
+			last, same = changes, 0
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// fire sends on all expired timers. c.mu must be held.
+func (c *FakeClock) fire() {
+	for _, t := range c.timers {
+		if t.active && t.when <= c.mono {
+			t.active = false
+			c.changes++
+			select {
+			case t.ch <- c.now:
+			default:
+			}
+		}
+	}
+}
+
+type fakeTimer struct {
+	c      *FakeClock
+	ch     chan time.Time
+	when   time.Duration
+	active bool
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	wasActive := t.active
+	t.active = false
+	t.c.changes++
+	t.drain()
+	return wasActive
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	wasActive := t.active
+	t.drain()
+	t.when = t.c.mono + d
+	t.active = true
+	t.c.changes++
+	t.c.fire()
+	return wasActive
+}
+
+// drain removes a stale value from the channel.
+func (t *fakeTimer) drain() {
+	select {
+	case <-t.ch:
+	default:
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+// Package shutdowntest helps testing code that uses the shutdown package.
+//
+// RunScenario runs a scripted shutdown on a manager with a fake clock
+// and exit function, so timeouts can be tested without waiting for them,
+// and exiting doesn't end the test.
+package shutdowntest
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/shutdown"
+)
+
+// A Scenario is a scripted shutdown, see RunScenario.
+type Scenario struct {
+	// Options are applied to the manager of the scenario.
+	Options []shutdown.Option
+
+	// Setup registers notifiers and functions with the manager of the scenario.
+	Setup func(e *Env)
+
+	// Steps are run in order, see Advance, Shutdown, Exit and Do.
+	Steps []Step
+
+	// WantEvents are the events expected to be recorded, in order, see Env.Record.
+	WantEvents []string
+
+	// WantTimedOut are the stages expected to time out, as numbered by
+	// shutdown.StageSummary, in the order they are run.
+	WantTimedOut []int
+
+	// WantExitCode is the expected exit code, if the scenario exits.
+	// If it isn't 0, the scenario must exit.
+	WantExitCode int
+
+	// WantLog contains strings expected in the log, in order.
+	// Each string must be part of a separate line.
+	WantLog []string
+}
+
+// Result is the outcome of a scenario.
+type Result struct {
+	Summary  shutdown.Summary // The last shutdown, see Manager.LastSummary.
+	Events   []string         // Events recorded with Env.Record.
+	Exited   bool             // True if the exit function was called.
+	ExitCode int              // The code given to the exit function.
+	Log      []string         // Lines written to shutdown.Logger.
+}
+
+// Env is the environment a scenario runs in.
+type Env struct {
+	Manager *shutdown.Manager
+	Clock   *FakeClock
+
+	mu       sync.Mutex // Mutex for below
+	events   []string
+	log      []string
+	exited   bool
+	exitCode int
+	done     chan struct{} // Closed when the shutdown started by a step returns.
+}
+
+// Record records an event, for instance that a function has completed.
+// It is safe to call from any goroutine.
+func (e *Env) Record(event string) {
+	e.mu.Lock()
+	e.events = append(e.events, event)
+	e.mu.Unlock()
+}
+
+// logWriter captures log output of an Env. Each call is a line.
+type logWriter struct {
+	e *Env
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	w.e.mu.Lock()
+	w.e.log = append(w.e.log, strings.TrimSuffix(string(p), "\n"))
+	w.e.mu.Unlock()
+	return len(p), nil
+}
+
+// A Step is a step of a scenario.
+type Step struct {
+	name string
+	run  func(t testing.TB, e *Env)
+}
+
+// Advance returns a step that moves the clock forward by d.
+// Timers are fired one at a time, and goroutines are given time
+// to react to each of them, before the clock is moved further.
+func Advance(d time.Duration) Step {
+	return Step{name: fmt.Sprintf("Advance(%v)", d), run: func(t testing.TB, e *Env) {
+		e.Clock.settle()
+		for d > 0 {
+			next, ok := e.Clock.next()
+			if !ok || next > d {
+				e.Clock.Advance(d)
+				break
+			}
+			e.Clock.Advance(next)
+			d -= next
+			e.Clock.settle()
+		}
+		e.Clock.settle()
+	}}
+}
+
+// Shutdown returns a step that starts shutdown of the manager.
+// It doesn't wait for shutdown to complete.
+func Shutdown() Step {
+	return Step{name: "Shutdown()", run: func(t testing.TB, e *Env) {
+		e.start(t, e.Manager.Shutdown)
+	}}
+}
+
+// Exit returns a step that starts shutdown of the manager, followed by exiting with code.
+// It doesn't wait for shutdown to complete.
+func Exit(code int) Step {
+	return Step{name: fmt.Sprintf("Exit(%d)", code), run: func(t testing.TB, e *Env) {
+		e.start(t, func() { e.Manager.Exit(code) })
+	}}
+}
+
+// Do returns a step that calls fn.
+func Do(fn func(e *Env)) Step {
+	return Step{name: "Do", run: func(t testing.TB, e *Env) {
+		fn(e)
+	}}
+}
+
+// start calls fn in a goroutine, and closes e.done when it returns.
+func (e *Env) start(t testing.TB, fn func()) {
+	if e.done != nil {
+		t.Fatal("shutdowntest: shutdown started twice")
+	}
+	done := make(chan struct{})
+	e.done = done
+	go func() {
+		defer close(done)
+		fn()
+	}()
+}
+
+// maxIdle is the longest time to wait for a shutdown to complete,
+// while no timers are active.
+const maxIdle = 10 * time.Second
+
+// RunScenario runs a scenario and checks the expectations of it.
+//
+// The scenario runs on a new manager, which uses a FakeClock and
+// an exit function that doesn't exit, so the default manager isn't
+// affected. shutdown.Logger is replaced while the scenario runs,
+// and restored when it returns.
+//
+// When all steps have run, the clock is advanced until shutdown
+// has completed, if it was started. The result is returned,
+// so further checks can be made.
+func RunScenario(t testing.TB, s Scenario) Result {
+	t.Helper()
+	e := &Env{Clock: NewFakeClock()}
+	logger := shutdown.Logger
+	shutdown.Logger = log.New(logWriter{e}, "", 0)
+	defer func() {
+		shutdown.Logger = logger
+	}()
+
+	opts := append([]shutdown.Option{shutdown.WithClock(e.Clock)}, s.Options...)
+	e.Manager = shutdown.NewManager(opts...)
+	e.Manager.SetExitFunc(func(code int) {
+		e.mu.Lock()
+		e.exited, e.exitCode = true, code
+		e.mu.Unlock()
+	})
+	if s.Setup != nil {
+		s.Setup(e)
+	}
+	for i, step := range s.Steps {
+		t.Logf("step %d: %s", i, step.name)
+		step.run(t, e)
+	}
+	if e.done != nil {
+		e.finish(t)
+	}
+
+	e.mu.Lock()
+	r := Result{
+		Summary:  e.Manager.LastSummary(),
+		Events:   append([]string(nil), e.events...),
+		Exited:   e.exited,
+		ExitCode: e.exitCode,
+		Log:      append([]string(nil), e.log...),
+	}
+	e.mu.Unlock()
+	s.check(t, r)
+	return r
+}
+
+// finish advances the clock until the started shutdown has returned.
+func (e *Env) finish(t testing.TB) {
+	t.Helper()
+	idle := time.Now()
+	for {
+		e.Clock.settle()
+		select {
+		case <-e.done:
+			return
+		default:
+		}
+		if next, ok := e.Clock.next(); ok {
+			e.Clock.Advance(next)
+			idle = time.Now()
+			continue
+		}
+		if time.Since(idle) > maxIdle {
+			t.Fatal("shutdowntest: shutdown did not complete")
+		}
+	}
+}
+
+// check reports the expectations of the scenario that were not met.
+func (s Scenario) check(t testing.TB, r Result) {
+	t.Helper()
+	if len(s.WantEvents) > 0 && !reflect.DeepEqual(r.Events, s.WantEvents) {
+		t.Errorf("shutdowntest: got events %q, want %q", r.Events, s.WantEvents)
+	}
+	var timedOut []int
+	for _, st := range r.Summary.Stages {
+		if st.TimedOut {
+			timedOut = append(timedOut, st.Stage)
+		}
+	}
+	if len(timedOut) > 0 || len(s.WantTimedOut) > 0 {
+		if !reflect.DeepEqual(timedOut, s.WantTimedOut) {
+			t.Errorf("shutdowntest: got timed out stages %v, want %v", timedOut, s.WantTimedOut)
+		}
+	}
+	if s.WantExitCode != 0 && !r.Exited {
+		t.Errorf("shutdowntest: did not exit, want exit code %d", s.WantExitCode)
+	} else if r.Exited && r.ExitCode != s.WantExitCode {
+		t.Errorf("shutdowntest: got exit code %d, want %d", r.ExitCode, s.WantExitCode)
+	}
+	lines := r.Log
+	for _, want := range s.WantLog {
+		found := false
+		for len(lines) > 0 && !found {
+			found = strings.Contains(lines[0], want)
+			lines = lines[1:]
+		}
+		if !found {
+			t.Errorf("shutdowntest: log line containing %q not found, got:\n%s", want, strings.Join(r.Log, "\n"))
+			return
+		}
+	}
+}
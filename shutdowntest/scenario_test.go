@@ -0,0 +1,88 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdowntest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/shutdown"
+)
+
+func TestRunScenario(t *testing.T) {
+	start := time.Now()
+	var stage1 bool
+	r := RunScenario(t, Scenario{
+		Setup: func(e *Env) {
+			e.Manager.SetTimeout(time.Minute)
+			e.Manager.FirstFunc(func(interface{}) { e.Record("first") }, nil)
+			slow := e.Manager.First()
+			go func() {
+				<-slow
+				// Never finish
+			}()
+			e.Manager.SecondFunc(func(interface{}) { e.Record("second") }, nil)
+		},
+		Steps: []Step{
+			Exit(3),
+			Advance(30 * time.Second),
+			Do(func(e *Env) {
+				stage1 = e.Manager.Started() && e.Manager.LastSummary().Stages == nil
+			}),
+		},
+		WantEvents:   []string{"first", "second"},
+		WantTimedOut: []int{1},
+		WantExitCode: 3,
+		WantLog:      []string{"Shutdown stage 1", "timeout waiting to shutdown", "Shutdown stage 2"},
+	})
+	if !stage1 {
+		t.Fatal("shutdown completed before the first stage timed out")
+	}
+	if !r.Exited || len(r.Summary.Stages) != 3 {
+		t.Fatal("unexpected result", r)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Fatal("scenario waited for the timeout", d)
+	}
+}
+
+func TestRunScenarioLogger(t *testing.T) {
+	logger := shutdown.Logger
+	RunScenario(t, Scenario{Steps: []Step{Shutdown()}})
+	if shutdown.Logger != logger {
+		t.Fatal("logger was not restored")
+	}
+}
+
+func TestArtificialDelay(t *testing.T) {
+	var slowID uint64
+	var pending []string
+	RunScenario(t, Scenario{
+		Setup: func(e *Env) {
+			e.Manager.SetTimeoutN(shutdown.Stage1, 5*time.Second)
+			e.Manager.OnStageTimeout(shutdown.Stage1, func(p []string) {
+				pending = p
+			})
+			e.Manager.FirstFunc(func(interface{}) { e.Record("fast") }, nil).WithArtificialDelay(time.Second)
+			slow := e.Manager.FirstFunc(func(interface{}) { e.Record("slow") }, nil).WithArtificialDelay(10 * time.Second)
+			slowID = slow.ID()
+		},
+		Steps: []Step{
+			Shutdown(),
+			Advance(4 * time.Second),
+			Do(func(e *Env) {
+				if s := e.Manager.LastSummary(); s.Stages != nil {
+					t.Error("shutdown completed before the timeout")
+				}
+			}),
+			Advance(time.Second),
+		},
+		WantTimedOut: []int{1},
+		WantLog:      []string{"Shutdown stage 1", "timeout waiting to shutdown"},
+	})
+	if len(pending) != 1 || !strings.Contains(pending[0], fmt.Sprintf("id %d", slowID)) {
+		t.Fatalf("expected only the slow notifier to be pending, got %q", pending)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"context"
+	"database/sql"
+)
+
+// RegisterDBCloser closes db in the given stage of the shutdown.
+//
+// This drains the connection pool of db, closing idle connections,
+// and waiting for connections that are in use to be returned.
+// Errors returned by Close are logged. The function waits for Close
+// until the timeout of the stage, and then logs that it didn't return,
+// see ShutdownFnCtx. A nil db panics.
+// The returned Notifier is only really useful for cancelling the close.
+func RegisterDBCloser(db *sql.DB, s Stage) Notifier {
+	return defaultManager.RegisterDBCloser(db, s)
+}
+
+// RegisterDBCloser closes db in the given stage of the shutdown of the manager.
+func (m *Manager) RegisterDBCloser(db *sql.DB, s Stage) Notifier {
+	if db == nil {
+		panic("shutdown: nil database")
+	}
+	return m.onFuncCtx(s.n, closeDB, db)
+}
+
+// closeDB closes the database, and waits until ctx is done for it.
+func closeDB(ctx context.Context, v interface{}) {
+	closed := make(chan error, 1)
+	go func() {
+		closed <- v.(*sql.DB).Close()
+	}()
+	select {
+	case err := <-closed:
+		if err != nil {
+			Logger.Println("Error closing database:", err)
+		}
+	case <-ctx.Done():
+		Logger.Println("Timeout closing database, connections may still be open")
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+)
+
+// testDriver is a database driver, where closing a connection blocks
+// until the closeConn channel of the driver is closed, if it is set.
+type testDriver struct {
+	closeConn chan struct{}
+}
+
+func (d *testDriver) Open(name string) (driver.Conn, error) {
+	return testConn{d}, nil
+}
+
+type testConn struct {
+	d *testDriver
+}
+
+func (c testConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c testConn) Close() error {
+	if c.d.closeConn != nil {
+		<-c.d.closeConn
+	}
+	return nil
+}
+
+func (c testConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("not supported")
+}
+
+// openTestDB returns a database with an idle connection.
+func openTestDB(t *testing.T, d *testDriver) *sql.DB {
+	db := sql.OpenDB(testConnector{d})
+	if err := db.PingContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+type testConnector struct {
+	d *testDriver
+}
+
+func (c testConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.d.Open("")
+}
+
+func (c testConnector) Driver() driver.Driver {
+	return c.d
+}
+
+func TestRegisterDBCloser(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	db := openTestDB(t, &testDriver{})
+	RegisterDBCloser(db, Stage2)
+	if db.Ping() != nil {
+		t.Fatal("database closed before shutdown")
+	}
+	Shutdown()
+	if err := db.Ping(); err == nil {
+		t.Fatal("database not closed")
+	}
+}
+
+func TestRegisterDBCloserTimeout(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeout(100 * time.Millisecond)
+	lines, restore := logLines()
+	defer restore()
+	d := &testDriver{closeConn: make(chan struct{})}
+	defer close(d.closeConn)
+	RegisterDBCloser(openTestDB(t, d), Stage1)
+	Shutdown()
+	nextLine(t, lines, "Timeout closing database")
+
+	expectPanic(t, "shutdown: nil database", func() {
+		RegisterDBCloser(nil, Stage1)
+	})
+}
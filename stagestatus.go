@@ -0,0 +1,135 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StageState is the state of a stage in the running or last shutdown, see StageStatuses.
+type StageState string
+
+const (
+	StagePending  StageState = "pending"  // The stage hasn't started.
+	StageRunning  StageState = "running"  // The stage has been signalled, and is waited for.
+	StageDone     StageState = "done"     // The stage has completed.
+	StageTimedOut StageState = "timedout" // The stage has completed, but timed out.
+	StageSkipped  StageState = "skipped"  // The stage was skipped, see SetStagePredicate.
+)
+
+// StageStatus describes a stage, see StageStatuses.
+type StageStatus struct {
+	Stage         int           // The stage, see WithGracefulDegradation.
+	Name          string        // The name of the stage, like "first".
+	Registrations int           // Notifiers and functions registered, see RegistrationCount.
+	State         StageState    // The state in the running or last shutdown.
+	Start         time.Time     // When the stage started, zero if it hasn't.
+	End           time.Time     // When the stage completed, zero if it hasn't.
+	Timeout       time.Duration // The effective timeout, see EffectiveTimeout.
+}
+
+// stageProgress is the state of a stage in the running or last shutdown.
+type stageProgress struct {
+	state      StageState
+	start, end time.Time
+}
+
+// StageStatuses returns the status of all stages, in the order they are run.
+//
+// This is meant for dashboards and other user interfaces, which can show
+// the state of the shutdown with a single call. The statuses are taken
+// together, so they are consistent with each other. Before shutdown, and
+// after Reset, all stages are pending. After shutdown, the states are those
+// of the last shutdown, and registrations have been released.
+func StageStatuses() []StageStatus {
+	return defaultManager.StageStatuses()
+}
+
+// StageStatuses returns the status of all stages of the manager, in the order they are run.
+func (m *Manager) StageStatuses() []StageStatus {
+	m.sqM.Lock()
+	defer m.sqM.Unlock()
+	m.srM.RLock()
+	defer m.srM.RUnlock()
+	s := make([]StageStatus, 0, numStages)
+	for _, stage := range m.order {
+		p := m.progress[stage]
+		if p.state == "" {
+			p.state = StagePending
+		}
+		s = append(s, StageStatus{
+			Stage:         stage,
+			Name:          stageName(stage),
+			Registrations: len(m.shutdownQueue[stage]),
+			State:         p.state,
+			Start:         p.start,
+			End:           p.end,
+			Timeout:       m.effectiveTimeout(stage),
+		})
+	}
+	return s
+}
+
+// setStageState sets the state of a stage of the running shutdown.
+func (m *Manager) setStageState(stage int, state StageState) {
+	now := m.clock.Now()
+	m.srM.Lock()
+	defer m.srM.Unlock()
+	p := &m.progress[stage]
+	p.state = state
+	if state == StageRunning {
+		p.start = now
+		m.emitStageState(stage, state, 0)
+		return
+	}
+	ran := !p.start.IsZero()
+	if !ran {
+		p.start = now
+	}
+	p.end = now
+	if ran || state == StageSkipped {
+		// Stages with nothing to wait for are not reported.
+		m.emitStageState(stage, state, p.end.Sub(p.start))
+	}
+}
+
+// CompletedStages returns the stages that have completed in the running
+// or last shutdown, in the order they were run. A stage has completed when
+// all its notifiers have finished, or it timed out. Stages that were
+// skipped are not included, see SetStagePredicate.
+// During shutdown, stages are added as they complete.
+func CompletedStages() []int {
+	return defaultManager.CompletedStages()
+}
+
+// CompletedStages returns the stages of the manager that have completed in the running or last shutdown.
+func (m *Manager) CompletedStages() []int {
+	m.srM.RLock()
+	defer m.srM.RUnlock()
+	var stages []int
+	for _, stage := range m.order {
+		switch m.progress[stage].state {
+		case StageDone, StageTimedOut:
+			stages = append(stages, stage)
+		}
+	}
+	return stages
+}
+
+// String returns a short description of the state of the manager, like
+//
+//	Manager{stages:5, started:false, locks:0, notifiers:[1, 0, 2, 0, 0]}
+//
+// where notifiers contains the number of registrations of each stage,
+// indexed by stage, see StageStatuses.
+func (m *Manager) String() string {
+	counts := make([]string, numStages)
+	for _, s := range m.StageStatuses() {
+		counts[s.Stage] = strconv.Itoa(s.Registrations)
+	}
+	st := m.Stats()
+	return fmt.Sprintf("Manager{stages:%d, started:%t, locks:%d, notifiers:[%s]}", numStages, st.Started, st.Locks, strings.Join(counts, ", "))
+}
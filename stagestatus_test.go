@@ -0,0 +1,117 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStageStatuses(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeoutN(Stage3, 200*time.Millisecond)
+	FirstFunc(func(interface{}) {}, nil)
+	running := make(chan struct{})
+	release := make(chan struct{})
+	SecondFunc(func(interface{}) {
+		close(running)
+		<-release
+	}, nil)
+	Third()
+
+	s := StageStatuses()
+	if len(s) != numStages {
+		t.Fatal("unexpected number of stages", len(s))
+	}
+	for _, st := range s {
+		if st.State != StagePending || !st.Start.IsZero() {
+			t.Fatalf("stage %s: unexpected status before shutdown: %+v", st.Name, st)
+		}
+	}
+	if s[4].Name != "third" || s[4].Registrations != 1 || s[4].Timeout != 200*time.Millisecond {
+		t.Fatalf("unexpected status of third stage: %+v", s[4])
+	}
+
+	done := make(chan struct{})
+	go func() {
+		Shutdown()
+		close(done)
+	}()
+	<-running
+	s = StageStatuses()
+	want := []StageState{StageDone, StageDone, StageDone, StageRunning, StagePending}
+	for i, st := range s {
+		if st.State != want[i] {
+			t.Fatalf("stage %s: got %s, want %s", st.Name, st.State, want[i])
+		}
+	}
+	if second := s[3]; second.Start.IsZero() || !second.End.IsZero() || second.Registrations != 1 {
+		t.Fatalf("unexpected status of running stage: %+v", second)
+	}
+	if first := s[2]; first.End.Before(first.Start) || first.End.After(s[3].Start) {
+		t.Fatalf("unexpected times of first stage: %+v", first)
+	}
+	close(release)
+	<-done
+
+	// The third notifier was never handled.
+	if s := StageStatuses(); s[3].State != StageDone || s[4].State != StageTimedOut {
+		t.Fatalf("unexpected statuses after shutdown: %+v", s)
+	}
+	Reset()
+	if s := StageStatuses(); s[4].State != StagePending || !s[4].End.IsZero() {
+		t.Fatalf("status not reset: %+v", s[4])
+	}
+}
+
+func TestCompletedStages(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	if s := CompletedStages(); len(s) != 0 {
+		t.Fatal("stages completed before shutdown", s)
+	}
+	SetStagePredicate(Stage3, func(Reason) bool { return false })
+	running := make(chan struct{})
+	release := make(chan struct{})
+	FirstFunc(func(interface{}) {}, nil)
+	SecondFunc(func(interface{}) {
+		close(running)
+		<-release
+	}, nil)
+	ThirdFunc(func(interface{}) {}, nil)
+
+	done := make(chan struct{})
+	go func() {
+		Shutdown()
+		close(done)
+	}()
+	<-running
+	if s := fmt.Sprint(CompletedStages()); s != "[0 4 1]" {
+		t.Fatal("unexpected stages while running the second stage", s)
+	}
+	close(release)
+	<-done
+	// The third stage was skipped.
+	if s := fmt.Sprint(CompletedStages()); s != "[0 4 1 2]" {
+		t.Fatal("unexpected stages after shutdown", s)
+	}
+}
+
+func TestManagerString(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	m := NewManager()
+	m.First()
+	m.SecondFunc(func(interface{}) {}, nil)
+	m.Second()
+	if !m.Lock() {
+		t.Fatal("lock failed")
+	}
+	want := "Manager{stages:5, started:false, locks:1, notifiers:[0, 1, 2, 0, 0]}"
+	if got := fmt.Sprint(m); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	m.Unlock()
+}
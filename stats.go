@@ -0,0 +1,174 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"os"
+	"time"
+)
+
+// Reason describes what started a shutdown.
+type Reason struct {
+	Cause  string    // Description of the cause.
+	Signal os.Signal // The signal that started the shutdown, if any.
+	Time   time.Time // When the shutdown was started.
+	Stack  string    // Stack of the goroutine that started the shutdown.
+}
+
+// ShutdownStats contains statistics about the shutdown of a manager.
+type ShutdownStats struct {
+	// Started is true if shutdown has been started.
+	Started bool
+
+	// Reason is the reason of the first trigger of the shutdown.
+	// It is only valid if Started is true.
+	Reason Reason
+
+	// Coalesced is the number of times shutdown was triggered,
+	// after it had already been started.
+	Coalesced int
+
+	// Locks is the number of locks currently held.
+	Locks int
+
+	// TimeoutsDisabled is true if timeouts are disabled, see SetTimeoutsDisabled.
+	TimeoutsDisabled bool
+}
+
+// Summary describes a completed shutdown.
+type Summary struct {
+	// Reason is the reason the shutdown was started.
+	Reason Reason
+
+	// Duration is the time the shutdown took.
+	Duration time.Duration
+
+	// Stages contains the stages that were run, in the order they were run.
+	Stages []StageSummary
+
+	// TimedOut is true if any stage timed out.
+	TimedOut bool
+
+	// Panics is the number of shutdown functions that panicked.
+	Panics int
+
+	// Callbacks contains the functions given to the interceptor,
+	// in the order they returned, see SetCallbackInterceptor.
+	Callbacks []CallbackSummary
+
+	// Channels contains the channels drained by DrainChannel,
+	// in the order they were drained.
+	Channels []ChannelSummary
+}
+
+// StageSummary describes a stage of a completed shutdown.
+type StageSummary struct {
+	Stage    int           // The stage, see WithGracefulDegradation.
+	Duration time.Duration // The time the stage took.
+	TimedOut bool          // True if the stage timed out.
+	Skipped  bool          // True if the stage was skipped, see SetStagePredicate.
+}
+
+// LastSummary returns a summary of the most recently completed shutdown.
+//
+// The summary is kept when Reset is called, until the next
+// shutdown has completed. If no shutdown has completed,
+// the zero value is returned.
+func LastSummary() Summary {
+	return defaultManager.LastSummary()
+}
+
+// LastSummary returns a summary of the most recently completed shutdown of the manager.
+func (m *Manager) LastSummary() Summary {
+	m.srM.RLock()
+	defer m.srM.RUnlock()
+	return m.last.copy()
+}
+
+// summarize stores the summary of the completed shutdown.
+func (m *Manager) summarize(stages []StageSummary) {
+	m.srM.Lock()
+	defer m.srM.Unlock()
+	s := Summary{
+		Reason:   m.reason,
+		Duration: m.clock.Mono() - m.startedMono,
+		Stages:   stages,
+		Panics:   m.panics,
+	}
+	if m.callbacks != nil {
+		s.Callbacks = append([]CallbackSummary(nil), m.callbacks...)
+	}
+	s.Channels = m.channelSummaries()
+	for _, st := range stages {
+		if st.TimedOut {
+			s.TimedOut = true
+		}
+	}
+	m.last = s
+	m.addHistory(s)
+	m.emitSummary(s)
+}
+
+// Stats returns statistics about the shutdown.
+func Stats() ShutdownStats {
+	return defaultManager.Stats()
+}
+
+// Stats returns statistics about the shutdown of the manager.
+func (m *Manager) Stats() ShutdownStats {
+	m.srM.RLock()
+	defer m.srM.RUnlock()
+	return ShutdownStats{
+		Started:          m.shutdownRequested,
+		Reason:           m.reason,
+		Coalesced:        m.coalesced,
+		Locks:            m.locks.held(),
+		TimeoutsDisabled: m.timeoutsDisabled,
+	}
+}
+
+// RegistrationCount returns the number of notifiers and functions
+// registered in the given stage.
+func RegistrationCount(s Stage) int {
+	return defaultManager.RegistrationCount(s)
+}
+
+// RegistrationCount returns the number of notifiers and functions registered in the given stage of the manager.
+func (m *Manager) RegistrationCount(s Stage) int {
+	m.sqM.Lock()
+	defer m.sqM.Unlock()
+	return len(m.shutdownQueue[s.n])
+}
+
+// HasRegistrations returns true if any notifiers or functions
+// are registered in the given stage.
+func HasRegistrations(s Stage) bool {
+	return defaultManager.HasRegistrations(s)
+}
+
+// HasRegistrations returns true if any notifiers or functions are registered in the given stage of the manager.
+func (m *Manager) HasRegistrations(s Stage) bool {
+	return m.RegistrationCount(s) > 0
+}
+
+// SetShutdownDebounce sets a duration after the first shutdown trigger in which
+// further triggers are ignored silently. After that they are logged.
+// In all cases repeated triggers will only wait for the running shutdown.
+// Triggers that exit, like Exit and OnSignal, don't call the exit function
+// again within the duration, see SetExitFunc.
+// A negative duration is treated as 0 with a warning.
+func SetShutdownDebounce(d time.Duration) {
+	defaultManager.SetShutdownDebounce(d)
+}
+
+// SetShutdownDebounce sets a duration after the first shutdown trigger of the manager
+// in which further triggers are ignored silently.
+func (m *Manager) SetShutdownDebounce(d time.Duration) {
+	if d < 0 {
+		Logger.Printf("SetShutdownDebounce: negative duration %v, using 0", d)
+		d = 0
+	}
+	m.srM.Lock()
+	m.debounce = d
+	m.srM.Unlock()
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCoalesce(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var calls int
+	var mu sync.Mutex
+	_ = FirstFunc(func(interface{}) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+	}, nil)
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			Shutdown()
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if calls != 1 {
+		t.Fatal("shutdown function called", calls, "times")
+	}
+	st := Stats()
+	if !st.Started {
+		t.Fatal("shutdown not marked started")
+	}
+	if st.Coalesced != 19 {
+		t.Fatal("expected 19 coalesced triggers, got", st.Coalesced)
+	}
+	if st.Reason.Cause != "Shutdown called" || !strings.Contains(st.Reason.Stack, "TestCoalesce") {
+		t.Fatal("unexpected reason", st.Reason)
+	}
+}
+
+// A repeated trigger must wait for the running shutdown.
+func TestCoalesceWaits(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	finished := make(chan struct{})
+	_ = FirstFunc(func(interface{}) {
+		time.Sleep(100 * time.Millisecond)
+		close(finished)
+	}, nil)
+	go Shutdown()
+	for !Started() {
+		time.Sleep(time.Millisecond)
+	}
+	Shutdown()
+	select {
+	case <-finished:
+	default:
+		t.Fatal("repeated shutdown returned before shutdown was complete")
+	}
+}
+
+func TestShutdownDebounce(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	c := newFakeClock()
+	defaultManager.clock = c
+	SetShutdownDebounce(time.Second)
+	lines, restore := logLines()
+	defer restore()
+
+	Shutdown()
+	Shutdown()
+	c.Advance(time.Second)
+	defaultManager.shutdown(Reason{Cause: "late trigger"})
+	l := nextLine(t, lines, "ignoring")
+	if !strings.Contains(l, "ignoring: late trigger") {
+		t.Fatal("unexpected log line", l)
+	}
+	if n := Stats().Coalesced; n != 2 {
+		t.Fatal("expected 2 coalesced triggers, got", n)
+	}
+}
+
+func TestShutdownDebounceExit(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	c := newFakeClock()
+	defaultManager.clock = c
+	SetShutdownDebounce(time.Second)
+	var exits []int
+	SetExitFunc(func(code int) { exits = append(exits, code) })
+	SetExitFlushDelay(0)
+
+	Exit(1)
+	Exit(2)
+	if len(exits) != 1 || exits[0] != 1 {
+		t.Fatal("exit function not called once within the debounce window", exits)
+	}
+	c.Advance(time.Second)
+	Exit(3)
+	if len(exits) != 2 || exits[1] != 3 {
+		t.Fatal("exit function not called after the debounce window", exits)
+	}
+}
+
+func TestStatsLocks(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	Lock()
+	Lock()
+	if n := Stats().Locks; n != 2 {
+		t.Fatal("expected 2 locks, got", n)
+	}
+	Unlock()
+	Unlock()
+	if n := Stats().Locks; n != 0 {
+		t.Fatal("expected 0 locks, got", n)
+	}
+}
+
+func TestNegativeDebounce(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	lines, restore := logLines()
+	defer restore()
+	SetShutdownDebounce(-time.Second)
+	nextLine(t, lines, "SetShutdownDebounce: negative duration -1s, using 0")
+	if d := defaultManager.debounce; d != 0 {
+		t.Fatal("unexpected debounce", d)
+	}
+}
+
+func TestRegistrations(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	_ = First()
+	n := FirstFunc(func(interface{}) {}, nil)
+	if !HasRegistrations(Stage1) {
+		t.Fatal("expected registrations in first stage")
+	}
+	if HasRegistrations(Stage2) {
+		t.Fatal("expected no registrations in second stage")
+	}
+	if c := RegistrationCount(Stage1); c != 2 {
+		t.Fatal("expected 2 registrations, got", c)
+	}
+	n.Cancel()
+	if c := RegistrationCount(Stage1); c != 1 {
+		t.Fatal("expected 1 registration after cancel, got", c)
+	}
+}
+
+func TestLastSummary(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeout(100 * time.Millisecond)
+	if s := LastSummary(); len(s.Stages) != 0 || s.TimedOut {
+		t.Fatal("summary before shutdown", s)
+	}
+	f := Second()
+	go func() {
+		<-f
+		// Never finish
+	}()
+	_ = FirstFunc(func(interface{}) { panic("This is expected") }, nil)
+	Shutdown()
+
+	s := LastSummary()
+	if s.Reason.Cause != "Shutdown called" {
+		t.Fatal("unexpected reason", s.Reason)
+	}
+	if !s.TimedOut || s.Panics != 1 {
+		t.Fatal("unexpected summary", s)
+	}
+	// The preshutdown stage always runs, since it waits for locks.
+	if len(s.Stages) != 3 || s.Stages[0].Stage != 0 || s.Stages[1].Stage != 1 || s.Stages[2].Stage != 2 {
+		t.Fatal("unexpected stages", s.Stages)
+	}
+	if s.Stages[1].TimedOut || !s.Stages[2].TimedOut {
+		t.Fatal("unexpected stage timeouts", s.Stages)
+	}
+	if s.Duration < s.Stages[2].Duration || s.Stages[2].Duration < 100*time.Millisecond {
+		t.Fatal("unexpected durations", s.Duration, s.Stages)
+	}
+}
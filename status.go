@@ -0,0 +1,97 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// shutdownStatus is the state written by WriteStatus.
+type shutdownStatus struct {
+	Started          bool              `json:"started"`
+	Completed        bool              `json:"completed"`
+	Stage            string            `json:"stage,omitempty"`
+	Pending          map[string]int    `json:"pending"`
+	Locks            int               `json:"locks"`
+	Timeouts         map[string]string `json:"timeouts"`
+	TimeoutsDisabled bool              `json:"timeouts_disabled"`
+	MaxDuration      string            `json:"max_duration"`
+	Last             *statusSummary    `json:"last,omitempty"`
+}
+
+// statusSummary is the summary of the last shutdown written by WriteStatus.
+type statusSummary struct {
+	Reason   string        `json:"reason"`
+	Duration string        `json:"duration"`
+	TimedOut bool          `json:"timed_out"`
+	Panics   int           `json:"panics"`
+	Stages   []statusStage `json:"stages"`
+}
+
+type statusStage struct {
+	Stage    string `json:"stage"`
+	Duration string `json:"duration"`
+	TimedOut bool   `json:"timed_out"`
+	Skipped  bool   `json:"skipped"`
+}
+
+// WriteStatus writes the state of the shutdown to w as JSON.
+//
+// The state is collected when it is written, and contains whether shutdown
+// has started, the running stage, the number of notifiers in each stage,
+// the locks held, the timeouts, and a summary of the last shutdown.
+// This is the body of ReadyzHandler. To publish it with the expvar
+// package, see the expvar subpackage.
+func WriteStatus(w io.Writer) error {
+	return defaultManager.WriteStatus(w)
+}
+
+// WriteStatus writes the state of the shutdown of the manager to w as JSON.
+func (m *Manager) WriteStatus(w io.Writer) error {
+	return json.NewEncoder(w).Encode(m.status())
+}
+
+// status returns the current state of the manager.
+func (m *Manager) status() shutdownStatus {
+	s := shutdownStatus{
+		Pending:  make(map[string]int, numStages),
+		Timeouts: make(map[string]string, numStages),
+	}
+	s.MaxDuration = m.EstimateMaxDuration().String()
+	m.sqM.Lock()
+	for stage := range m.shutdownQueue {
+		s.Pending[stageName(stage)] = len(m.shutdownQueue[stage])
+	}
+	m.sqM.Unlock()
+
+	m.srM.RLock()
+	defer m.srM.RUnlock()
+	s.Started = m.shutdownRequested
+	select {
+	case <-m.done:
+		s.Completed = true
+	default:
+	}
+	if m.shutdownRequested && !s.Completed && m.current >= 0 {
+		s.Stage = stageName(m.order[m.current])
+	}
+	s.Locks = m.locks.held()
+	for stage := 0; stage < numStages; stage++ {
+		s.Timeouts[stageName(stage)] = m.effectiveTimeout(stage).String()
+	}
+	s.TimeoutsDisabled = m.timeoutsDisabled
+	if m.last.Stages != nil {
+		last := &statusSummary{
+			Reason:   m.last.Reason.Cause,
+			Duration: m.last.Duration.String(),
+			TimedOut: m.last.TimedOut,
+			Panics:   m.last.Panics,
+		}
+		for _, st := range m.last.Stages {
+			last.Stages = append(last.Stages, statusStage{Stage: stageName(st.Stage), Duration: st.Duration.String(), TimedOut: st.TimedOut, Skipped: st.Skipped})
+		}
+		s.Last = last
+	}
+	return s
+}
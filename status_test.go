@@ -0,0 +1,52 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// readStatus returns the state written by WriteStatus.
+func readStatus(t *testing.T) shutdownStatus {
+	var buf bytes.Buffer
+	if err := WriteStatus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var s shutdownStatus
+	if err := json.Unmarshal(buf.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestWriteStatus(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	SetTimeoutN(Stage2, 2*time.Second)
+	_ = First()
+	_ = SecondFunc(setBool, new(bool))
+
+	s := readStatus(t)
+	if s.Started || s.Stage != "" || s.Last != nil {
+		t.Fatal("unexpected state before shutdown", s)
+	}
+	if s.Pending["first"] != 1 || s.Pending["second"] != 1 || s.Pending["third"] != 0 {
+		t.Fatal("unexpected pending", s.Pending)
+	}
+	if s.Timeouts["first"] != "1s" || s.Timeouts["second"] != "2s" {
+		t.Fatal("unexpected timeouts", s.Timeouts)
+	}
+
+	SetTimeout(100 * time.Millisecond)
+	Shutdown()
+	s = readStatus(t)
+	if !s.Started || !s.Completed || s.Last == nil {
+		t.Fatal("unexpected state after shutdown", s)
+	}
+	if !s.Last.TimedOut || s.Last.Reason != "Shutdown called" || len(s.Last.Stages) != 3 || s.Last.Stages[1].Stage != "first" {
+		t.Fatal("unexpected last shutdown", s.Last)
+	}
+}
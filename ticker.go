@@ -0,0 +1,181 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"sync"
+	"time"
+)
+
+// A ShutdownTicker is a time.Ticker that is stopped when shutdown starts.
+//
+// Unlike a time.Ticker, the channel is closed when the ticker is stopped,
+// either by calling Stop or when shutdown starts, so goroutines
+// ranging over it return.
+type ShutdownTicker struct {
+	C <-chan time.Time // The channel on which the ticks are delivered.
+
+	t    *time.Ticker
+	n    Notifier
+	stop chan struct{}
+	once sync.Once
+}
+
+// Ticker returns a new ShutdownTicker, which ticks with a period of d.
+// It is stopped when the Preshutdown stage starts.
+// If the manager is disabled, see Disable, it is only stopped by Stop.
+func Ticker(d time.Duration) *ShutdownTicker {
+	return defaultManager.Ticker(d)
+}
+
+// Ticker returns a new ShutdownTicker, which is stopped when shutdown of the manager starts.
+func (m *Manager) Ticker(d time.Duration) *ShutdownTicker {
+	c := make(chan time.Time, 1)
+	t := &ShutdownTicker{
+		C:    c,
+		t:    time.NewTicker(d),
+		n:    m.PreShutdown(),
+		stop: make(chan struct{}),
+	}
+	if m.isDisabled() && !m.isClosed() {
+		// t.n is spent, and shutdown never starts, so wait for Stop only.
+		t.n = nil
+		go t.run(c)
+		return t
+	}
+	if !m.addWatcher() {
+		// Closed, so t.n is spent, and run returns right away.
+		t.run(c)
+		return t
+	}
+	go func() {
+		defer m.watchers.Done()
+		t.run(c)
+	}()
+	return t
+}
+
+// run forwards ticks until the ticker is stopped.
+func (t *ShutdownTicker) run(c chan time.Time) {
+	defer close(c)
+	defer t.t.Stop()
+	for {
+		select {
+		case tm := <-t.t.C:
+			// Drop ticks for slow receivers, like time.Ticker.
+			select {
+			case c <- tm:
+			default:
+			}
+		case v := <-t.n:
+			if v != nil {
+				close(v)
+			}
+			return
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// Stop turns off the ticker, and closes the channel.
+// It can be called more than once.
+func (t *ShutdownTicker) Stop() {
+	t.once.Do(func() {
+		close(t.stop)
+		t.n.Cancel()
+	})
+}
+
+// A ShutdownTimer is a time.Timer that is stopped when shutdown starts.
+//
+// When shutdown starts, the channel is closed, so code waiting for
+// the timer is unblocked. Otherwise it works like a time.Timer.
+type ShutdownTimer struct {
+	C <-chan time.Time // The channel on which the time is delivered.
+
+	m *Manager
+	c chan time.Time
+
+	mu     sync.Mutex // Mutex for below
+	t      *time.Timer
+	n      Notifier // The Preshutdown registration, nil while stopped.
+	closed bool
+}
+
+// Timer returns a new ShutdownTimer, that will send the current time
+// on its channel after at least d.
+// It is stopped when the Preshutdown stage starts.
+func Timer(d time.Duration) *ShutdownTimer {
+	return defaultManager.Timer(d)
+}
+
+// Timer returns a new ShutdownTimer, which is stopped when shutdown of the manager starts.
+func (m *Manager) Timer(d time.Duration) *ShutdownTimer {
+	t := &ShutdownTimer{m: m, c: make(chan time.Time, 1)}
+	t.C = t.c
+	t.mu.Lock()
+	t.t = time.AfterFunc(d, t.fire)
+	t.register()
+	t.mu.Unlock()
+	return t
+}
+
+// fire sends the current time, unless the channel is closed.
+func (t *ShutdownTimer) fire() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	select {
+	case t.c <- time.Now():
+	default:
+	}
+}
+
+// register closes the channel when shutdown starts.
+// t.mu must be held.
+func (t *ShutdownTimer) register() {
+	t.n = t.m.PreShutdownFunc(func(interface{}) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.closed = true
+		t.t.Stop()
+		close(t.c)
+	}, nil)
+}
+
+// Stop prevents the timer from firing, like time.Timer.Stop.
+// It returns true if the call stops the timer, and false if the
+// timer has already expired, been stopped, or shutdown has started.
+// The channel is not closed.
+func (t *ShutdownTimer) Stop() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	active := t.t.Stop()
+	if t.n != nil {
+		n := t.n
+		t.n = nil
+		// No longer registered, until the timer is reset.
+		n.Cancel()
+	}
+	return active && !t.closed
+}
+
+// Reset changes the timer to expire after d, like time.Timer.Reset.
+// It returns true if the timer had been active, false if the timer had
+// expired or been stopped. If shutdown has started, it does nothing
+// and returns false.
+func (t *ShutdownTimer) Reset(d time.Duration) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return false
+	}
+	active := t.t.Reset(d)
+	if t.n == nil {
+		t.register()
+	}
+	return active
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTicker(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	tk := Ticker(10 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if _, ok := <-tk.C; !ok {
+			t.Fatal("ticker channel closed")
+		}
+	}
+	tk.Stop()
+	tk.Stop()
+	for range tk.C {
+	}
+	if HasRegistrations(Preshutdown) {
+		t.Fatal("stopped ticker still registered")
+	}
+}
+
+func TestTickerDisabled(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	if err := Disable(); err != nil {
+		t.Fatal(err)
+	}
+	tk := Ticker(10 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if _, ok := <-tk.C; !ok {
+			t.Fatal("ticker of disabled manager stopped")
+		}
+	}
+	tk.Stop()
+	for range tk.C {
+	}
+}
+
+func TestTickerShutdown(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	tk := Ticker(time.Hour)
+	returned := make(chan struct{})
+	go func() {
+		for range tk.C {
+		}
+		close(returned)
+	}()
+	Shutdown()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("ticker channel not closed on shutdown")
+	}
+	tk.Stop()
+}
+
+func TestTimer(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	tm := Timer(10 * time.Millisecond)
+	if _, ok := <-tm.C; !ok {
+		t.Fatal("timer channel closed")
+	}
+	if tm.Reset(10 * time.Millisecond) {
+		t.Fatal("expired timer reported as active")
+	}
+	<-tm.C
+	tm.Reset(time.Hour)
+	if !tm.Stop() {
+		t.Fatal("active timer reported as stopped")
+	}
+	if HasRegistrations(Preshutdown) {
+		t.Fatal("stopped timer still registered")
+	}
+	if tm.Reset(time.Hour) {
+		t.Fatal("stopped timer reported as active")
+	}
+	if !HasRegistrations(Preshutdown) {
+		t.Fatal("reset timer not registered")
+	}
+}
+
+func TestTimerShutdown(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	tm := Timer(time.Hour)
+	returned := make(chan struct{})
+	go func() {
+		<-tm.C
+		close(returned)
+	}()
+	Shutdown()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("timer channel not closed on shutdown")
+	}
+	if _, ok := <-tm.C; ok {
+		t.Fatal("timer channel not closed")
+	}
+	if tm.Reset(time.Millisecond) || tm.Stop() {
+		t.Fatal("timer active after shutdown")
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNoTrace is returned by WriteTrace when no shutdown has completed.
+var ErrNoTrace = errors.New("shutdown: no completed shutdown to trace")
+
+// shutdownTrace is the timeline of the last completed shutdown.
+type shutdownTrace struct {
+	start   time.Duration // Monotonic time the shutdown started.
+	reason  string
+	stages  []StageSummary
+	starts  []time.Duration // Monotonic start of each stage.
+	timings []callbackTime
+}
+
+// traceEvent is an event in the Chrome trace event format.
+type traceEvent struct {
+	Name string            `json:"name"`
+	Cat  string            `json:"cat,omitempty"`
+	Ph   string            `json:"ph"`
+	Ts   float64           `json:"ts"`
+	Dur  float64           `json:"dur,omitempty"`
+	Pid  int               `json:"pid"`
+	Tid  int               `json:"tid"`
+	Args map[string]string `json:"args,omitempty"`
+}
+
+// WriteTrace writes a timeline of the last completed shutdown to w,
+// which can be loaded in chrome://tracing or https://ui.perfetto.dev.
+//
+// The timeline is written in the Chrome trace event format: a JSON object
+// with a "traceEvents" array of complete ("X") events. Times are in
+// microseconds from the start of the shutdown. Stages are on thread 0
+// with category "stage", and each notifier is on its own thread with
+// category "notifier". Notifiers are named like in the log, and have a
+// "finished" argument, which is "false" if the stage stopped waiting for it.
+// Stages have a "timed_out" or "skipped" argument when that happened.
+// If no shutdown has completed, ErrNoTrace is returned.
+func WriteTrace(w io.Writer) error {
+	return defaultManager.WriteTrace(w)
+}
+
+// WriteTrace writes a timeline of the last completed shutdown of the manager to w.
+func (m *Manager) WriteTrace(w io.Writer) error {
+	m.srM.RLock()
+	t := m.trace
+	m.srM.RUnlock()
+	if t == nil {
+		return ErrNoTrace
+	}
+	micros := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Microsecond)
+	}
+	// Name the process after the reason of the shutdown.
+	events := []traceEvent{{
+		Name: "process_name",
+		Ph:   "M",
+		Pid:  1,
+		Args: map[string]string{"name": "shutdown: " + t.reason},
+	}}
+	for i, s := range t.stages {
+		ev := traceEvent{
+			Name: stageName(s.Stage),
+			Cat:  "stage",
+			Ph:   "X",
+			Ts:   micros(t.starts[i] - t.start),
+			Dur:  micros(s.Duration),
+			Pid:  1,
+		}
+		switch {
+		case s.Skipped:
+			ev.Args = map[string]string{"skipped": "true"}
+		case s.TimedOut:
+			ev.Args = map[string]string{"timed_out": "true"}
+		}
+		events = append(events, ev)
+	}
+	for i, c := range t.timings {
+		finished := "true"
+		if !c.finished {
+			finished = "false"
+		}
+		events = append(events, traceEvent{
+			Name: c.label,
+			Cat:  "notifier",
+			Ph:   "X",
+			Ts:   micros(c.start - t.start),
+			Dur:  micros(c.d),
+			Pid:  1,
+			Tid:  i + 1,
+			Args: map[string]string{"stage": stageName(c.stage), "finished": finished},
+		})
+	}
+	return json.NewEncoder(w).Encode(struct {
+		TraceEvents     []traceEvent `json:"traceEvents"`
+		DisplayTimeUnit string       `json:"displayTimeUnit"`
+	}{TraceEvents: events, DisplayTimeUnit: "ms"})
+}
+
+// saveTrace keeps the timeline of the shutdown that has just completed.
+func (m *Manager) saveTrace(stages []StageSummary, starts []time.Duration) {
+	m.srM.Lock()
+	defer m.srM.Unlock()
+	m.trace = &shutdownTrace{
+		start:   m.startedMono,
+		reason:  m.reason.Cause,
+		stages:  stages,
+		starts:  starts,
+		timings: append([]callbackTime(nil), m.timings...),
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWriteTrace(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var buf bytes.Buffer
+	if err := WriteTrace(&buf); err != ErrNoTrace {
+		t.Fatal("unexpected error", err)
+	}
+	slow := SecondFunc(func(interface{}) {
+		time.Sleep(200 * time.Millisecond)
+	}, nil)
+	id := slow.ID()
+	_ = FirstFunc(func(interface{}) {}, nil)
+	Shutdown()
+	if err := WriteTrace(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var trace struct {
+		TraceEvents []struct {
+			Name string            `json:"name"`
+			Cat  string            `json:"cat"`
+			Ph   string            `json:"ph"`
+			Ts   float64           `json:"ts"`
+			Dur  float64           `json:"dur"`
+			Tid  int               `json:"tid"`
+			Args map[string]string `json:"args"`
+		} `json:"traceEvents"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &trace); err != nil {
+		t.Fatal(err, buf.String())
+	}
+	var found, second bool
+	var stageEnd float64
+	for _, ev := range trace.TraceEvents {
+		switch {
+		case ev.Cat == "stage" && ev.Name == "first":
+			stageEnd = ev.Ts + ev.Dur
+		case ev.Cat == "stage" && ev.Name == "second":
+			second = true
+			if ev.Ts < stageEnd || ev.Dur < 200e3 {
+				t.Fatal("unexpected stage event", ev)
+			}
+		case ev.Name == fmt.Sprintf("notifier id %d", id):
+			found = true
+			if ev.Ph != "X" || ev.Cat != "notifier" || ev.Args["stage"] != "second" || ev.Args["finished"] != "true" {
+				t.Fatal("unexpected notifier event", ev)
+			}
+			if ev.Dur < 200e3 || ev.Dur > 900e3 {
+				t.Fatal("unexpected duration", ev.Dur)
+			}
+		}
+	}
+	if !found || !second {
+		t.Fatal("events missing from trace", buf.String())
+	}
+}
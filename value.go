@@ -0,0 +1,75 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrAlreadyCalled is returned by Notifier.SetValue
+// if the function has already been called.
+var ErrAlreadyCalled = errors.New("shutdown: function has already been called")
+
+// ErrNotFunction is returned by Notifier.SetValue if the notifier
+// is not a registered shutdown function. This is also the case when
+// it has been cancelled, or shutdown has completed.
+var ErrNotFunction = errors.New("shutdown: notifier is not a registered function")
+
+// fnValue is the value given to a shutdown function.
+type fnValue struct {
+	mu     sync.Mutex
+	v      interface{}
+	called bool
+}
+
+// take returns the value, and marks it as used.
+func (f *fnValue) take() interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.called = true
+	return f.v
+}
+
+// set replaces the value, unless it has been used.
+func (f *fnValue) set(v interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.called {
+		return ErrAlreadyCalled
+	}
+	f.v = v
+	return nil
+}
+
+// setFnValue records the value of the function notifier n.
+func setFnValue(n Notifier, val *fnValue) {
+	nM.Lock()
+	defer nM.Unlock()
+	if ns := notifiers[n]; ns != nil {
+		ns.value = val
+	}
+}
+
+// SetValue replaces the value that is given to a shutdown function,
+// like FirstFunc, when it is called.
+//
+// This allows the value to be updated, for instance when a connection
+// is replaced, without cancelling and registering the function again.
+// The function is called with either the old or the new value.
+// If the function has already been called, ErrAlreadyCalled is returned
+// and the function keeps the value it was called with.
+// If s is not a registered function, ErrNotFunction is returned.
+func (s Notifier) SetValue(v interface{}) error {
+	nM.Lock()
+	ns := notifiers[s]
+	var val *fnValue
+	if ns != nil && !ns.cancelled {
+		val = ns.value
+	}
+	nM.Unlock()
+	if val == nil {
+		return ErrNotFunction
+	}
+	return val.set(v)
+}
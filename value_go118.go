@@ -0,0 +1,35 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package shutdown
+
+// TypedNotifier is the notifier of a function registered with StageFunc.
+type TypedNotifier[T any] struct {
+	Notifier
+}
+
+// SetValue replaces the value that is given to the function when it is called.
+// See Notifier.SetValue.
+func (n TypedNotifier[T]) SetValue(v T) error {
+	return n.Notifier.SetValue(v)
+}
+
+// StageFunc registers a function in the given stage, which is called with v.
+// Unlike FirstFunc and the other functions, the value is typed.
+func StageFunc[T any](s Stage, fn func(T), v T) TypedNotifier[T] {
+	return StageFuncOf(defaultManager, s, fn, v)
+}
+
+// StageFuncOf is like StageFunc, but the function is executed by the given manager.
+func StageFuncOf[T any](m *Manager, s Stage, fn func(T), v T) TypedNotifier[T] {
+	if fn == nil {
+		panic("shutdown: nil shutdown function")
+	}
+	return TypedNotifier[T]{m.onFunc(s.n, func(v interface{}) {
+		// v is nil if T is an interface type and the value is nil.
+		t, _ := v.(T)
+		fn(t)
+	}, v)}
+}
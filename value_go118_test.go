@@ -0,0 +1,26 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package shutdown
+
+import (
+	"testing"
+)
+
+func TestStageFunc(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var got int
+	n := StageFunc(Stage2, func(v int) { got = v }, 1)
+	if err := n.SetValue(2); err != nil {
+		t.Fatal(err)
+	}
+	var gotErr error
+	StageFunc(Stage3, func(err error) { gotErr = err }, nil)
+	Shutdown()
+	if got != 2 || gotErr != nil {
+		t.Fatal("unexpected values", got, gotErr)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestSetValue(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var got interface{}
+	n := FirstFunc(func(v interface{}) { got = v }, "old")
+	if err := n.SetValue("new"); err != nil {
+		t.Fatal(err)
+	}
+	var errLater error
+	ThirdFunc(func(interface{}) {
+		errLater = n.SetValue("later")
+	}, nil)
+	if err := First().SetValue("x"); err != ErrNotFunction {
+		t.Fatal("unexpected error for channel notifier", err)
+	}
+	c := SecondFunc(func(interface{}) {}, nil)
+	c.Cancel()
+	if err := c.SetValue("x"); err != ErrNotFunction {
+		t.Fatal("unexpected error for cancelled function", err)
+	}
+	Shutdown()
+	if got != "new" {
+		t.Fatal("unexpected value", got)
+	}
+	if errLater != ErrAlreadyCalled {
+		t.Fatal("unexpected error after function was called", errLater)
+	}
+	if err := n.SetValue("after"); err == nil {
+		t.Fatal("value set after shutdown")
+	}
+}
+
+func TestSetValueConcurrent(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		reset()
+		stop := startTimer(t)
+		var got interface{}
+		calls := 0
+		n := ThirdFunc(func(v interface{}) {
+			got = v
+			calls++
+		}, -1)
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		written := map[interface{}]bool{-1: true}
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; ; i++ {
+					v := g*1000000 + i
+					mu.Lock()
+					written[v] = true
+					mu.Unlock()
+					if n.SetValue(v) != nil {
+						return
+					}
+				}
+			}(g)
+		}
+		Shutdown()
+		wg.Wait()
+		if calls != 1 || !written[got] {
+			t.Fatal("unexpected value", calls, got)
+		}
+		close(stop)
+	}
+}
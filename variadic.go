@@ -0,0 +1,74 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+// ShutdownFnV is a shutdown function that is given several parameters.
+type ShutdownFnV func(params ...interface{})
+
+// PreShutdownFuncV registers a function that will be called as soon as the shutdown
+// is signalled, before locks are released.
+// See FirstFuncV for details.
+func PreShutdownFuncV(fn ShutdownFnV, params ...interface{}) Notifier {
+	return defaultManager.PreShutdownFuncV(fn, params...)
+}
+
+// PreShutdownFuncV registers a variadic function that is called when shutdown of the manager is signalled.
+func (m *Manager) PreShutdownFuncV(fn ShutdownFnV, params ...interface{}) Notifier {
+	return m.onFuncV(0, fn, params)
+}
+
+// FirstFuncV executes a function in the first stage of the shutdown.
+// The function is called with the given parameters, so they don't have
+// to be packed into a single value:
+//
+//	shutdown.FirstFuncV(func(p ...interface{}) {
+//	    flush(p[0].(*Cache), p[1].(*DB))
+//	}, cache, db)
+//
+// If the parameters are replaced with Notifier.SetValue, the value should
+// be a []interface{} with the new parameters. Any other value is passed
+// as the only parameter.
+func FirstFuncV(fn ShutdownFnV, params ...interface{}) Notifier {
+	return defaultManager.FirstFuncV(fn, params...)
+}
+
+// FirstFuncV executes a variadic function in the first stage of the shutdown of the manager.
+func (m *Manager) FirstFuncV(fn ShutdownFnV, params ...interface{}) Notifier {
+	return m.onFuncV(1, fn, params)
+}
+
+// SecondFuncV executes a function in the second stage of the shutdown.
+// See FirstFuncV for details.
+func SecondFuncV(fn ShutdownFnV, params ...interface{}) Notifier {
+	return defaultManager.SecondFuncV(fn, params...)
+}
+
+// SecondFuncV executes a variadic function in the second stage of the shutdown of the manager.
+func (m *Manager) SecondFuncV(fn ShutdownFnV, params ...interface{}) Notifier {
+	return m.onFuncV(2, fn, params)
+}
+
+// ThirdFuncV executes a function in the third stage of the shutdown.
+// See FirstFuncV for details.
+func ThirdFuncV(fn ShutdownFnV, params ...interface{}) Notifier {
+	return defaultManager.ThirdFuncV(fn, params...)
+}
+
+// ThirdFuncV executes a variadic function in the third stage of the shutdown of the manager.
+func (m *Manager) ThirdFuncV(fn ShutdownFnV, params ...interface{}) Notifier {
+	return m.onFuncV(3, fn, params)
+}
+
+// Create a function notifier, that is called with the parameters.
+func (m *Manager) onFuncV(prio int, fn ShutdownFnV, params []interface{}) Notifier {
+	if fn == nil {
+		panic("shutdown: nil shutdown function")
+	}
+	return m.onFunc(prio, func(v interface{}) {
+		params, ok := v.([]interface{})
+		if !ok {
+			params = []interface{}{v}
+		}
+		fn(params...)
+	}, params)
+}
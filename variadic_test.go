@@ -0,0 +1,44 @@
+// Copyright (c) 2015 Klaus Post, released under MIT License. See LICENSE file.
+
+package shutdown
+
+import (
+	"testing"
+)
+
+func TestFuncV(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	var got []interface{}
+	SecondFuncV(func(params ...interface{}) {
+		got = params
+	}, "a", 2, nil)
+	var none, replaced []interface{}
+	_ = FirstFuncV(func(params ...interface{}) {
+		none = params
+	})
+	f := ThirdFuncV(func(params ...interface{}) {
+		replaced = params
+	}, "old")
+	if err := f.SetValue("new"); err != nil {
+		t.Fatal(err)
+	}
+	Shutdown()
+	if len(got) != 3 || got[0] != "a" || got[1] != 2 || got[2] != nil {
+		t.Fatal("unexpected parameters", got)
+	}
+	if len(none) != 0 {
+		t.Fatal("unexpected parameters", none)
+	}
+	if len(replaced) != 1 || replaced[0] != "new" {
+		t.Fatal("unexpected parameters", replaced)
+	}
+}
+
+func TestNilFuncV(t *testing.T) {
+	reset()
+	defer close(startTimer(t))
+	expectPanic(t, "shutdown: nil shutdown function", func() {
+		SecondFuncV(nil, 1, 2)
+	})
+}